@@ -0,0 +1,230 @@
+package hmacsig_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/auth/hmacsig"
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/interceptors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const method = "/partner.v1.Orders/Create"
+
+var secret = []byte("s3cret")
+
+func newVerifier(t *testing.T, now func() time.Time) *hmacsig.Verifier {
+	t.Helper()
+	v, err := hmacsig.NewVerifier(hmacsig.Config{
+		Keys:   map[string][]byte{"partner-a": secret},
+		Nonces: hmacsig.NewMemoryNonces(),
+		Now:    now,
+	})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	return v
+}
+
+func signed(t *testing.T, s *hmacsig.Signer, msg any) metadata.MD {
+	t.Helper()
+	md, err := s.Sign(method, msg)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return md
+}
+
+func codeOf(err error) codes.Code {
+	st, _ := status.FromError(err)
+	return st.Code()
+}
+
+func TestVerify_ValidSignature(t *testing.T) {
+	v := newVerifier(t, nil)
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{KeyID: "partner-a", Secret: secret})
+	msg := wrapperspb.String("order-1")
+
+	ctx, err := v.Verify(t.Context(), method, signed(t, s, msg), msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, ok := contextx.ActorFromContext(ctx)
+	if !ok || a.ClientID != "partner-a" {
+		t.Fatalf("expected actor with ClientID partner-a, got %+v (ok=%v)", a, ok)
+	}
+}
+
+func TestVerify_TamperedMessage(t *testing.T) {
+	v := newVerifier(t, nil)
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{KeyID: "partner-a", Secret: secret})
+
+	md := signed(t, s, wrapperspb.String("order-1"))
+	_, err := v.Verify(t.Context(), method, md, wrapperspb.String("order-2"))
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestVerify_WrongMethod(t *testing.T) {
+	v := newVerifier(t, nil)
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{KeyID: "partner-a", Secret: secret})
+	msg := wrapperspb.String("order-1")
+
+	_, err := v.Verify(t.Context(), "/partner.v1.Orders/Delete", signed(t, s, msg), msg)
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestVerify_UnknownKey(t *testing.T) {
+	v := newVerifier(t, nil)
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{KeyID: "partner-b", Secret: secret})
+	msg := wrapperspb.String("order-1")
+
+	_, err := v.Verify(t.Context(), method, signed(t, s, msg), msg)
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestVerify_StaleTimestamp(t *testing.T) {
+	serverNow := time.Now()
+	v := newVerifier(t, func() time.Time { return serverNow })
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{
+		KeyID:  "partner-a",
+		Secret: secret,
+		Now:    func() time.Time { return serverNow.Add(-10 * time.Minute) },
+	})
+	msg := wrapperspb.String("order-1")
+
+	_, err := v.Verify(t.Context(), method, signed(t, s, msg), msg)
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestVerify_ReplayRejected(t *testing.T) {
+	v := newVerifier(t, nil)
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{KeyID: "partner-a", Secret: secret})
+	msg := wrapperspb.String("order-1")
+	md := signed(t, s, msg)
+
+	if _, err := v.Verify(t.Context(), method, md, msg); err != nil {
+		t.Fatalf("first request: unexpected error: %v", err)
+	}
+	_, err := v.Verify(t.Context(), method, md, msg)
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+}
+
+func TestVerify_OfflineNonceStoreRejects(t *testing.T) {
+	l2 := cache.NewL2("localhost:1", "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+	v, err := hmacsig.NewVerifier(hmacsig.Config{
+		Keys:   map[string][]byte{"partner-a": secret},
+		Nonces: l2,
+	})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{KeyID: "partner-a", Secret: secret})
+	msg := wrapperspb.String("order-1")
+
+	ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+	defer cancel()
+	// Without the store a replay cannot be detected, so even a first,
+	// correctly signed request must not pass.
+	if _, err := v.Verify(ctx, method, signed(t, s, msg), msg); codeOf(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable with Redis down, got %v", err)
+	}
+}
+
+func TestVerify_MissingHeaders(t *testing.T) {
+	v := newVerifier(t, nil)
+	_, err := v.Verify(t.Context(), method, metadata.MD{}, nil)
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestNewVerifier_RequiresNonceStore(t *testing.T) {
+	_, err := hmacsig.NewVerifier(hmacsig.Config{Keys: map[string][]byte{"k": secret}})
+	if err == nil {
+		t.Fatal("expected error without nonce store")
+	}
+}
+
+func TestSignatureUnary_RoundTripThroughClientInterceptor(t *testing.T) {
+	v := newVerifier(t, nil)
+	s := hmacsig.NewSigner(hmacsig.SignerConfig{KeyID: "partner-a", Secret: secret})
+	server := interceptors.SignatureUnary(v)
+	msg := wrapperspb.String("order-1")
+
+	// The fake invoker feeds the outgoing metadata into the server interceptor.
+	invoker := func(ctx context.Context, m string, req, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		srvCtx := metadata.NewIncomingContext(t.Context(), md)
+		_, err := server(srvCtx, req, &grpc.UnaryServerInfo{FullMethod: m}, func(ctx context.Context, _ any) (any, error) {
+			if _, ok := contextx.ActorFromContext(ctx); !ok {
+				t.Fatal("expected actor in handler context")
+			}
+			return "ok", nil
+		})
+		return err
+	}
+
+	err := s.UnaryClientInterceptor()(t.Context(), method, msg, nil, nil, invoker)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSignatureUnary_OptionalPassesUnsignedRequests(t *testing.T) {
+	v := newVerifier(t, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	var called bool
+	handler := func(ctx context.Context, _ any) (any, error) {
+		called = true
+		if _, ok := contextx.ActorFromContext(ctx); ok {
+			t.Fatal("unsigned request must not carry an actor")
+		}
+		return "ok", nil
+	}
+
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs("authorization", "Bearer token"))
+	if _, err := interceptors.SignatureUnary(v, interceptors.SignatureOptional())(ctx, nil, info, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Fatal("handler was not called")
+	}
+
+	// A partially signed request is still verified.
+	ctx = metadata.NewIncomingContext(t.Context(), metadata.Pairs(hmacsig.HeaderKeyID, "partner-a"))
+	_, err := interceptors.SignatureUnary(v, interceptors.SignatureOptional())(ctx, nil, info, handler)
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestMemoryNonces_ClaimOnce(t *testing.T) {
+	m := hmacsig.NewMemoryNonces()
+	if ok, _ := m.Claim(t.Context(), "k", time.Minute); !ok {
+		t.Fatal("first claim should succeed")
+	}
+	if ok, _ := m.Claim(t.Context(), "k", time.Minute); ok {
+		t.Fatal("second claim should fail")
+	}
+	if ok, _ := m.Claim(t.Context(), "other", time.Minute); !ok {
+		t.Fatal("claim of a different key should succeed")
+	}
+}
@@ -0,0 +1,58 @@
+package hmacsig
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/cache"
+)
+
+var _ NonceStore = (*cache.L2)(nil)
+
+// NonceStore records nonces for replay detection. Claim marks key as taken
+// for ttl and reports whether this call was the first to do so; it must
+// return an error rather than false when it cannot tell. A store must keep
+// every claimed key until its ttl has passed — an evicting cache would let a
+// replay through. [cache.L2] implements NonceStore and shares nonces across
+// replicas; [MemoryNonces] suits a single replica.
+type NonceStore interface {
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// MemoryNonces is an in-process [NonceStore]. Unlike the L1 cache it never
+// evicts an entry before it expires, so memory grows with the number of
+// signed requests inside the replay window. It is safe for concurrent use.
+type MemoryNonces struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryNonces creates an empty in-process nonce store.
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{seen: make(map[string]time.Time), now: time.Now}
+}
+
+// Claim implements [NonceStore]. It never returns an error.
+func (m *MemoryNonces) Claim(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !now.Before(m.nextSweep) {
+		// Drop expired entries at most once per ttl so that the sweep cost is
+		// amortized over every claim made in between.
+		for k, exp := range m.seen {
+			if !now.Before(exp) {
+				delete(m.seen, k)
+			}
+		}
+		m.nextSweep = now.Add(ttl)
+	}
+	if exp, ok := m.seen[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	m.seen[key] = now.Add(ttl)
+	return true, nil
+}
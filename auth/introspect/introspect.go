@@ -0,0 +1,301 @@
+// Package introspect provides an authenticator that validates opaque OAuth2
+// access tokens against an RFC 7662 token introspection endpoint.
+//
+// Results for active tokens are cached until the token's exp claim (capped by
+// Config.MaxCacheTTL) so that the identity provider is not queried on every
+// request; inactive results are cached briefly so that replaying a bad token
+// does not either. The HTTP call is wrapped with the optional [breaker.Breaker] and
+// [retry.Do] helpers; transport errors and 5xx/429 responses surface as
+// codes.Unavailable so they can be retried.
+package introspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/breaker"
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults applied by [New] when the corresponding Config field is zero.
+const (
+	defaultTenantClaim = "tenant"
+	defaultMaxCacheTTL = time.Minute
+	defaultInactiveTTL = 5 * time.Second
+)
+
+// Errors are allocated once to avoid per-request allocations on the hot path.
+var (
+	errMissingToken = status.Error(codes.Unauthenticated, "missing bearer token")
+	errInactive     = status.Error(codes.Unauthenticated, "token is not active")
+	errBreakerOpen  = status.Error(codes.Unavailable, "token introspection unavailable")
+)
+
+// Config holds the introspection endpoint and client settings.
+type Config struct {
+	// Endpoint is the URL of the introspection endpoint.
+	Endpoint string
+
+	// ClientID and ClientSecret authenticate this resource server at the
+	// endpoint using HTTP Basic authentication.
+	ClientID     string
+	ClientSecret string
+
+	// HTTPClient performs the request. Defaults to a client with a
+	// five-second timeout.
+	HTTPClient *http.Client
+
+	// TenantClaim is the response member mapped to Actor.Tenant.
+	// Defaults to "tenant".
+	TenantClaim string
+
+	// Cache stores introspection results keyed by a SHA-256 digest of the
+	// token. When nil every request is introspected.
+	Cache cache.Cache
+
+	// MaxCacheTTL caps how long a result is cached, even if the token's exp
+	// lies further in the future. Defaults to one minute.
+	MaxCacheTTL time.Duration
+
+	// InactiveCacheTTL is how long an inactive result is cached, capped by
+	// MaxCacheTTL. A token never becomes active again, so caching only
+	// bounds the load a client retrying a bad token puts on the endpoint.
+	// Defaults to five seconds; a negative value disables it.
+	InactiveCacheTTL time.Duration
+
+	// Breaker, when set, short-circuits introspection with
+	// codes.Unavailable while the endpoint is failing.
+	Breaker *breaker.Breaker
+
+	// Retry controls retries of failed introspection calls. The zero value
+	// performs a single attempt. Retryable failures carry
+	// codes.Unavailable.
+	Retry retry.Config
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Result is the subset of an introspection response used by the
+// authenticator.
+type Result struct {
+	Active   bool   `json:"active"`
+	Subject  string `json:"sub,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	Exp      int64  `json:"exp,omitempty"`
+}
+
+// Actor maps r onto a [contextx.Actor]. Scope is split on spaces.
+func (r *Result) Actor() contextx.Actor {
+	return contextx.Actor{
+		Subject:  r.Subject,
+		Tenant:   r.Tenant,
+		ClientID: r.ClientID,
+		Scopes:   strings.Fields(r.Scope),
+	}
+}
+
+// Authenticator validates bearer tokens via token introspection. It is safe
+// for concurrent use.
+type Authenticator struct {
+	cfg Config
+}
+
+// New creates an Authenticator from cfg. It returns an error when the
+// endpoint is missing or not an absolute URL.
+func New(cfg Config) (*Authenticator, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("introspect: invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	if cfg.TenantClaim == "" {
+		cfg.TenantClaim = defaultTenantClaim
+	}
+	if cfg.MaxCacheTTL <= 0 {
+		cfg.MaxCacheTTL = defaultMaxCacheTTL
+	}
+	if cfg.InactiveCacheTTL == 0 {
+		cfg.InactiveCacheTTL = defaultInactiveTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Authenticator{cfg: cfg}, nil
+}
+
+// Authenticate has the [auth.AuthFunc] signature and can be passed directly
+// to WithAuth. It reads the bearer token from the "authorization" metadata
+// key, introspects it, and stores the resulting Actor in the context.
+func (a *Authenticator) Authenticate(ctx context.Context, _ string, md metadata.MD) (context.Context, error) {
+	token := bearerToken(md)
+	if token == "" {
+		return ctx, errMissingToken
+	}
+	res, err := a.Introspect(ctx, token)
+	if err != nil {
+		return ctx, err
+	}
+	if !res.Active {
+		return ctx, errInactive
+	}
+	return contextx.WithActor(ctx, res.Actor()), nil
+}
+
+// Introspect returns the introspection result for token, serving it from the
+// cache when possible. Cached results whose exp has passed are reported as
+// inactive.
+func (a *Authenticator) Introspect(ctx context.Context, token string) (*Result, error) {
+	key := cacheKey(token)
+	if a.cfg.Cache != nil {
+		if raw, ok, _ := a.cfg.Cache.Get(ctx, key); ok {
+			var res Result
+			if json.Unmarshal(raw, &res) == nil {
+				if res.Exp != 0 && a.cfg.Now().Unix() >= res.Exp {
+					res.Active = false
+				}
+				return &res, nil
+			}
+		}
+	}
+
+	res, err := retry.Do(ctx, a.cfg.Retry, func(ctx context.Context) (*Result, error) {
+		return a.call(ctx, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cfg.Cache != nil {
+		if ttl := a.cacheTTL(res); ttl > 0 {
+			if raw, err := json.Marshal(res); err == nil {
+				_ = a.cfg.Cache.Set(ctx, key, raw, ttl)
+			}
+		}
+	}
+	return res, nil
+}
+
+// cacheTTL returns how long res may be cached: until exp, capped by
+// MaxCacheTTL, or InactiveCacheTTL if res is inactive.
+func (a *Authenticator) cacheTTL(res *Result) time.Duration {
+	ttl := a.cfg.MaxCacheTTL
+	if !res.Active {
+		return min(ttl, a.cfg.InactiveCacheTTL)
+	}
+	if res.Exp != 0 {
+		ttl = min(ttl, time.Unix(res.Exp, 0).Sub(a.cfg.Now()))
+	}
+	return ttl
+}
+
+// call performs a single introspection request, consulting the breaker. A
+// request abandoned by its caller says nothing about the endpoint and is not
+// recorded.
+func (a *Authenticator) call(ctx context.Context, token string) (*Result, error) {
+	b := a.cfg.Breaker
+	if b != nil && !b.Allow() {
+		return nil, errBreakerOpen
+	}
+	res, err := a.post(ctx, token)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if b != nil {
+		if status.Code(err) == codes.Unavailable {
+			b.OnFailure()
+		} else {
+			b.OnSuccess()
+		}
+	}
+	return res, err
+}
+
+// post sends the introspection request and decodes the response.
+func (a *Authenticator) post(ctx context.Context, token string) (*Result, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "introspect: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(a.cfg.ClientSecret))
+	}
+
+	resp, err := a.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "introspect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, status.Errorf(codes.Unavailable, "introspect: endpoint returned %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, status.Errorf(codes.Internal, "introspect: endpoint returned %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "introspect: %v", err)
+	}
+	return a.decode(body)
+}
+
+// decode parses an introspection response, reading the tenant from the
+// configured claim.
+func (a *Authenticator) decode(body []byte) (*Result, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, status.Errorf(codes.Internal, "introspect: malformed response: %v", err)
+	}
+	res := &Result{}
+	res.Active, _ = raw["active"].(bool)
+	res.Subject, _ = raw["sub"].(string)
+	res.Scope, _ = raw["scope"].(string)
+	res.ClientID, _ = raw["client_id"].(string)
+	res.Tenant, _ = raw[a.cfg.TenantClaim].(string)
+	if exp, ok := raw["exp"].(float64); ok {
+		res.Exp = int64(exp)
+	}
+	return res, nil
+}
+
+// bearerToken extracts the token from the "authorization" metadata key.
+func bearerToken(md metadata.MD) string {
+	vals := md.Get("authorization")
+	if len(vals) == 0 {
+		return ""
+	}
+	scheme, token, ok := strings.Cut(vals[0], " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// cacheKey derives a cache key that does not expose the raw token.
+func cacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "introspect:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,249 @@
+package introspect
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/breaker"
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeIdP returns an httptest server that answers introspection requests with
+// resp and counts the calls it receives.
+func fakeIdP(t *testing.T, statusCode int, resp map[string]any) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if id, secret, ok := r.BasicAuth(); !ok || id != "rs" || secret != "pw" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("token") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(statusCode)
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func newAuth(t *testing.T, cfg Config) *Authenticator {
+	t.Helper()
+	cfg.ClientID, cfg.ClientSecret = "rs", "pw"
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return a
+}
+
+func bearer(token string) metadata.MD {
+	return metadata.Pairs("authorization", "Bearer "+token)
+}
+
+func TestAuthenticate_ActiveTokenMapsActor(t *testing.T) {
+	srv, _ := fakeIdP(t, http.StatusOK, map[string]any{
+		"active":    true,
+		"sub":       "user-7",
+		"scope":     "read write",
+		"client_id": "web",
+		"org":       "acme",
+	})
+	a := newAuth(t, Config{Endpoint: srv.URL, TenantClaim: "org"})
+
+	ctx, err := a.Authenticate(t.Context(), "/svc/M", bearer("tok"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actor, ok := contextx.ActorFromContext(ctx)
+	if !ok {
+		t.Fatal("expected actor in context")
+	}
+	if actor.Subject != "user-7" || actor.ClientID != "web" || actor.Tenant != "acme" {
+		t.Fatalf("unexpected actor: %+v", actor)
+	}
+	if !slices.Equal(actor.Scopes, []string{"read", "write"}) {
+		t.Fatalf("unexpected scopes: %v", actor.Scopes)
+	}
+}
+
+func TestAuthenticate_InactiveToken(t *testing.T) {
+	srv, _ := fakeIdP(t, http.StatusOK, map[string]any{"active": false})
+	a := newAuth(t, Config{Endpoint: srv.URL})
+
+	_, err := a.Authenticate(t.Context(), "/svc/M", bearer("tok"))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestAuthenticate_MissingToken(t *testing.T) {
+	srv, calls := fakeIdP(t, http.StatusOK, map[string]any{"active": true})
+	a := newAuth(t, Config{Endpoint: srv.URL})
+
+	_, err := a.Authenticate(t.Context(), "/svc/M", metadata.MD{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+	if calls.Load() != 0 {
+		t.Fatal("endpoint should not be called without a token")
+	}
+}
+
+func TestIntrospect_CachesUntilExp(t *testing.T) {
+	now := time.Now()
+	srv, calls := fakeIdP(t, http.StatusOK, map[string]any{
+		"active": true,
+		"sub":    "user-7",
+		"exp":    now.Add(30 * time.Second).Unix(),
+	})
+	l1, err := cache.NewL1(100)
+	if err != nil {
+		t.Fatalf("NewL1: %v", err)
+	}
+	a := newAuth(t, Config{
+		Endpoint:    srv.URL,
+		Cache:       l1,
+		MaxCacheTTL: time.Hour,
+		Now:         func() time.Time { return now },
+	})
+
+	for range 3 {
+		if _, err := a.Authenticate(t.Context(), "/svc/M", bearer("tok")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected 1 introspection call, got %d", n)
+	}
+
+	// Once exp has passed the cached result must be treated as inactive.
+	now = now.Add(time.Minute)
+	_, err = a.Authenticate(t.Context(), "/svc/M", bearer("tok"))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated after exp, got %v", err)
+	}
+}
+
+func TestIntrospect_CachesInactiveBriefly(t *testing.T) {
+	now := time.Now()
+	srv, calls := fakeIdP(t, http.StatusOK, map[string]any{"active": false})
+	l1, err := cache.NewL1(100)
+	if err != nil {
+		t.Fatalf("NewL1: %v", err)
+	}
+	a := newAuth(t, Config{
+		Endpoint:         srv.URL,
+		Cache:            l1,
+		InactiveCacheTTL: 50 * time.Millisecond,
+		Now:              func() time.Time { return now },
+	})
+
+	for range 3 {
+		_, err := a.Authenticate(t.Context(), "/svc/M", bearer("bad"))
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("expected Unauthenticated, got %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected 1 introspection call, got %d", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	_, _ = a.Authenticate(t.Context(), "/svc/M", bearer("bad"))
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected the inactive result to expire, got %d calls", n)
+	}
+}
+
+func TestIntrospect_CanceledCallerDoesNotTripBreaker(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	b := breaker.New(breaker.Config{FailureThreshold: 1, OpenTimeout: time.Hour, HalfOpenMaxSuccess: 1})
+	a := newAuth(t, Config{Endpoint: srv.URL, Breaker: b})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := a.Authenticate(ctx, "/svc/M", bearer("tok"))
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled, got %v", err)
+	}
+	if st := b.State(); st != breaker.Closed {
+		t.Fatalf("breaker state = %v, want closed", st)
+	}
+}
+
+func TestIntrospect_RetriesUnavailable(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"active": true, "sub": "u"})
+	}))
+	t.Cleanup(srv.Close)
+
+	a := newAuth(t, Config{
+		Endpoint: srv.URL,
+		Retry: retry.Config{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    5 * time.Millisecond,
+			RetryCodes:  []codes.Code{codes.Unavailable},
+		},
+	})
+
+	if _, err := a.Authenticate(t.Context(), "/svc/M", bearer("tok")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("expected 3 calls, got %d", n)
+	}
+}
+
+func TestIntrospect_BreakerOpens(t *testing.T) {
+	srv, calls := fakeIdP(t, http.StatusBadGateway, map[string]any{})
+	a := newAuth(t, Config{
+		Endpoint: srv.URL,
+		Breaker: breaker.New(breaker.Config{
+			FailureThreshold:   2,
+			OpenTimeout:        time.Hour,
+			HalfOpenMaxSuccess: 1,
+		}),
+	})
+
+	for range 4 {
+		_, err := a.Authenticate(t.Context(), "/svc/M", bearer("tok"))
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected Unavailable, got %v", err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected breaker to stop calls after 2 failures, got %d calls", n)
+	}
+}
+
+func TestNew_InvalidEndpoint(t *testing.T) {
+	if _, err := New(Config{Endpoint: "not a url"}); err == nil {
+		t.Fatal("expected error for invalid endpoint")
+	}
+}
@@ -3,14 +3,15 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// L2 is a Redis-backed cache layer. All operations fail soft: if Redis is
-// unavailable, methods return a miss (or silently discard the write) instead
-// of surfacing the error to the caller.
+// L2 is a Redis-backed cache layer. All operations except Claim fail soft: if
+// Redis is unavailable, they return a miss (or silently discard the write)
+// instead of surfacing the error to the caller.
 type L2 struct {
 	rdb *redis.Client
 }
@@ -46,6 +47,75 @@ func (l *L2) Set(ctx context.Context, key string, val []byte, ttl time.Duration)
 	return nil
 }
 
+// GetOrSet returns the value stored under key. On a miss it calls loader,
+// stores the result and returns it. Redis errors are treated as a miss and a
+// discarded write (fail soft), so the loader result is returned as-is.
+func (l *L2) GetOrSet(ctx context.Context, key string, ttl time.Duration, loader func(context.Context) ([]byte, error)) ([]byte, error) {
+	if v, ok, _ := l.Get(ctx, key); ok {
+		return v, nil
+	}
+	val, err := loader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_ = l.Set(ctx, key, val, ttl)
+	return val, nil
+}
+
+// Claim atomically marks key as taken for ttl and reports whether this call
+// was the first to do so. Unlike the other methods it does not fail soft:
+// when Redis cannot be reached the error is returned, because the caller
+// cannot tell whether the key was already taken.
+func (l *L2) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ok, err := l.rdb.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("cache: claim %q: %w", key, err)
+	}
+	return ok, nil
+}
+
+// Publish sends msg to channel. Errors are silently discarded (fail soft).
+func (l *L2) Publish(ctx context.Context, channel string, msg []byte) error {
+	_ = l.rdb.Publish(ctx, channel, msg).Err()
+	return nil
+}
+
+// Subscribe delivers messages published to channel until ctx is done, at
+// which point the returned channel is closed. The subscription reconnects
+// automatically after connection loss; messages published while
+// disconnected are lost.
+func (l *L2) Subscribe(ctx context.Context, channel string) <-chan []byte {
+	ps := l.rdb.Subscribe(ctx, channel)
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer ps.Close()
+		in := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- []byte(m.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Client returns the underlying Redis client so that other components, such
+// as the distributed rate limiter, can share the connection pool.
+func (l *L2) Client() *redis.Client {
+	return l.rdb
+}
+
 // Ping checks the Redis connection.
 func (l *L2) Ping(ctx context.Context) error {
 	return l.rdb.Ping(ctx).Err()
@@ -128,3 +128,57 @@ func TestL2_FailSoft(t *testing.T) {
 		t.Fatalf("expected nil error on unreachable Redis, got: %v", err)
 	}
 }
+
+func TestL2_GetOrSet_FailSoft(t *testing.T) {
+	l2 := NewL2("localhost:1", "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+	defer cancel()
+
+	v, err := l2.GetOrSet(ctx, "k", time.Second, func(context.Context) ([]byte, error) {
+		return []byte("loaded"), nil
+	})
+	if err != nil {
+		t.Fatalf("expected nil error on unreachable Redis, got: %v", err)
+	}
+	if string(v) != "loaded" {
+		t.Fatalf("got %q, want %q", v, "loaded")
+	}
+}
+
+func TestL2_Claim_FirstCallerWins(t *testing.T) {
+	l2 := redisL2(t)
+	ctx := t.Context()
+
+	key := "test:l2:claim:" + t.Name()
+	t.Cleanup(func() { _ = l2.Client().Del(context.Background(), key).Err() })
+
+	ok, err := l2.Claim(ctx, key, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Claim 1: %v", err)
+	}
+	if !ok {
+		t.Fatal("first Claim should succeed")
+	}
+	ok, err = l2.Claim(ctx, key, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Claim 2: %v", err)
+	}
+	if ok {
+		t.Fatal("second Claim should fail")
+	}
+}
+
+func TestL2_Claim_ReportsUnreachableRedis(t *testing.T) {
+	l2 := NewL2("localhost:1", "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+	defer cancel()
+
+	// The caller cannot know whether the key was taken, so it gets an error.
+	if ok, err := l2.Claim(ctx, "k", time.Second); err == nil {
+		t.Fatalf("expected an error on unreachable Redis, got %v", ok)
+	}
+}
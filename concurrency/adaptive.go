@@ -0,0 +1,279 @@
+package concurrency
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+)
+
+// Sample is the outcome of one request, fed to an [Algorithm].
+type Sample struct {
+	// RTT is the time the request held its slot.
+	RTT time.Duration
+	// InFlight is the number of requests in flight when it started,
+	// including itself.
+	InFlight int
+	// Dropped reports that the request failed in a way that indicates
+	// overload (e.g. a deadline was exceeded).
+	Dropped bool
+}
+
+// Algorithm computes a new concurrency limit from the current one and a
+// request sample. Calls are serialized by [Adaptive], so implementations
+// need not be safe for concurrent use. The result is clamped to the
+// adaptive limiter's bounds.
+type Algorithm interface {
+	Update(limit int, s Sample) int
+}
+
+// AIMD grows the limit by one for every successful request that used at
+// least half of it, and multiplies it by Backoff on every dropped request or
+// request slower than Timeout.
+type AIMD struct {
+	// Backoff is the factor applied on a drop. Default 0.9.
+	Backoff float64
+	// Timeout marks slower requests as dropped. Zero disables the check.
+	Timeout time.Duration
+}
+
+// Update implements [Algorithm].
+func (a *AIMD) Update(limit int, s Sample) int {
+	if s.Dropped || (a.Timeout > 0 && s.RTT > a.Timeout) {
+		backoff := a.Backoff
+		if backoff <= 0 || backoff >= 1 {
+			backoff = 0.9
+		}
+		return int(float64(limit) * backoff)
+	}
+	if s.InFlight*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Gradient2 compares a short-term with a long-term (exponentially averaged)
+// latency. While the two agree the limit grows by a queue allowance of
+// sqrt(limit); when short-term latency rises above Tolerance times the
+// long-term one, the limit shrinks proportionally. It follows Netflix's
+// concurrency-limits algorithm of the same name.
+type Gradient2 struct {
+	// Tolerance is the latency increase accepted before shrinking.
+	// Default 1.5.
+	Tolerance float64
+	// Smoothing weights new limit estimates. Default 0.2.
+	Smoothing float64
+	// LongWindow is the number of samples averaged into the long-term
+	// latency. Default 600.
+	LongWindow int
+
+	long     float64 // long-term RTT in nanoseconds, 0 until the first sample
+	estimate float64
+}
+
+// Update implements [Algorithm].
+func (g *Gradient2) Update(limit int, s Sample) int {
+	tolerance := cmpOr(g.Tolerance, 1.5)
+	smoothing := cmpOr(g.Smoothing, 0.2)
+	window := float64(cmpOr(g.LongWindow, 600))
+	// Start from, and follow clamping of, the limit actually in force.
+	if int(g.estimate) != limit {
+		g.estimate = float64(limit)
+	}
+
+	short := float64(s.RTT)
+	if short <= 0 {
+		return limit
+	}
+	if g.long == 0 {
+		g.long = short
+	} else {
+		g.long += (short - g.long) / window
+	}
+	// After a latency spike recover quickly to the new baseline.
+	if g.long/short > 2 {
+		g.long *= 0.95
+	}
+
+	// Don't grow while the limit is not the bottleneck.
+	if s.InFlight < int(g.estimate)/2 && !s.Dropped {
+		return int(g.estimate)
+	}
+
+	gradient := math.Max(0.5, math.Min(1, tolerance*g.long/short))
+	if s.Dropped {
+		gradient = 0.5
+	}
+	next := g.estimate*gradient + math.Sqrt(g.estimate)
+	g.estimate = g.estimate*(1-smoothing) + next*smoothing
+	return int(g.estimate)
+}
+
+// Vegas estimates the queue built up at the server from the ratio of the
+// lowest observed latency to the current one and keeps it between Alpha
+// and Beta requests: the limit grows by one below Alpha and shrinks by one
+// above Beta.
+type Vegas struct {
+	// Alpha is the queue size below which the limit grows. Default 3.
+	Alpha int
+	// Beta is the queue size above which the limit shrinks. Default 6.
+	Beta int
+
+	minRTT time.Duration
+}
+
+// Update implements [Algorithm].
+func (v *Vegas) Update(limit int, s Sample) int {
+	if s.RTT <= 0 {
+		return limit
+	}
+	if v.minRTT == 0 || s.RTT < v.minRTT {
+		v.minRTT = s.RTT
+	}
+	if s.Dropped {
+		return limit - 1
+	}
+	queue := int(math.Ceil(float64(limit) * (1 - float64(v.minRTT)/float64(s.RTT))))
+	switch {
+	case queue < cmpOr(v.Alpha, 3):
+		return limit + 1
+	case queue > cmpOr(v.Beta, 6):
+		return limit - 1
+	}
+	return limit
+}
+
+// cmpOr returns v, or def if v is not positive.
+func cmpOr[T int | float64](v, def T) T {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// AdaptiveConfig holds the parameters of an [Adaptive] limiter.
+type AdaptiveConfig struct {
+	// Algorithm adjusts the limit. Defaults to [AIMD].
+	Algorithm Algorithm
+	// Initial is the starting limit. Defaults to Min.
+	Initial int
+	// Min and Max bound the limit. Min defaults to 1, Max to 1000.
+	Min, Max int
+	// Queue and QueueTimeout configure the wait queue as in [Config].
+	Queue        int
+	QueueTimeout time.Duration
+	// OnChange is passed to the underlying [Limiter].
+	OnChange func(inFlight, queued int)
+	// OnLimit, if set, is called with the new limit, scaled by
+	// [Adaptive.SetScale], whenever it changes.
+	OnLimit func(limit int)
+}
+
+// Adaptive is a [Limiter] whose limit is adjusted by an [Algorithm] from the
+// latency and outcome of completed requests.
+type Adaptive struct {
+	lim *Limiter
+	now func() time.Time
+
+	mu      sync.Mutex
+	alg     Algorithm
+	min     int
+	max     int
+	limit   int
+	scale   float64 // set by SetScale
+	onLimit func(int)
+}
+
+// NewAdaptive creates an Adaptive limiter.
+func NewAdaptive(cfg AdaptiveConfig) *Adaptive {
+	if cfg.Algorithm == nil {
+		cfg.Algorithm = &AIMD{}
+	}
+	cfg.Min = max(cfg.Min, 1)
+	if cfg.Max <= 0 {
+		cfg.Max = 1000
+	}
+	cfg.Max = max(cfg.Max, cfg.Min)
+	initial := min(max(cfg.Initial, cfg.Min), cfg.Max)
+
+	a := &Adaptive{
+		lim: New(Config{
+			Limit:        initial,
+			Queue:        cfg.Queue,
+			QueueTimeout: cfg.QueueTimeout,
+			OnChange:     cfg.OnChange,
+		}),
+		now:     time.Now,
+		alg:     cfg.Algorithm,
+		min:     cfg.Min,
+		max:     cfg.Max,
+		limit:   initial,
+		scale:   1,
+		onLimit: cfg.OnLimit,
+	}
+	if a.onLimit != nil {
+		a.onLimit(initial)
+	}
+	return a
+}
+
+// Acquire takes a slot like [Limiter.Acquire]. On success the caller must
+// call done exactly once when the request completes, reporting whether it
+// was dropped; the sample is fed to the algorithm and the slot released.
+func (a *Adaptive) Acquire(ctx context.Context) (done func(dropped bool), err error) {
+	if err := a.lim.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	start := a.now()
+	inFlight := a.lim.InFlight()
+	return func(dropped bool) {
+		a.update(Sample{RTT: a.now().Sub(start), InFlight: inFlight, Dropped: dropped})
+		a.lim.Release()
+	}, nil
+}
+
+// Limit returns the current limit, before scaling by [Adaptive.SetScale].
+func (a *Adaptive) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
+
+// SetScale admits only the fraction f, in (0, 1], of the limit found by the
+// algorithm, e.g. during a warm-up period. The algorithm keeps adjusting the
+// unscaled limit.
+func (a *Adaptive) SetScale(f float64) {
+	if f <= 0 || f > 1 {
+		f = 1
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if f == a.scale {
+		return
+	}
+	a.scale = f
+	a.apply()
+}
+
+// update feeds s to the algorithm and applies the clamped result.
+func (a *Adaptive) update(s Sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next := min(max(a.alg.Update(a.limit, s), a.min), a.max)
+	if next != a.limit {
+		a.limit = next
+		a.apply()
+	}
+}
+
+// apply passes the scaled limit to the underlying limiter. a.mu must be
+// held.
+func (a *Adaptive) apply() {
+	n := warmup.Scale(a.limit, a.scale)
+	a.lim.SetLimit(n)
+	if a.onLimit != nil {
+		a.onLimit(n)
+	}
+}
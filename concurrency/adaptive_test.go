@@ -0,0 +1,88 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAIMD(t *testing.T) {
+	a := &AIMD{Timeout: 100 * time.Millisecond}
+
+	if got := a.Update(10, Sample{RTT: time.Millisecond, InFlight: 6}); got != 11 {
+		t.Fatalf("busy success: got %d, want 11", got)
+	}
+	if got := a.Update(10, Sample{RTT: time.Millisecond, InFlight: 2}); got != 10 {
+		t.Fatalf("idle success must not grow: got %d", got)
+	}
+	if got := a.Update(10, Sample{Dropped: true}); got != 9 {
+		t.Fatalf("drop: got %d, want 9", got)
+	}
+	if got := a.Update(10, Sample{RTT: time.Second, InFlight: 10}); got != 9 {
+		t.Fatalf("timeout: got %d, want 9", got)
+	}
+}
+
+func TestGradient2_ShrinksOnLatencyIncrease(t *testing.T) {
+	g := &Gradient2{}
+	limit := 20
+	for range 50 {
+		limit = g.Update(limit, Sample{RTT: 10 * time.Millisecond, InFlight: limit})
+	}
+	grown := limit
+	if grown <= 20 {
+		t.Fatalf("expected growth at stable latency, got %d", grown)
+	}
+	for range 20 {
+		limit = g.Update(limit, Sample{RTT: 100 * time.Millisecond, InFlight: limit})
+	}
+	if limit >= grown {
+		t.Fatalf("expected shrink after latency increase, %d -> %d", grown, limit)
+	}
+}
+
+func TestVegas(t *testing.T) {
+	v := &Vegas{}
+	if got := v.Update(10, Sample{RTT: 10 * time.Millisecond}); got != 11 {
+		t.Fatalf("no queue: got %d, want 11", got)
+	}
+	// RTT doubled: queue ≈ limit/2 = 10 > beta.
+	if got := v.Update(20, Sample{RTT: 20 * time.Millisecond}); got != 19 {
+		t.Fatalf("queueing: got %d, want 19", got)
+	}
+}
+
+func TestAdaptive_ClampsAndReportsLimit(t *testing.T) {
+	var reported []int
+	a := NewAdaptive(AdaptiveConfig{
+		Algorithm: &AIMD{},
+		Initial:   2,
+		Min:       2,
+		Max:       3,
+		OnLimit:   func(n int) { reported = append(reported, n) },
+	})
+
+	for range 3 {
+		done, err := a.Acquire(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(false)
+	}
+	if a.Limit() != 3 {
+		t.Fatalf("expected limit clamped to max 3, got %d", a.Limit())
+	}
+
+	for range 5 {
+		done, err := a.Acquire(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(true)
+	}
+	if a.Limit() != 2 {
+		t.Fatalf("expected limit clamped to min 2, got %d", a.Limit())
+	}
+	if len(reported) < 3 || reported[0] != 2 {
+		t.Fatalf("unexpected limit reports %v", reported)
+	}
+}
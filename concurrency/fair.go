@@ -0,0 +1,314 @@
+package concurrency
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrKeyQueueFull is returned by [FairQueue.Acquire] when the key already
+// has the most requests waiting, either because it reached its own queue
+// bound or because its newest request was pushed out to make room for a
+// key with a shorter queue.
+var ErrKeyQueueFull = errors.New("concurrency: key queue full")
+
+// FairConfig holds the parameters of a [FairQueue].
+type FairConfig struct {
+	// Limit is the number of in-flight slots shared by all keys. Values
+	// below 1 are treated as 1.
+	Limit int
+
+	// Queue is the maximum number of requests waiting for a slot across all
+	// keys. Zero rejects immediately when all slots are taken.
+	Queue int
+
+	// KeyQueue is the maximum number of requests of a single key waiting
+	// for a slot. Zero bounds them by Queue only.
+	KeyQueue int
+
+	// QueueTimeout bounds how long a request waits in the queue. Zero waits
+	// until the request context is done.
+	QueueTimeout time.Duration
+
+	// OnChange, if set, is called with the key and the change in its
+	// in-flight and queued requests every time either changes. It is called
+	// with the queue's lock held and must not call back into the queue.
+	OnChange func(key string, inFlight, queued int)
+}
+
+// flow is the state of one key. It exists while the key has requests in
+// flight or queued.
+type flow struct {
+	key      string
+	finish   float64   // virtual finish tag of the key's newest request
+	inFlight int       // requests holding a slot
+	waiters  list.List // of *fairWaiter, front = oldest
+	index    int       // position in FairQueue.backlog, -1 if not queued
+}
+
+// head returns the oldest waiter of f.
+func (f *flow) head() *fairWaiter {
+	return f.waiters.Front().Value.(*fairWaiter)
+}
+
+// fairWaiter is a queued Acquire call.
+type fairWaiter struct {
+	flow  *flow
+	el    *list.Element
+	start float64 // virtual start tag
+	seq   uint64  // arrival order, breaks ties between equal start tags
+	ready chan struct{}
+	done  bool  // granted a slot or pushed out; set before ready is closed
+	err   error // non-nil if pushed out
+}
+
+// backlog is a min-heap of the flows with queued requests, ordered by the
+// start tag of their oldest request.
+type backlog []*flow
+
+func (b backlog) Len() int { return len(b) }
+func (b backlog) Less(i, j int) bool {
+	x, y := b[i].head(), b[j].head()
+	return x.start < y.start || x.start == y.start && x.seq < y.seq
+}
+func (b backlog) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+	b[i].index, b[j].index = i, j
+}
+func (b *backlog) Push(x any) {
+	f := x.(*flow)
+	f.index = len(*b)
+	*b = append(*b, f)
+}
+func (b *backlog) Pop() any {
+	old := *b
+	f := old[len(old)-1]
+	f.index = -1
+	*b = old[:len(old)-1]
+	return f
+}
+
+// FairQueue shares a fixed number of in-flight slots among keys, such as
+// tenants, by weighted fair queuing. While slots are free requests are
+// admitted at once; when they are taken, requests queue per key and free
+// slots go to the key that is furthest behind its weighted share, so that
+// a key with twice the weight gets twice the slots of a busy neighbour and
+// no key can starve the others.
+//
+// Shares follow start-time fair queuing: every request is tagged with a
+// virtual start time, the later of the current virtual time and the finish
+// tag of its key's previous request, and finishes 1/weight later; queued
+// requests are served in start tag order. A key's history is forgotten once
+// it has nothing in flight or queued. When the shared queue is full, the
+// newest request of the key with the longest queue is pushed out in favour
+// of a key with a shorter one. All methods are safe for concurrent use.
+type FairQueue struct {
+	mu       sync.Mutex
+	cfg      FairConfig
+	inFlight int
+	queued   int
+	vtime    float64 // start tag of the latest admitted request
+	seq      uint64  // arrivals so far
+	flows    map[string]*flow
+	backlog  backlog
+}
+
+// NewFair creates a FairQueue with the given configuration.
+func NewFair(cfg FairConfig) *FairQueue {
+	cfg.Limit = max(cfg.Limit, 1)
+	return &FairQueue{cfg: cfg, flows: make(map[string]*flow)}
+}
+
+// Acquire takes a slot for key, waiting in the queue if necessary. Weights
+// below or equal to zero count as 1. On success the caller must call the
+// returned release function exactly once. If ctx is done while waiting, the
+// context error is returned.
+func (q *FairQueue) Acquire(ctx context.Context, key string, weight float64) (release func(), err error) {
+	if weight <= 0 {
+		weight = 1
+	}
+	q.mu.Lock()
+	f, ok := q.flows[key]
+	if !ok {
+		f = &flow{key: key, index: -1}
+		q.flows[key] = f
+	}
+	start := max(q.vtime, f.finish)
+	if q.inFlight < q.cfg.Limit && q.queued == 0 {
+		f.finish = start + 1/weight
+		q.vtime = start
+		q.inFlight++
+		f.inFlight++
+		q.changed(f, 1, 0)
+		q.mu.Unlock()
+		return func() { q.release(f) }, nil
+	}
+	if err := q.makeRoom(f); err != nil {
+		q.forget(f)
+		q.mu.Unlock()
+		return nil, err
+	}
+	f.finish = start + 1/weight
+	q.seq++
+	w := &fairWaiter{flow: f, start: start, seq: q.seq, ready: make(chan struct{})}
+	w.el = f.waiters.PushBack(w)
+	q.queued++
+	if f.index < 0 {
+		heap.Push(&q.backlog, f)
+	}
+	q.changed(f, 0, 1)
+	q.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if q.cfg.QueueTimeout > 0 {
+		t := time.NewTimer(q.cfg.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-w.ready:
+		if w.err != nil {
+			return nil, w.err
+		}
+		return func() { q.release(f) }, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrQueueTimeout
+	}
+
+	q.mu.Lock()
+	if w.done {
+		// A slot was handed over, or we were pushed out, while giving up.
+		q.mu.Unlock()
+		if w.err != nil {
+			return nil, w.err
+		}
+		q.release(f)
+		return nil, err
+	}
+	q.remove(w)
+	q.forget(f)
+	q.mu.Unlock()
+	return nil, err
+}
+
+// InFlight returns the number of requests currently holding a slot.
+func (q *FairQueue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.inFlight
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (q *FairQueue) Queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// makeRoom checks that a request of f may queue, pushing out the newest
+// request of the longest queue if the shared queue is full. Must be called
+// with q.mu held.
+func (q *FairQueue) makeRoom(f *flow) error {
+	switch {
+	case q.cfg.Queue <= 0:
+		return ErrLimitExceeded
+	case q.cfg.KeyQueue > 0 && f.waiters.Len() >= q.cfg.KeyQueue:
+		return ErrKeyQueueFull
+	case q.queued < q.cfg.Queue:
+		return nil
+	}
+	var longest *flow
+	for _, g := range q.backlog {
+		if longest == nil || g.waiters.Len() > longest.waiters.Len() {
+			longest = g
+		}
+	}
+	// Only push out if the victim's queue stays at least as long as f's.
+	if longest == nil || longest.waiters.Len() <= f.waiters.Len()+1 {
+		if longest == f {
+			return ErrKeyQueueFull
+		}
+		return ErrQueueFull
+	}
+	w := longest.waiters.Back().Value.(*fairWaiter)
+	q.remove(w)
+	w.done = true
+	w.err = ErrKeyQueueFull
+	close(w.ready)
+	return nil
+}
+
+// release returns a slot held by a request of f and hands free slots on.
+func (q *FairQueue) release(f *flow) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight--
+	f.inFlight--
+	q.changed(f, -1, 0)
+	q.grant()
+	q.forget(f)
+}
+
+// grant hands free slots to queued requests in start tag order. Must be
+// called with q.mu held.
+func (q *FairQueue) grant() {
+	for q.inFlight < q.cfg.Limit && len(q.backlog) > 0 {
+		f := q.backlog[0]
+		w := f.head()
+		f.waiters.Remove(w.el)
+		if f.waiters.Len() == 0 {
+			heap.Pop(&q.backlog)
+		} else {
+			heap.Fix(&q.backlog, 0)
+		}
+		q.queued--
+		q.vtime = w.start
+		q.inFlight++
+		f.inFlight++
+		q.changed(f, 1, -1)
+		w.done = true
+		close(w.ready)
+	}
+}
+
+// remove takes a waiter out of the queue. If it was the newest of its key,
+// the key's finish tag is rolled back so that it is not charged for a
+// request that never ran. Must be called with q.mu held.
+func (q *FairQueue) remove(w *fairWaiter) {
+	f := w.flow
+	front, back := f.waiters.Front() == w.el, f.waiters.Back() == w.el
+	f.waiters.Remove(w.el)
+	if back {
+		f.finish = w.start
+	}
+	switch {
+	case f.waiters.Len() == 0:
+		heap.Remove(&q.backlog, f.index)
+	case front:
+		heap.Fix(&q.backlog, f.index)
+	}
+	q.queued--
+	q.changed(f, 0, -1)
+}
+
+// forget drops the state of f once it has nothing in flight or queued.
+// Must be called with q.mu held.
+func (q *FairQueue) forget(f *flow) {
+	if f.inFlight == 0 && f.waiters.Len() == 0 {
+		delete(q.flows, f.key)
+	}
+}
+
+// changed reports a state change of f to cfg.OnChange. Must be called with
+// q.mu held.
+func (q *FairQueue) changed(f *flow, inFlight, queued int) {
+	if q.cfg.OnChange != nil {
+		q.cfg.OnChange(f.key, inFlight, queued)
+	}
+}
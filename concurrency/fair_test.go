@@ -0,0 +1,187 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// grant is a slot handed to a queued request of key.
+type grant struct {
+	key     string
+	release func()
+}
+
+// waiting returns the number of queued requests of key.
+func waiting(q *FairQueue, key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if f, ok := q.flows[key]; ok {
+		return f.waiters.Len()
+	}
+	return 0
+}
+
+// enqueue starts a waiting Acquire for key and returns once it is queued.
+func enqueue(t *testing.T, q *FairQueue, key string, weight float64, granted chan<- grant) {
+	t.Helper()
+	n := waiting(q, key)
+	go func() {
+		release, err := q.Acquire(t.Context(), key, weight)
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+			return
+		}
+		granted <- grant{key, release}
+	}()
+	for waiting(q, key) != n+1 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// drain releases the held slot and every granted one in turn, and returns
+// the keys in the order they got their slot.
+func drain(t *testing.T, release func(), granted <-chan grant, n int) []string {
+	t.Helper()
+	var order []string
+	for range n {
+		release()
+		g := <-granted
+		order = append(order, g.key)
+		release = g.release
+	}
+	release()
+	return order
+}
+
+func TestFairQueue_InterleavesKeys(t *testing.T) {
+	q := NewFair(FairConfig{Limit: 1, Queue: 10})
+	hold, err := q.Acquire(t.Context(), "other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan grant)
+	for range 4 {
+		enqueue(t, q, "heavy", 1, granted)
+	}
+	for range 2 {
+		enqueue(t, q, "light", 1, granted)
+	}
+
+	got := drain(t, hold, granted, 6)
+	want := []string{"heavy", "light", "heavy", "light", "heavy", "heavy"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("grant order = %v, want %v", got, want)
+		}
+	}
+	if q.InFlight() != 0 || q.Queued() != 0 || len(q.flows) != 0 {
+		t.Fatalf("in-flight %d queued %d flows %d, want all 0", q.InFlight(), q.Queued(), len(q.flows))
+	}
+}
+
+func TestFairQueue_Weights(t *testing.T) {
+	q := NewFair(FairConfig{Limit: 1, Queue: 10})
+	hold, err := q.Acquire(t.Context(), "other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan grant)
+	for range 3 {
+		enqueue(t, q, "free", 1, granted)
+	}
+	for range 3 {
+		enqueue(t, q, "pro", 3, granted)
+	}
+
+	got := drain(t, hold, granted, 6)
+	want := []string{"free", "pro", "pro", "pro", "free", "free"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("grant order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFairQueue_PushesOutLongestQueue(t *testing.T) {
+	q := NewFair(FairConfig{Limit: 1, Queue: 3})
+	hold, err := q.Acquire(t.Context(), "other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushed := make(chan error, 1)
+	granted := make(chan grant)
+	for range 2 {
+		enqueue(t, q, "heavy", 1, granted)
+	}
+	go func() {
+		_, err := q.Acquire(t.Context(), "heavy", 1)
+		pushed <- err
+	}()
+	for waiting(q, "heavy") != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full; light takes the place of heavy's newest request.
+	enqueue(t, q, "light", 1, granted)
+	if err := <-pushed; !errors.Is(err, ErrKeyQueueFull) {
+		t.Fatalf("pushed-out request: expected ErrKeyQueueFull, got %v", err)
+	}
+	if _, err := q.Acquire(t.Context(), "heavy", 1); !errors.Is(err, ErrKeyQueueFull) {
+		t.Fatalf("heavy: expected ErrKeyQueueFull, got %v", err)
+	}
+	if _, err := q.Acquire(t.Context(), "light", 1); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("light: expected ErrQueueFull, got %v", err)
+	}
+
+	got := drain(t, hold, granted, 3)
+	if got[0] != "heavy" || got[1] != "light" || got[2] != "heavy" {
+		t.Fatalf("grant order = %v, want [heavy light heavy]", got)
+	}
+}
+
+func TestFairQueue_KeyQueue(t *testing.T) {
+	q := NewFair(FairConfig{Limit: 1, Queue: 10, KeyQueue: 1})
+	hold, err := q.Acquire(t.Context(), "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan grant)
+	enqueue(t, q, "b", 1, granted)
+	if _, err := q.Acquire(t.Context(), "b", 1); !errors.Is(err, ErrKeyQueueFull) {
+		t.Fatalf("expected ErrKeyQueueFull, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := q.Acquire(ctx, "c", 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	hold()
+	(<-granted).release()
+	if q.InFlight() != 0 || q.Queued() != 0 || len(q.flows) != 0 {
+		t.Fatalf("in-flight %d queued %d flows %d, want all 0", q.InFlight(), q.Queued(), len(q.flows))
+	}
+}
+
+func TestFairQueue_QueueTimeout(t *testing.T) {
+	q := NewFair(FairConfig{Limit: 1, Queue: 1, QueueTimeout: 10 * time.Millisecond})
+	hold, err := q.Acquire(t.Context(), "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hold()
+
+	if _, err := q.Acquire(t.Context(), "b", 1); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+	if _, err := q.Acquire(t.Context(), "b", 1); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("queue slot not freed after timeout: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Package concurrency provides a bulkhead: a semaphore that bounds the
+// number of in-flight requests, with an optional bounded FIFO wait queue,
+// adaptive limits, weighted fair queuing across keys, and a load meter for
+// priority-based load shedding.
+package concurrency
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLimitExceeded is returned when no slot is free and queueing is
+	// disabled.
+	ErrLimitExceeded = errors.New("concurrency: limit exceeded")
+	// ErrQueueFull is returned when no slot is free and the wait queue is
+	// full.
+	ErrQueueFull = errors.New("concurrency: queue full")
+	// ErrQueueTimeout is returned when a queued request did not get a slot
+	// within the queue timeout.
+	ErrQueueTimeout = errors.New("concurrency: queue timeout")
+)
+
+// Config holds the limiter parameters.
+type Config struct {
+	// Limit is the maximum number of requests in flight. Values below 1 are
+	// treated as 1.
+	Limit int
+
+	// Queue is the maximum number of requests waiting for a slot. Zero
+	// rejects immediately when all slots are taken.
+	Queue int
+
+	// QueueTimeout bounds how long a request waits in the queue. Zero waits
+	// until the request context is done.
+	QueueTimeout time.Duration
+
+	// OnChange, if set, is called with the change in in-flight and queued
+	// requests every time either changes, e.g. to drive gauges. It is called
+	// with the limiter's lock held and must not call back into the limiter.
+	OnChange func(inFlight, queued int)
+}
+
+// waiter is a queued Acquire call.
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// Limiter is a semaphore with a bounded FIFO wait queue. All methods are safe
+// for concurrent use.
+type Limiter struct {
+	mu       sync.Mutex
+	cfg      Config
+	inFlight int
+	waiters  list.List // of *waiter, front = oldest
+}
+
+// New creates a Limiter with the given configuration.
+func New(cfg Config) *Limiter {
+	cfg.Limit = max(cfg.Limit, 1)
+	return &Limiter{cfg: cfg}
+}
+
+// Acquire takes a slot, waiting in the queue if necessary. On success the
+// caller must call [Limiter.Release] exactly once. If ctx is done while
+// waiting, the context error is returned.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.inFlight < l.cfg.Limit && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.changed(1, 0)
+		l.mu.Unlock()
+		return nil
+	}
+	if l.cfg.Queue <= 0 {
+		l.mu.Unlock()
+		return ErrLimitExceeded
+	}
+	if l.waiters.Len() >= l.cfg.Queue {
+		l.mu.Unlock()
+		return ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{})}
+	el := l.waiters.PushBack(w)
+	l.changed(0, 1)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.cfg.QueueTimeout > 0 {
+		t := time.NewTimer(l.cfg.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrQueueTimeout
+	}
+
+	l.mu.Lock()
+	if w.granted {
+		// A slot was handed over while we were giving up; pass it on.
+		l.mu.Unlock()
+		l.Release()
+		return err
+	}
+	l.waiters.Remove(el)
+	l.changed(0, -1)
+	l.mu.Unlock()
+	return err
+}
+
+// Release returns a slot taken by a successful [Limiter.Acquire] and hands it
+// to the oldest queued request, if any.
+func (l *Limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.changed(-1, 0)
+	l.grant()
+}
+
+// SetLimit changes the maximum number of in-flight requests. Raising the
+// limit admits queued requests immediately; lowering it takes effect as
+// in-flight requests complete.
+func (l *Limiter) SetLimit(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg.Limit = max(n, 1)
+	l.grant()
+}
+
+// Limit returns the current maximum number of in-flight requests.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg.Limit
+}
+
+// InFlight returns the number of requests currently holding a slot.
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (l *Limiter) Queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiters.Len()
+}
+
+// grant hands free slots to queued requests in FIFO order. Must be called
+// with l.mu held.
+func (l *Limiter) grant() {
+	for l.inFlight < l.cfg.Limit && l.waiters.Len() > 0 {
+		w := l.waiters.Remove(l.waiters.Front()).(*waiter)
+		w.granted = true
+		l.inFlight++
+		l.changed(1, -1)
+		close(w.ready)
+	}
+}
+
+// changed reports a state change to cfg.OnChange. Must be called with l.mu
+// held.
+func (l *Limiter) changed(inFlight, queued int) {
+	if l.cfg.OnChange != nil {
+		l.cfg.OnChange(inFlight, queued)
+	}
+}
@@ -0,0 +1,106 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter_RejectsWithoutQueue(t *testing.T) {
+	l := New(Config{Limit: 2})
+
+	for i := range 2 {
+		if err := l.Acquire(t.Context()); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	if err := l.Acquire(t.Context()); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected ErrLimitExceeded, got %v", err)
+	}
+
+	l.Release()
+	if err := l.Acquire(t.Context()); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestLimiter_QueueHandsOverInOrder(t *testing.T) {
+	l := New(Config{Limit: 1, Queue: 2})
+	if err := l.Acquire(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan int, 2)
+	for i := range 2 {
+		go func() {
+			if err := l.Acquire(t.Context()); err != nil {
+				t.Errorf("waiter %d: %v", i, err)
+				return
+			}
+			order <- i
+		}()
+		// Make sure waiter i is queued before waiter i+1.
+		for l.Queued() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := l.Acquire(t.Context()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	l.Release()
+	if got := <-order; got != 0 {
+		t.Fatalf("expected waiter 0 first, got %d", got)
+	}
+	l.Release()
+	if got := <-order; got != 1 {
+		t.Fatalf("expected waiter 1 second, got %d", got)
+	}
+	if l.InFlight() != 1 || l.Queued() != 0 {
+		t.Fatalf("in-flight %d queued %d, want 1 and 0", l.InFlight(), l.Queued())
+	}
+}
+
+func TestLimiter_QueueTimeoutAndCancel(t *testing.T) {
+	l := New(Config{Limit: 1, Queue: 1, QueueTimeout: 10 * time.Millisecond})
+	if err := l.Acquire(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Acquire(t.Context()); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := l.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if l.Queued() != 0 {
+		t.Fatalf("expected empty queue, got %d", l.Queued())
+	}
+}
+
+func TestLimiter_SetLimitAdmitsQueued(t *testing.T) {
+	var inFlight, queued int
+	l := New(Config{Limit: 1, Queue: 1, OnChange: func(f, q int) { inFlight += f; queued += q }})
+	if err := l.Acquire(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- l.Acquire(t.Context()) }()
+	for l.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	l.SetLimit(2)
+	if err := <-done; err != nil {
+		t.Fatalf("queued acquire: %v", err)
+	}
+	if l.Limit() != 2 || inFlight != 2 || queued != 0 {
+		t.Fatalf("limit %d in-flight %d queued %d, want 2, 2, 0", l.Limit(), inFlight, queued)
+	}
+}
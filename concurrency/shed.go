@@ -0,0 +1,135 @@
+package concurrency
+
+import (
+	"sync"
+	"time"
+)
+
+// ShedConfig holds the parameters of a [Shedder].
+type ShedConfig struct {
+	// MaxInFlight is the number of requests in flight at which the load
+	// is 1. Zero ignores the in-flight count.
+	MaxInFlight int
+
+	// TargetLatency is the standing latency at which the load is 1. Zero
+	// ignores latency.
+	TargetLatency time.Duration
+
+	// Interval is the period over which the lowest latency is taken as the
+	// standing latency. Defaults to 100ms.
+	Interval time.Duration
+
+	// Now returns the current time. Defaults to time.Now; tests may inject
+	// a fake clock.
+	Now func() time.Time
+}
+
+// Shedder measures server load for priority-based load shedding. Load is
+// the larger of the in-flight ratio and the standing-latency ratio.
+//
+// Latency is measured from admission until done is called, so it covers
+// the handler as well as any waiting on the server. Standing latency follows
+// CoDel: it is the lowest latency observed during the last complete
+// interval. A single slow request does not raise it; only a server where
+// every request is slow, typically because requests wait for resources,
+// does. An interval without timed requests resets it, so load recovers once
+// traffic has been shed. All methods are safe for concurrent use.
+type Shedder struct {
+	cfg ShedConfig
+
+	mu          sync.Mutex
+	inFlight    int
+	windowStart time.Time
+	windowMin   time.Duration // lowest latency in the current interval, -1 if none
+	standing    time.Duration // lowest latency of the last complete interval
+}
+
+// NewShedder creates a Shedder with the given configuration.
+func NewShedder(cfg ShedConfig) *Shedder {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 100 * time.Millisecond
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Shedder{cfg: cfg, windowStart: cfg.Now(), windowMin: -1}
+}
+
+// roll closes the current interval if it has elapsed. s.mu must be held.
+func (s *Shedder) roll(now time.Time) {
+	elapsed := now.Sub(s.windowStart)
+	if elapsed < s.cfg.Interval {
+		return
+	}
+	s.standing = max(s.windowMin, 0)
+	if elapsed >= 2*s.cfg.Interval {
+		// The interval just closed was followed by an empty one.
+		s.standing = 0
+	}
+	s.windowStart = now
+	s.windowMin = -1
+}
+
+// load returns the current load. s.mu must be held.
+func (s *Shedder) load() float64 {
+	var l float64
+	if s.cfg.MaxInFlight > 0 {
+		l = float64(s.inFlight) / float64(s.cfg.MaxInFlight)
+	}
+	if s.cfg.TargetLatency > 0 {
+		l = max(l, float64(s.standing)/float64(s.cfg.TargetLatency))
+	}
+	return l
+}
+
+// Load returns the current load; 1 means fully loaded.
+func (s *Shedder) Load() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roll(s.cfg.Now())
+	return s.load()
+}
+
+// Admit admits a request while the load is below threshold, and returns
+// the load it saw either way. done must be called when an admitted request
+// finishes; it records the request's latency.
+func (s *Shedder) Admit(threshold float64) (done func(), load float64, ok bool) {
+	return s.admit(threshold, true)
+}
+
+// AdmitUntimed is like [Shedder.Admit] but done records no latency; the
+// request only counts as in flight. Use it for work whose duration says
+// nothing about load, such as long-lived streams.
+func (s *Shedder) AdmitUntimed(threshold float64) (done func(), load float64, ok bool) {
+	return s.admit(threshold, false)
+}
+
+// admit implements [Shedder.Admit] and [Shedder.AdmitUntimed].
+func (s *Shedder) admit(threshold float64, timed bool) (func(), float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := s.cfg.Now()
+	s.roll(start)
+	load := s.load()
+	if load >= threshold {
+		return nil, load, false
+	}
+	s.inFlight++
+	return func() { s.finish(start, timed) }, load, true
+}
+
+// finish releases an admitted request that started at start and, if timed,
+// records its latency.
+func (s *Shedder) finish(start time.Time, timed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.cfg.Now()
+	s.roll(now)
+	s.inFlight--
+	if !timed {
+		return
+	}
+	if d := now.Sub(start); s.windowMin < 0 || d < s.windowMin {
+		s.windowMin = d
+	}
+}
@@ -0,0 +1,97 @@
+package concurrency
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestShedder_InFlightLoad(t *testing.T) {
+	s := NewShedder(ShedConfig{MaxInFlight: 4})
+
+	var dones []func()
+	for i := range 3 {
+		done, _, ok := s.Admit(1)
+		if !ok {
+			t.Fatalf("request %d rejected", i)
+		}
+		dones = append(dones, done)
+	}
+	if got := s.Load(); got != 0.75 {
+		t.Fatalf("load = %v, want 0.75", got)
+	}
+	if _, load, ok := s.Admit(0.7); ok || load != 0.75 {
+		t.Fatalf("expected rejection at threshold 0.7, got ok=%v load=%v", ok, load)
+	}
+	if _, _, ok := s.Admit(1); !ok {
+		t.Fatal("fourth request must fit under threshold 1")
+	}
+	if _, _, ok := s.Admit(1); ok {
+		t.Fatal("fifth request must be rejected at full load")
+	}
+	for _, d := range dones {
+		d()
+	}
+	if got := s.Load(); got != 0.25 {
+		t.Fatalf("load = %v, want 0.25", got)
+	}
+}
+
+func TestShedder_StandingLatency(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	s := NewShedder(ShedConfig{TargetLatency: 100 * time.Millisecond, Interval: time.Second, Now: clk.Now})
+
+	// Every request in the interval takes at least 150ms: a standing queue.
+	for _, d := range []time.Duration{200 * time.Millisecond, 150 * time.Millisecond} {
+		done, _, _ := s.Admit(1)
+		clk.Advance(d)
+		done()
+	}
+	if got := s.Load(); got != 0 {
+		t.Fatalf("load before the interval closed = %v, want 0", got)
+	}
+	clk.Advance(700 * time.Millisecond)
+	if got := s.Load(); got != 1.5 {
+		t.Fatalf("load = %v, want 1.5", got)
+	}
+
+	// One fast request in the next interval clears it.
+	done, _, _ := s.Admit(2)
+	clk.Advance(10 * time.Millisecond)
+	done()
+	clk.Advance(time.Second)
+	if got := s.Load(); got != 0.1 {
+		t.Fatalf("load = %v, want 0.1", got)
+	}
+
+	// An interval without completions resets the latency signal.
+	clk.Advance(2 * time.Second)
+	if got := s.Load(); got != 0 {
+		t.Fatalf("load after idle intervals = %v, want 0", got)
+	}
+}
+
+func TestShedder_UntimedSkipsLatency(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	s := NewShedder(ShedConfig{MaxInFlight: 2, TargetLatency: 100 * time.Millisecond, Interval: time.Second, Now: clk.Now})
+
+	done, _, ok := s.AdmitUntimed(1)
+	if !ok {
+		t.Fatal("stream rejected")
+	}
+	if got := s.Load(); got != 0.5 {
+		t.Fatalf("load with one stream in flight = %v, want 0.5", got)
+	}
+	// A long-lived stream must not count as standing latency.
+	clk.Advance(10 * time.Second)
+	done()
+	clk.Advance(500 * time.Millisecond)
+	if got := s.Load(); got != 0 {
+		t.Fatalf("load after the stream ended = %v, want 0", got)
+	}
+}
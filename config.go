@@ -4,22 +4,58 @@ import (
 	"math/rand"
 
 	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/concurrency"
+	"github.com/Keksclan/goRawrSquirrel/interceptors"
 	"github.com/Keksclan/goRawrSquirrel/internal/core"
 	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/quota"
+	"github.com/Keksclan/goRawrSquirrel/ratelimit"
 	"github.com/Keksclan/goRawrSquirrel/security"
 	"github.com/Keksclan/goRawrSquirrel/tracing"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
 )
 
 // config holds the internal configuration assembled via functional options.
 type config struct {
-	middlewares core.MiddlewareBuilder
-	resolver    *policy.Resolver
-	ipBlocker   *security.IPBlocker
-	cache       cache.Cache
-	l1          *cache.L1
-	l2          *cache.L2
-	tracing     *tracing.TracingConfig
-	funMode     bool
-	funRand     rand.Source
-	funMessages []string
+	middlewares      core.MiddlewareBuilder
+	auth             bool
+	tenantGuard      bool
+	impersonation    bool
+	resolver         *policy.Resolver
+	ipBlocker        *security.IPBlocker
+	clientResolver   *security.ClientResolver
+	cache            cache.Cache
+	l1               *cache.L1
+	l2               *cache.L2
+	rateLimitRedis   *ratelimit.RedisConfig
+	rateLimitHeaders bool
+	rateLimitCost    *policy.CostRule
+	concurrencyAlg   func() concurrency.Algorithm
+	quotas           *quota.Quotas
+	dryRun           *policy.DryRunRule
+	warmUp           *warmup.Ramp
+	tracing          *tracing.TracingConfig
+	funMode          bool
+	funRand          rand.Source
+	funMessages      []string
+
+	// deferred holds option steps that depend on other options (e.g. the
+	// policy resolver). NewServer runs them after every option has been
+	// applied, so the order in which options are passed does not matter.
+	deferred []func(*config)
+}
+
+// clientAddrs returns the resolver of effective client addresses for
+// middleware that identify clients by IP: the IP blocker if one is set, so
+// that every middleware honours the same trusted proxies, otherwise the
+// resolver of WithClientResolver. It returns nil if neither is configured,
+// in which case the peer address is used as-is.
+func (c *config) clientAddrs() interceptors.ClientAddrResolver {
+	switch {
+	case c.ipBlocker != nil:
+		return c.ipBlocker
+	case c.clientResolver != nil:
+		return c.clientResolver
+	}
+	return nil
 }
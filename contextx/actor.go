@@ -1,6 +1,9 @@
 package contextx
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
 // Actor represents the authenticated identity behind a request. It is
 // typically populated by an authentication interceptor and stored in the
@@ -20,6 +23,9 @@ type Actor struct {
 
 // WithActor returns a derived context that carries the given Actor.
 func WithActor(ctx context.Context, a Actor) context.Context {
+	if s, ok := ctx.Value(actorSinkKey).(*actorSink); ok {
+		s.record(a)
+	}
 	return context.WithValue(ctx, actorKey, a)
 }
 
@@ -29,3 +35,61 @@ func ActorFromContext(ctx context.Context) (Actor, bool) {
 	a, ok := ctx.Value(actorKey).(Actor)
 	return a, ok
 }
+
+// TrackActor returns a derived context together with a function that
+// reports the first Actor stored with [WithActor] in ctx or any context
+// derived from it. It lets a middleware that runs before authentication
+// learn who the request authenticated as once the handler returns.
+//
+// If authentication failed but a subject was reported with
+// [ReportSubject], the function returns an Actor carrying only that Subject
+// and false.
+func TrackActor(ctx context.Context) (context.Context, func() (Actor, bool)) {
+	s := &actorSink{}
+	return context.WithValue(ctx, actorSinkKey, s), s.get
+}
+
+// ReportSubject records subject as the identity a request claims before it
+// is authenticated, e.g. the user name of a login attempt. An AuthFunc calls
+// it before rejecting a request, so that a middleware using [TrackActor],
+// such as the fail-ban detector, can count the failure against the subject.
+// It does nothing in a context not derived from TrackActor.
+func ReportSubject(ctx context.Context, subject string) {
+	if s, ok := ctx.Value(actorSinkKey).(*actorSink); ok {
+		s.report(subject)
+	}
+}
+
+// actorSink holds the first Actor, and the first reported subject, recorded
+// below a [TrackActor] context.
+type actorSink struct {
+	mu      sync.Mutex
+	a       Actor
+	ok      bool
+	subject string
+}
+
+func (s *actorSink) record(a Actor) {
+	s.mu.Lock()
+	if !s.ok {
+		s.a, s.ok = a, true
+	}
+	s.mu.Unlock()
+}
+
+func (s *actorSink) report(subject string) {
+	s.mu.Lock()
+	if s.subject == "" {
+		s.subject = subject
+	}
+	s.mu.Unlock()
+}
+
+func (s *actorSink) get() (Actor, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ok {
+		return Actor{Subject: s.subject}, false
+	}
+	return s.a, true
+}
@@ -39,3 +39,34 @@ func TestActorFromContextMissing(t *testing.T) {
 		t.Fatal("expected no actor in empty context")
 	}
 }
+
+func TestTrackActorReportsFirstActor(t *testing.T) {
+	ctx, tracked := TrackActor(t.Context())
+	if _, ok := tracked(); ok {
+		t.Fatal("expected no actor before WithActor")
+	}
+
+	inner := WithActor(ctx, Actor{Subject: "caller"})
+	_ = WithActor(inner, Actor{Subject: "impersonated"})
+
+	got, ok := tracked()
+	if !ok || got.Subject != "caller" {
+		t.Fatalf("got (%+v, %v), want caller", got, ok)
+	}
+}
+
+func TestTrackActorReportsAttemptedSubject(t *testing.T) {
+	ctx, tracked := TrackActor(t.Context())
+	ReportSubject(ctx, "mallory")
+
+	got, ok := tracked()
+	if ok || got.Subject != "mallory" {
+		t.Fatalf("got (%+v, %v), want unauthenticated mallory", got, ok)
+	}
+
+	// A successful authentication takes precedence.
+	_ = WithActor(ctx, Actor{Subject: "alice"})
+	if got, ok := tracked(); !ok || got.Subject != "alice" {
+		t.Fatalf("got (%+v, %v), want alice", got, ok)
+	}
+}
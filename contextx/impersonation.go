@@ -0,0 +1,17 @@
+package contextx
+
+import "context"
+
+// WithImpersonator returns a derived context that records the original,
+// authenticated Actor when a request is executed on behalf of another Actor.
+// The effective Actor is still stored via [WithActor].
+func WithImpersonator(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, impersonatorKey, a)
+}
+
+// ImpersonatorFromContext extracts the original Actor stored in ctx.
+// The boolean return value is false when the request is not impersonated.
+func ImpersonatorFromContext(ctx context.Context) (Actor, bool) {
+	a, ok := ctx.Value(impersonatorKey).(Actor)
+	return a, ok
+}
@@ -0,0 +1,26 @@
+package contextx
+
+import "testing"
+
+func TestWithImpersonatorRoundTrip(t *testing.T) {
+	ctx := WithActor(t.Context(), Actor{Subject: "customer-1"})
+	ctx = WithImpersonator(ctx, Actor{Subject: "support-7"})
+
+	orig, ok := ImpersonatorFromContext(ctx)
+	if !ok {
+		t.Fatal("expected impersonator in context")
+	}
+	if orig.Subject != "support-7" {
+		t.Fatalf("got %q, want %q", orig.Subject, "support-7")
+	}
+	eff, _ := ActorFromContext(ctx)
+	if eff.Subject != "customer-1" {
+		t.Fatalf("effective actor: got %q, want %q", eff.Subject, "customer-1")
+	}
+}
+
+func TestImpersonatorFromContextMissing(t *testing.T) {
+	if _, ok := ImpersonatorFromContext(t.Context()); ok {
+		t.Fatal("expected no impersonator in empty context")
+	}
+}
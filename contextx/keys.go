@@ -8,4 +8,6 @@ const (
 	actorKey contextKey = iota
 	requestIDKey
 	groupKey
+	impersonatorKey
+	actorSinkKey
 )
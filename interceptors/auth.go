@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/Keksclan/goRawrSquirrel/auth"
+	"github.com/Keksclan/goRawrSquirrel/policy"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
@@ -22,39 +23,78 @@ func authError(err error) error {
 	return errUnauthenticated
 }
 
+// AuthOption customizes [AuthUnary] and [AuthStream].
+type AuthOption func(*authState)
+
+// AuthDryRun makes the interceptor report instead of reject requests that
+// fail authentication when def, or the [policy.Policy.DryRun] rule of the
+// group r resolves, selects Auth. Such requests proceed with their original
+// context, i.e. without an Actor. Either argument may be nil.
+func AuthDryRun(def *policy.DryRunRule, r *policy.Resolver) AuthOption {
+	return func(s *authState) { s.dry = &dryRun{def: def, resolver: r} }
+}
+
+// authState holds the AuthFunc and its optional dry-run rule.
+type authState struct {
+	fn  auth.AuthFunc
+	dry *dryRun
+}
+
+// authenticate runs the AuthFunc and returns the context to continue with.
+// In dry-run mode a failure is reported and ctx returned unchanged.
+func (s *authState) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	newCtx, err := s.fn(ctx, fullMethod, md)
+	if err == nil {
+		return newCtx, nil
+	}
+	if err := s.dry.enforce(ctx, middlewareAuth, fullMethod, pickAuth, authError(err)); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+func newAuthState(fn auth.AuthFunc, opts []AuthOption) *authState {
+	st := &authState{fn: fn}
+	for _, o := range opts {
+		o(st)
+	}
+	return st
+}
+
 // AuthUnary returns a unary server interceptor that calls the supplied
 // AuthFunc before forwarding to the handler.
-func AuthUnary(fn auth.AuthFunc) grpc.UnaryServerInterceptor {
+func AuthUnary(fn auth.AuthFunc, opts ...AuthOption) grpc.UnaryServerInterceptor {
+	st := newAuthState(fn, opts)
 	return func(
 		ctx context.Context,
 		req any,
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (any, error) {
-		md, _ := metadata.FromIncomingContext(ctx)
-		newCtx, err := fn(ctx, info.FullMethod, md)
+		newCtx, err := st.authenticate(ctx, info.FullMethod)
 		if err != nil {
-			return nil, authError(err)
+			return nil, err
 		}
 		return handler(newCtx, req)
 	}
 }
 
 // AuthStream returns a stream server interceptor that calls the supplied
-// AuthFunc before forwarding to the handler.
-func AuthStream(fn auth.AuthFunc) grpc.StreamServerInterceptor {
+// AuthFunc before forwarding to the handler. The context returned by the
+// AuthFunc is exposed through the stream's Context method.
+func AuthStream(fn auth.AuthFunc, opts ...AuthOption) grpc.StreamServerInterceptor {
+	st := newAuthState(fn, opts)
 	return func(
 		srv any,
 		ss grpc.ServerStream,
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		ctx := ss.Context()
-		md, _ := metadata.FromIncomingContext(ctx)
-		_, err := fn(ctx, info.FullMethod, md)
+		newCtx, err := st.authenticate(ss.Context(), info.FullMethod)
 		if err != nil {
-			return authError(err)
+			return err
 		}
-		return handler(srv, ss)
+		return handler(srv, &contextStream{ServerStream: ss, ctx: newCtx})
 	}
 }
@@ -0,0 +1,329 @@
+package interceptors
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Keksclan/goRawrSquirrel/concurrency"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rejection errors are allocated once to avoid per-request allocations on the hot path.
+var (
+	// errOverloaded rejects a request because its group's bulkhead is full;
+	// another replica may well serve it.
+	errOverloaded = status.Error(codes.Unavailable, "concurrency limit exceeded")
+	// errTooManyConcurrent rejects a request because its key already uses
+	// all of its slots; retrying elsewhere does not help.
+	errTooManyConcurrent = status.Error(codes.ResourceExhausted, "too many concurrent requests")
+)
+
+// defaultGroupLabel is the metrics label of the server-wide default rule.
+const defaultGroupLabel = "default"
+
+// ConcurrencyOption customizes [ConcurrencyUnary] and [ConcurrencyStream].
+type ConcurrencyOption func(*concurrencyState)
+
+// ConcurrencyAlgorithm sets the algorithm used by rules with Adaptive set.
+// newAlg is called once per group, since algorithms keep per-limiter state.
+// The default is [concurrency.AIMD].
+func ConcurrencyAlgorithm(newAlg func() concurrency.Algorithm) ConcurrencyOption {
+	return func(s *concurrencyState) { s.newAlg = newAlg }
+}
+
+// ConcurrencyWarmUp scales every limit by the factor of r, so that limits
+// ramp up to their configured value during a warm-up period.
+func ConcurrencyWarmUp(r *warmup.Ramp) ConcurrencyOption {
+	return func(s *concurrencyState) { s.ramp = r }
+}
+
+// ConcurrencyClientAddr sets the resolver used for rules keyed by
+// [policy.KeyPeerIP]. By default the peer address is used as-is.
+func ConcurrencyClientAddr(r ClientAddrResolver) ConcurrencyOption {
+	return func(s *concurrencyState) { s.addrs = r }
+}
+
+// slotLimiter is the common interface of static and adaptive group limiters.
+// done reports whether the request was dropped and releases the slot.
+// SetScale admits only a fraction of the limit.
+type slotLimiter interface {
+	Acquire(ctx context.Context) (done func(dropped bool), err error)
+	SetScale(f float64)
+}
+
+// staticLimiter adapts a fixed-limit [concurrency.Limiter] to slotLimiter.
+type staticLimiter struct {
+	*concurrency.Limiter
+	limit   int // configured limit
+	onLimit func(int)
+}
+
+func (l staticLimiter) SetScale(f float64) {
+	n := warmup.Scale(max(l.limit, 1), f)
+	l.SetLimit(n)
+	l.onLimit(n)
+}
+
+func (l staticLimiter) Acquire(ctx context.Context) (func(bool), error) {
+	if err := l.Limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	return func(bool) { l.Release() }, nil
+}
+
+// keyedLimiter is a per-key limiter together with the number of requests
+// currently using it; it is dropped when the last one finishes.
+type keyedLimiter struct {
+	lim   *concurrency.Limiter
+	refs  int
+	scale float64
+}
+
+// concurrencyState holds the server-wide default rule, an optional policy
+// resolver whose groups may override it, and the limiters created lazily
+// from resolved rules.
+type concurrencyState struct {
+	def      *policy.ConcurrencyRule
+	resolver *policy.Resolver
+	addrs    ClientAddrResolver
+	newAlg   func() concurrency.Algorithm
+	ramp     *warmup.Ramp
+
+	mu     sync.Mutex
+	groups map[string]slotLimiter
+	scales map[string]float64 // scale last applied to groups
+	keys   map[string]*keyedLimiter
+}
+
+// newConcurrencyState applies opts on top of the defaults.
+func newConcurrencyState(def *policy.ConcurrencyRule, r *policy.Resolver, opts []ConcurrencyOption) *concurrencyState {
+	st := &concurrencyState{
+		def:      def,
+		resolver: r,
+		addrs:    peerResolver{},
+		newAlg:   func() concurrency.Algorithm { return &concurrency.AIMD{} },
+		groups:   make(map[string]slotLimiter),
+		scales:   make(map[string]float64),
+		keys:     make(map[string]*keyedLimiter),
+	}
+	for _, o := range opts {
+		o(st)
+	}
+	return st
+}
+
+// ruleFor returns the group's MaxConcurrent rule when the resolver matches
+// fullMethod to a group that defines one. Otherwise it returns the default
+// with an empty group name.
+func (s *concurrencyState) ruleFor(fullMethod string) (string, *policy.ConcurrencyRule) {
+	if s.resolver != nil {
+		if name, pol, ok := s.resolver.Resolve(fullMethod); ok && pol != nil && pol.MaxConcurrent != nil {
+			return name, pol.MaxConcurrent
+		}
+	}
+	return "", s.def
+}
+
+// onChange returns a concurrency.Config.OnChange callback that drives the
+// in-flight and queued gauges of group.
+func onChange(group string) func(inFlight, queued int) {
+	group = cmp.Or(group, defaultGroupLabel)
+	inFlight := metrics.ConcurrencyInFlight().WithLabelValues(group)
+	queued := metrics.ConcurrencyQueued().WithLabelValues(group)
+	return func(f, q int) {
+		if f != 0 {
+			inFlight.Add(float64(f))
+		}
+		if q != 0 {
+			queued.Add(float64(q))
+		}
+	}
+}
+
+// groupLimiter returns (or lazily creates) the limiter of an unkeyed rule,
+// scaled by the warm-up factor f.
+func (s *concurrencyState) groupLimiter(group string, rule *policy.ConcurrencyRule, f float64) slotLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.groups[group]
+	if !ok {
+		limit := metrics.ConcurrencyLimit().WithLabelValues(cmp.Or(group, defaultGroupLabel))
+		onLimit := func(n int) { limit.Set(float64(n)) }
+		if rule.Adaptive {
+			l = concurrency.NewAdaptive(concurrency.AdaptiveConfig{
+				Algorithm:    s.newAlg(),
+				Initial:      rule.Limit,
+				Min:          rule.MinLimit,
+				Max:          rule.MaxLimit,
+				Queue:        rule.Queue,
+				QueueTimeout: rule.QueueTimeout,
+				OnChange:     onChange(group),
+				OnLimit:      onLimit,
+			})
+		} else {
+			onLimit(max(rule.Limit, 1))
+			l = staticLimiter{
+				Limiter: concurrency.New(concurrency.Config{
+					Limit:        rule.Limit,
+					Queue:        rule.Queue,
+					QueueTimeout: rule.QueueTimeout,
+					OnChange:     onChange(group),
+				}),
+				limit:   rule.Limit,
+				onLimit: onLimit,
+			}
+		}
+		s.groups[group] = l
+		s.scales[group] = 1
+	}
+	if f != s.scales[group] {
+		s.scales[group] = f
+		l.SetScale(f)
+	}
+	return l
+}
+
+// acquire takes a slot for the request and returns the function that gives
+// it back, which receives the handler's error. It returns nil, nil when no
+// rule applies or the request is exempt.
+func (s *concurrencyState) acquire(ctx context.Context, fullMethod string) (func(error), error) {
+	group, rule := s.ruleFor(fullMethod)
+	if rule == nil || bypass(ctx, middlewareConcurrency, fullMethod) {
+		return nil, nil
+	}
+	f := s.ramp.Factor()
+
+	if rule.Key == policy.KeyNone {
+		done, err := s.groupLimiter(group, rule, f).Acquire(ctx)
+		if err != nil {
+			return nil, rejectConcurrency(group, err, errOverloaded)
+		}
+		return func(err error) { done(isOverload(ctx, err)) }, nil
+	}
+
+	id := group + "\x00" + requestKey(ctx, rule.Key, s.addrs)
+	s.mu.Lock()
+	kl, ok := s.keys[id]
+	if !ok {
+		kl = &keyedLimiter{lim: concurrency.New(concurrency.Config{
+			Limit:        warmup.Scale(rule.Limit, f),
+			Queue:        rule.Queue,
+			QueueTimeout: rule.QueueTimeout,
+			OnChange:     onChange(group),
+		}), scale: f}
+		s.keys[id] = kl
+	}
+	if f != kl.scale {
+		kl.scale = f
+		kl.lim.SetLimit(warmup.Scale(rule.Limit, f))
+	}
+	kl.refs++
+	s.mu.Unlock()
+
+	unref := func() {
+		s.mu.Lock()
+		if kl.refs--; kl.refs == 0 {
+			delete(s.keys, id)
+		}
+		s.mu.Unlock()
+	}
+	if err := kl.lim.Acquire(ctx); err != nil {
+		unref()
+		return nil, rejectConcurrency(group, err, errTooManyConcurrent)
+	}
+	return func(error) {
+		kl.lim.Release()
+		unref()
+	}, nil
+}
+
+// isOverload reports whether a handler outcome signals overload to an
+// adaptive limiter: an expired deadline or a DeadlineExceeded or Unavailable
+// status. ResourceExhausted is not counted, because it reports a caller's
+// quota or rate limit, e.g. from the quota middleware running inside this
+// one, not a struggling backend.
+func isOverload(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// rejectConcurrency counts the rejection and maps err to a gRPC status:
+// full limiters and queues yield full, a cancelled or expired request its
+// context status.
+func rejectConcurrency(group string, err, full error) error {
+	group = cmp.Or(group, defaultGroupLabel)
+	reason := "limit"
+	switch {
+	case errors.Is(err, concurrency.ErrQueueFull):
+		reason = "queue_full"
+	case errors.Is(err, concurrency.ErrQueueTimeout):
+		reason = "queue_timeout"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		metrics.ConcurrencyRejected().WithLabelValues(group, "canceled").Inc()
+		return status.FromContextError(err).Err()
+	}
+	metrics.ConcurrencyRejected().WithLabelValues(group, reason).Inc()
+	return full
+}
+
+// ConcurrencyUnary returns a unary server interceptor that bounds the number
+// of requests handled at the same time. def applies to every method unless
+// the resolver matches a group with its own MaxConcurrent rule; either may be
+// nil. A full group bulkhead yields codes.Unavailable, a key that exhausted
+// its own slots codes.ResourceExhausted.
+func ConcurrencyUnary(def *policy.ConcurrencyRule, r *policy.Resolver, opts ...ConcurrencyOption) grpc.UnaryServerInterceptor {
+	st := newConcurrencyState(def, r, opts)
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp any, err error) {
+		release, err := st.acquire(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if release != nil {
+			// Deferred so that a panicking handler still frees its slot.
+			defer func() { release(err) }()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ConcurrencyStream returns a stream server interceptor that bounds the
+// number of open streams. A stream holds its slot until the handler returns.
+func ConcurrencyStream(def *policy.ConcurrencyRule, r *policy.Resolver, opts ...ConcurrencyOption) grpc.StreamServerInterceptor {
+	st := newConcurrencyState(def, r, opts)
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) (err error) {
+		release, err := st.acquire(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if release != nil {
+			defer func() { release(err) }()
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,184 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/concurrency"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// blockingHandler returns a handler that signals entered and then waits
+// for release.
+func blockingHandler(entered chan<- struct{}, release <-chan struct{}) grpc.UnaryHandler {
+	return func(context.Context, any) (any, error) {
+		entered <- struct{}{}
+		<-release
+		return "ok", nil
+	}
+}
+
+func TestConcurrencyUnary_GroupBulkhead(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("slow").
+			Exact("/api.Service/Slow").
+			Policy(policy.Policy{MaxConcurrent: &policy.ConcurrencyRule{Limit: 1}}),
+	)
+	ic := ConcurrencyUnary(nil, r)
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Slow"}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := ic(t.Context(), nil, info, blockingHandler(entered, release))
+		done <- err
+	}()
+	<-entered
+
+	if v := testutil.ToFloat64(metrics.ConcurrencyInFlight().WithLabelValues("slow")); v != 1 {
+		t.Fatalf("in-flight gauge = %v, want 1", v)
+	}
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", codeOf(err))
+	}
+
+	// Methods outside the group are not limited.
+	other := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Fast"}
+	if _, err := ic(t.Context(), nil, other, okHandler); err != nil {
+		t.Fatalf("unlimited method: %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+}
+
+func TestConcurrencyUnary_QueueTimeout(t *testing.T) {
+	ic := ConcurrencyUnary(&policy.ConcurrencyRule{Limit: 1, Queue: 1, QueueTimeout: 10 * time.Millisecond}, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	go func() { _, _ = ic(t.Context(), nil, info, blockingHandler(entered, release)) }()
+	<-entered
+
+	start := time.Now()
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable after queue timeout, got %v", codeOf(err))
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("expected the request to wait in the queue")
+	}
+}
+
+func TestConcurrencyUnary_PerKey(t *testing.T) {
+	ic := ConcurrencyUnary(&policy.ConcurrencyRule{Limit: 1, Key: policy.KeyMetadata("x-user")}, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+	u1 := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-user", "u1"))
+	u2 := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-user", "u2"))
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		_, _ = ic(u1, nil, info, blockingHandler(entered, release))
+		close(done)
+	}()
+	<-entered
+
+	if _, err := ic(u1, nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted for u1, got %v", codeOf(err))
+	}
+	if _, err := ic(u2, nil, info, okHandler); err != nil {
+		t.Fatalf("u2 must have its own slots: %v", err)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestConcurrencyUnary_AdaptiveShrinksOnOverload(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("adaptive").
+			Exact("/api.Service/Work").
+			Policy(policy.Policy{MaxConcurrent: &policy.ConcurrencyRule{
+				Limit: 10, Adaptive: true, MinLimit: 2, MaxLimit: 20,
+			}}),
+	)
+	ic := ConcurrencyUnary(nil, r, ConcurrencyAlgorithm(func() concurrency.Algorithm {
+		return &concurrency.AIMD{Backoff: 0.5}
+	}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Work"}
+	gauge := metrics.ConcurrencyLimit().WithLabelValues("adaptive")
+
+	overloaded := func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.Unavailable, "backend overloaded")
+	}
+	_, _ = ic(t.Context(), nil, info, overloaded)
+	if v := testutil.ToFloat64(gauge); v != 5 {
+		t.Fatalf("limit gauge = %v after one drop, want 5", v)
+	}
+	for range 5 {
+		_, _ = ic(t.Context(), nil, info, overloaded)
+	}
+	if v := testutil.ToFloat64(gauge); v != 2 {
+		t.Fatalf("limit gauge = %v, want the minimum 2", v)
+	}
+
+	// Plain errors are not overload signals; a busy limiter grows again.
+	_, _ = ic(t.Context(), nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.NotFound, "nope")
+	})
+	if v := testutil.ToFloat64(gauge); v != 3 {
+		t.Fatalf("limit gauge = %v after NotFound, want 3", v)
+	}
+
+	// Quota and rate-limit rejections are the caller's problem, not the
+	// backend's.
+	_, _ = ic(t.Context(), nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.ResourceExhausted, "quota exceeded")
+	})
+	if v := testutil.ToFloat64(gauge); v < 3 {
+		t.Fatalf("limit gauge = %v after ResourceExhausted, want no drop below 3", v)
+	}
+}
+
+func TestConcurrencyUnary_WarmUp(t *testing.T) {
+	now := time.Unix(0, 0)
+	ramp := warmup.New(warmup.Config{Duration: time.Minute, From: 0.5, Now: func() time.Time { return now }})
+	ic := ConcurrencyUnary(&policy.ConcurrencyRule{Limit: 2}, nil, ConcurrencyWarmUp(ramp))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/WarmUp"}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := ic(t.Context(), nil, info, blockingHandler(entered, release))
+		done <- err
+	}()
+	<-entered
+
+	// Half of two slots during warm-up.
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.Unavailable {
+		t.Fatalf("during warm-up: expected Unavailable, got %v", codeOf(err))
+	}
+	now = now.Add(time.Minute)
+	if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+		t.Fatalf("after warm-up: %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,66 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+)
+
+// Middleware names used in dry-run logs and the would_reject metric.
+const (
+	middlewareRateLimit = "ratelimit"
+	middlewareIPBlock   = "ipblock"
+	middlewareAuth      = "auth"
+)
+
+// dryRun decides whether a middleware only reports the requests it would
+// reject. def is the server-wide rule; groups matched by resolver may
+// override it with [policy.Policy.DryRun]. A nil *dryRun always enforces.
+type dryRun struct {
+	def      *policy.DryRunRule
+	resolver *policy.Resolver
+}
+
+// shadowed reports whether the rule that applies to fullMethod selects the
+// middleware picked by pick, together with the group label of the method.
+func (d *dryRun) shadowed(fullMethod string, pick func(*policy.DryRunRule) bool) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+	group, rule := defaultGroupLabel, d.def
+	if d.resolver != nil {
+		if name, pol, ok := d.resolver.Resolve(fullMethod); ok && pol != nil {
+			group = name
+			if pol.DryRun != nil {
+				rule = pol.DryRun
+			}
+		}
+	}
+	return group, rule != nil && pick(rule)
+}
+
+// enforce returns err unless the middleware runs in dry-run mode for
+// fullMethod, in which case the rejection is reported and nil returned. It
+// is only called for rejected requests, so admitted ones never pay for the
+// policy lookup.
+func (d *dryRun) enforce(ctx context.Context, middleware, fullMethod string, pick func(*policy.DryRunRule) bool, err error) error {
+	group, ok := d.shadowed(fullMethod, pick)
+	if !ok {
+		return err
+	}
+	reportWouldReject(ctx, middleware, group, fullMethod, err)
+	return nil
+}
+
+// reportWouldReject logs and counts a rejection that was not enforced.
+func reportWouldReject(ctx context.Context, middleware, group, fullMethod string, err error) {
+	metrics.WouldReject().WithLabelValues(middleware, group).Inc()
+	slog.InfoContext(ctx, "dry run: request would be rejected",
+		"middleware", middleware, "group", group, "method", fullMethod, "error", err)
+}
+
+func pickRateLimit(r *policy.DryRunRule) bool { return r.RateLimit }
+func pickIPBlock(r *policy.DryRunRule) bool   { return r.IPBlock }
+func pickAuth(r *policy.DryRunRule) bool      { return r.Auth }
@@ -0,0 +1,150 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/ratelimit"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func wouldReject(middleware, group string) float64 {
+	return testutil.ToFloat64(metrics.WouldReject().WithLabelValues(middleware, group))
+}
+
+func TestRateLimitUnary_DryRunPerGroup(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("shadow").
+			Exact("/api.Service/New").
+			Policy(policy.Policy{
+				RateLimit: &policy.RateLimitRule{Rate: 1, Window: time.Hour},
+				DryRun:    &policy.DryRunRule{RateLimit: true},
+			}),
+		policy.Group("enforced").
+			Exact("/api.Service/Old").
+			Policy(policy.Policy{RateLimit: &policy.RateLimitRule{Rate: 1, Window: time.Hour}}),
+	)
+	ic := RateLimitUnary(nil, r, RateLimitDryRun(nil))
+
+	before := wouldReject(middlewareRateLimit, "shadow")
+	shadow := &grpc.UnaryServerInfo{FullMethod: "/api.Service/New"}
+	for i := range 3 {
+		if _, err := ic(t.Context(), nil, shadow, okHandler); err != nil {
+			t.Fatalf("call %d: dry-run group must not reject, got %v", i, err)
+		}
+	}
+	if got := wouldReject(middlewareRateLimit, "shadow") - before; got != 2 {
+		t.Fatalf("would_reject = %v, want 2", got)
+	}
+
+	enforced := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Old"}
+	_, _ = ic(t.Context(), nil, enforced, okHandler)
+	if _, err := ic(t.Context(), nil, enforced, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+}
+
+func TestRateLimitUnary_DryRunDefault(t *testing.T) {
+	ic := RateLimitUnary(ratelimit.NewLimiter(0.001, 1), nil, RateLimitDryRun(&policy.DryRunRule{RateLimit: true}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Any"}
+
+	before := wouldReject(middlewareRateLimit, defaultGroupLabel)
+	for range 2 {
+		if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := wouldReject(middlewareRateLimit, defaultGroupLabel) - before; got != 1 {
+		t.Fatalf("would_reject = %v, want 1", got)
+	}
+}
+
+func TestStreamMessageLimit_DryRun(t *testing.T) {
+	rule := &policy.StreamRateLimitRule{Rate: 1, Window: time.Hour, Block: true}
+	ic := StreamMessageLimit(rule, nil, StreamLimitDryRun(&policy.DryRunRule{RateLimit: true}))
+
+	before := wouldReject(middlewareRateLimit, defaultGroupLabel)
+	err := runStream(t, ic, t.Context(), func(ss grpc.ServerStream) error {
+		for range 3 {
+			// Must neither fail nor block.
+			if err := ss.RecvMsg(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wouldReject(middlewareRateLimit, defaultGroupLabel) - before; got != 2 {
+		t.Fatalf("would_reject = %v, want 2", got)
+	}
+}
+
+func TestIPBlockUnary_DryRun(t *testing.T) {
+	b, err := security.NewIPBlocker(security.Config{Mode: security.DenyList, CIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Any"}
+
+	if _, err := IPBlockUnary(b)(ctx, nil, info, okHandler); codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+
+	before := wouldReject(middlewareIPBlock, defaultGroupLabel)
+	ic := IPBlockUnary(b, IPBlockDryRun(&policy.DryRunRule{IPBlock: true}, nil))
+	if _, err := ic(ctx, nil, info, okHandler); err != nil {
+		t.Fatalf("dry run must not reject, got %v", err)
+	}
+	if got := wouldReject(middlewareIPBlock, defaultGroupLabel) - before; got != 1 {
+		t.Fatalf("would_reject = %v, want 1", got)
+	}
+}
+
+func TestAuthUnary_DryRunProceedsWithoutActor(t *testing.T) {
+	fn := func(ctx context.Context, _ string, md metadata.MD) (context.Context, error) {
+		if len(md.Get("authorization")) == 0 {
+			return ctx, errors.New("missing token")
+		}
+		return contextx.WithActor(ctx, contextx.Actor{Subject: "u"}), nil
+	}
+	r := policy.NewResolver(
+		policy.Group("legacy").
+			Prefix("/legacy.").
+			Policy(policy.Policy{DryRun: &policy.DryRunRule{Auth: true}}),
+	)
+	ic := AuthUnary(fn, AuthDryRun(nil, r))
+
+	before := wouldReject(middlewareAuth, "legacy")
+	var hadActor bool
+	_, err := ic(t.Context(), nil, &grpc.UnaryServerInfo{FullMethod: "/legacy.Service/Get"},
+		func(ctx context.Context, _ any) (any, error) {
+			_, hadActor = contextx.ActorFromContext(ctx)
+			return "ok", nil
+		})
+	if err != nil || hadActor {
+		t.Fatalf("expected to proceed without Actor, got err=%v actor=%v", err, hadActor)
+	}
+	if got := wouldReject(middlewareAuth, "legacy") - before; got != 1 {
+		t.Fatalf("would_reject = %v, want 1", got)
+	}
+
+	// Other methods are still enforced.
+	_, err = ic(t.Context(), nil, &grpc.UnaryServerInfo{FullMethod: "/api.Service/Get"}, okHandler)
+	if codeOf(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// middlewareConcurrency names the concurrency limiter in bypass logs and
+// the exempt_bypassed metric.
+const middlewareConcurrency = "concurrency"
+
+// exemptKey is the context key of a request's *exemption.
+type exemptKey struct{}
+
+// exemption is the outcome of the exemption rules for one request. The
+// peer rules are evaluated when the request arrives; the Actor rules once,
+// by the first middleware that asks after authentication.
+type exemption struct {
+	rules  *security.Exemptions
+	peer   security.Exemption
+	peerOK bool
+
+	once    sync.Once
+	actor   security.Exemption
+	actorOK bool
+
+	// deferred is a rejection by a middleware before authentication that
+	// Actor rules may still lift; see deferForActor.
+	deferred error
+}
+
+// match reports whether the request is exempt and why.
+func (x *exemption) match(ctx context.Context) (security.Exemption, bool) {
+	if x.peerOK {
+		return x.peer, true
+	}
+	if !x.rules.HasActorRules() {
+		return security.Exemption{}, false
+	}
+	a, ok := contextx.ActorFromContext(ctx)
+	if !ok {
+		return security.Exemption{}, false
+	}
+	x.once.Do(func() { x.actor, x.actorOK = x.rules.MatchActor(a) })
+	return x.actor, x.actorOK
+}
+
+// bypass reports whether the request in ctx is exempt from middleware, and
+// logs and counts the bypass if so. Requests that did not pass through
+// [ExemptUnary] or [ExemptStream] are never exempt.
+func bypass(ctx context.Context, middleware, fullMethod string) bool {
+	x, _ := ctx.Value(exemptKey{}).(*exemption)
+	if x == nil {
+		return false
+	}
+	ex, ok := x.match(ctx)
+	if !ok {
+		return false
+	}
+	metrics.ExemptBypassed().WithLabelValues(middleware, ex.Reason).Inc()
+	slog.InfoContext(ctx, "exempt request bypassed middleware",
+		"middleware", middleware, "method", fullMethod, "reason", ex.Reason, "match", ex.Match)
+	return true
+}
+
+// deferForActor holds back err, a rejection by a middleware that runs before
+// authentication, if the Actor rules could still exempt the request, and
+// reports whether it did. The rejection is then settled by settleDeferred
+// once the Actor is known.
+func deferForActor(ctx context.Context, err error) bool {
+	x, _ := ctx.Value(exemptKey{}).(*exemption)
+	if x == nil || x.peerOK || !x.rules.HasActorRules() {
+		return false
+	}
+	x.deferred = err
+	return true
+}
+
+// settleDeferred returns the rejection held back for the request in ctx by
+// deferForActor, unless the authenticated Actor turns out to be exempt from
+// middleware.
+func settleDeferred(ctx context.Context, middleware, fullMethod string) error {
+	x, _ := ctx.Value(exemptKey{}).(*exemption)
+	if x == nil || x.deferred == nil {
+		return nil
+	}
+	err := x.deferred
+	x.deferred = nil
+	if bypass(ctx, middleware, fullMethod) {
+		return nil
+	}
+	return err
+}
+
+// withExemption evaluates the peer rules of e and stores the outcome in ctx.
+func withExemption(ctx context.Context, e *security.Exemptions) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	x := &exemption{rules: e}
+	x.peer, x.peerOK = e.MatchPeer(ctx, md)
+	return context.WithValue(ctx, exemptKey{}, x)
+}
+
+// ExemptUnary returns a unary server interceptor that evaluates e once per
+// request, so that the rate-limit, concurrency and IP-block interceptors
+// installed after it let exempt requests through. Rules on the Actor only
+// take effect in interceptors that run after authentication, and in the
+// pre-authentication rate limits when paired with a [RateLimitPostAuth]
+// stage, which settles their rejections.
+func ExemptUnary(e *security.Exemptions) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		return handler(withExemption(ctx, e), req)
+	}
+}
+
+// ExemptStream returns a stream server interceptor that evaluates e once
+// per stream. See [ExemptUnary].
+func ExemptStream(e *security.Exemptions) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: withExemption(ss.Context(), e)})
+	}
+}
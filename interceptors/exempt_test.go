@@ -0,0 +1,198 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/ratelimit"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func bypassed(middleware, reason string) float64 {
+	return testutil.ToFloat64(metrics.ExemptBypassed().WithLabelValues(middleware, reason))
+}
+
+func TestExempt_CIDRBypassesIPBlockAndRateLimit(t *testing.T) {
+	ex, err := security.NewExemptions(security.ExemptionConfig{CIDRs: []string{"10.20.0.0/16"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := security.NewIPBlocker(security.Config{Mode: security.AllowList, CIDRs: []string{"192.168.0.0/16"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{
+		ExemptUnary(ex),
+		IPBlockUnary(b),
+		RateLimitUnary(ratelimit.NewLimiter(0.001, 1), nil),
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Any"}
+
+	probe := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.20.1.1"), Port: 1}})
+	beforeIP := bypassed(middlewareIPBlock, security.ExemptCIDR)
+	beforeRL := bypassed(middlewareRateLimit, security.ExemptCIDR)
+	for i := range 3 {
+		if _, err := ic(probe, nil, info, okHandler); err != nil {
+			t.Fatalf("call %d: exempt request rejected: %v", i, err)
+		}
+	}
+	if got := bypassed(middlewareIPBlock, security.ExemptCIDR) - beforeIP; got != 3 {
+		t.Fatalf("ipblock bypasses = %v, want 3", got)
+	}
+	if got := bypassed(middlewareRateLimit, security.ExemptCIDR) - beforeRL; got != 3 {
+		t.Fatalf("ratelimit bypasses = %v, want 3", got)
+	}
+
+	// Exempt requests consume no tokens.
+	client := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1}})
+	if _, err := ic(client, nil, info, okHandler); err != nil {
+		t.Fatalf("first regular request: %v", err)
+	}
+	if _, err := ic(client, nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	other := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.30.1.1"), Port: 1}})
+	if _, err := ic(other, nil, info, okHandler); codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestExempt_TokenBypassesRateLimit(t *testing.T) {
+	secret := []byte("s3cret")
+	ex, err := security.NewExemptions(security.ExemptionConfig{BypassKeys: map[string][]byte{"ops": secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{
+		ExemptUnary(ex),
+		RateLimitUnary(ratelimit.NewLimiter(0.001, 1), nil),
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Any"}
+	tok := security.SignBypassToken(secret, "ops", time.Now().Add(time.Hour))
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs(security.HeaderBypassToken, tok))
+
+	for i := range 3 {
+		if _, err := ic(ctx, nil, info, okHandler); err != nil {
+			t.Fatalf("call %d: exempt request rejected: %v", i, err)
+		}
+	}
+	_, _ = ic(t.Context(), nil, info, okHandler)
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+}
+
+func TestExempt_SubjectBypassesConcurrencyAfterAuth(t *testing.T) {
+	ex, err := security.NewExemptions(security.ExemptionConfig{Subjects: []string{"svc-batch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		sub, _ := metadata.FromIncomingContext(ctx)
+		return handler(contextx.WithActor(ctx, contextx.Actor{Subject: sub.Get("sub")[0]}), req)
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{
+		ExemptUnary(ex),
+		authenticate,
+		ConcurrencyUnary(&policy.ConcurrencyRule{Limit: 1}, nil),
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Any"}
+	as := func(sub string) context.Context {
+		return metadata.NewIncomingContext(t.Context(), metadata.Pairs("sub", sub))
+	}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := ic(as("user-1"), nil, info, blockingHandler(entered, release))
+		done <- err
+	}()
+	<-entered
+
+	if _, err := ic(as("user-2"), nil, info, okHandler); codeOf(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	before := bypassed(middlewareConcurrency, security.ExemptSubject)
+	if _, err := ic(as("svc-batch"), nil, info, okHandler); err != nil {
+		t.Fatalf("exempt request rejected: %v", err)
+	}
+	if got := bypassed(middlewareConcurrency, security.ExemptSubject) - before; got != 1 {
+		t.Fatalf("concurrency bypasses = %v, want 1", got)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExempt_SubjectBypassesGlobalRateLimitAfterAuth(t *testing.T) {
+	ex, err := security.NewExemptions(security.ExemptionConfig{Subjects: []string{"svc-batch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		sub, _ := metadata.FromIncomingContext(ctx)
+		return handler(contextx.WithActor(ctx, contextx.Actor{Subject: sub.Get("sub")[0]}), req)
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{
+		ExemptUnary(ex),
+		RateLimitUnary(ratelimit.NewLimiter(0.001, 1), nil, RateLimitPreAuth()),
+		authenticate,
+		RateLimitUnary(nil, nil, RateLimitPostAuth()),
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Any"}
+	as := func(sub string) context.Context {
+		return metadata.NewIncomingContext(t.Context(), metadata.Pairs("sub", sub))
+	}
+
+	if _, err := ic(as("user-1"), nil, info, okHandler); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if _, err := ic(as("user-1"), nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	before := bypassed(middlewareRateLimit, security.ExemptSubject)
+	if _, err := ic(as("svc-batch"), nil, info, okHandler); err != nil {
+		t.Fatalf("exempt request rejected: %v", err)
+	}
+	if got := bypassed(middlewareRateLimit, security.ExemptSubject) - before; got != 1 {
+		t.Fatalf("ratelimit bypasses = %v, want 1", got)
+	}
+}
+
+func TestExempt_CIDRBypassesStreamMessageLimit(t *testing.T) {
+	ex, err := security.NewExemptions(security.ExemptionConfig{CIDRs: []string{"10.20.0.0/16"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := StreamMessageLimit(&policy.StreamRateLimitRule{Rate: 1, Window: time.Hour}, nil)
+	ic := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return ExemptStream(ex)(srv, ss, info, func(srv any, ss grpc.ServerStream) error {
+			return limit(srv, ss, info, handler)
+		})
+	}
+	probe := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.20.1.1"), Port: 1}})
+
+	err = runStream(t, ic, probe, func(ss grpc.ServerStream) error {
+		for range 3 {
+			if err := ss.RecvMsg(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("exempt stream limited: %v", err)
+	}
+}
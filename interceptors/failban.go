@@ -0,0 +1,97 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// FailBanOption customizes [FailBanUnary] and [FailBanStream].
+type FailBanOption func(*failBanState)
+
+// FailBanClientAddr sets the resolver of the client IP that failures are
+// counted against. By default the peer address is used as-is; pass the
+// *security.IPBlocker the detector bans into to honour trusted proxies.
+func FailBanClientAddr(r ClientAddrResolver) FailBanOption {
+	return func(s *failBanState) { s.addrs = r }
+}
+
+// failBanState holds the detector and the client address resolver.
+type failBanState struct {
+	detector *security.BanDetector
+	addrs    ClientAddrResolver
+}
+
+func newFailBanState(d *security.BanDetector, opts []FailBanOption) *failBanState {
+	st := &failBanState{detector: d, addrs: peerResolver{}}
+	for _, o := range opts {
+		o(st)
+	}
+	return st
+}
+
+// observe reports the outcome err of the request in ctx to the detector,
+// together with the Actor reported by actor, and logs and counts any
+// resulting ban.
+func (s *failBanState) observe(ctx context.Context, fullMethod string, err error, actor func() (contextx.Actor, bool)) {
+	code := status.Code(err)
+	if err == nil || !s.detector.Counts(code) {
+		return
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	addr, ok := s.addrs.ClientAddr(ctx, md)
+	if !ok {
+		return
+	}
+	a, _ := actor()
+	ban, ok := s.detector.Observe(addr, a.Subject, code)
+	if !ok {
+		return
+	}
+	metrics.FailBanBans().WithLabelValues(ban.Reason).Inc()
+	slog.WarnContext(ctx, "client banned after repeated failures",
+		"addr", ban.Addr, "duration", ban.Duration, "offense", ban.Offense,
+		"reason", ban.Reason, "subject", ban.Subject, "method", fullMethod, "code", code)
+}
+
+// FailBanUnary returns a unary server interceptor that reports failed
+// requests to d, which bans clients that fail too often. It must run before
+// the interceptors whose failures it counts, e.g. authentication; the Actor
+// those set is picked up through [contextx.TrackActor].
+func FailBanUnary(d *security.BanDetector, opts ...FailBanOption) grpc.UnaryServerInterceptor {
+	st := newFailBanState(d, opts)
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		tracked, actor := contextx.TrackActor(ctx)
+		resp, err := handler(tracked, req)
+		st.observe(ctx, info.FullMethod, err, actor)
+		return resp, err
+	}
+}
+
+// FailBanStream returns a stream server interceptor that reports failed
+// streams to d. See [FailBanUnary].
+func FailBanStream(d *security.BanDetector, opts ...FailBanOption) grpc.StreamServerInterceptor {
+	st := newFailBanState(d, opts)
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		tracked, actor := contextx.TrackActor(ss.Context())
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: tracked})
+		st.observe(ss.Context(), info.FullMethod, err, actor)
+		return err
+	}
+}
@@ -0,0 +1,116 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestFailBanUnary_BansRepeatedAuthFailures(t *testing.T) {
+	b, err := security.NewIPBlocker(security.Config{Mode: security.DenyList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := security.NewBanDetector(b, security.BanConfig{MaxFailures: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if len(md.Get("authorization")) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "missing token")
+		}
+		return handler(ctx, req)
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{
+		IPBlockUnary(b),
+		FailBanUnary(d, FailBanClientAddr(b)),
+		authenticate,
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Any"}
+	ctx := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 1}})
+
+	before := testutil.ToFloat64(metrics.FailBanBans().WithLabelValues(security.BanByIP))
+	for i := range 3 {
+		if _, err := ic(ctx, nil, info, okHandler); codeOf(err) != codes.Unauthenticated {
+			t.Fatalf("attempt %d: expected Unauthenticated, got %v", i, err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.FailBanBans().WithLabelValues(security.BanByIP)) - before; got != 1 {
+		t.Fatalf("bans = %v, want 1", got)
+	}
+
+	authed := metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer x"))
+	if _, err := ic(authed, nil, info, okHandler); codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected banned client to get PermissionDenied, got %v", err)
+	}
+}
+
+func TestFailBanUnary_CountsPerActor(t *testing.T) {
+	b, err := security.NewIPBlocker(security.Config{Mode: security.DenyList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := security.NewBanDetector(b, security.BanConfig{
+		Codes:       []codes.Code{codes.PermissionDenied},
+		MaxFailures: 2,
+		ByActor:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(contextx.WithActor(ctx, contextx.Actor{Subject: "alice"}), req)
+	}
+	denied := func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.PermissionDenied, "nope")
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{FailBanUnary(d), authenticate})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Admin"}
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		ctx := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}})
+		_, _ = ic(ctx, nil, info, denied)
+	}
+	l := b.List()
+	if len(l) != 1 || l[0].Prefix.String() != "192.0.2.2/32" {
+		t.Fatalf("blocker list = %v, want 192.0.2.2/32 banned for alice's failures", l)
+	}
+}
+
+func TestFailBanUnary_CountsReportedSubjectOfFailedAuth(t *testing.T) {
+	b, err := security.NewIPBlocker(security.Config{Mode: security.DenyList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := security.NewBanDetector(b, security.BanConfig{MaxFailures: 2, ByActor: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A login attempt for alice with a wrong password.
+	authFn := func(ctx context.Context, _ string, _ metadata.MD) (context.Context, error) {
+		contextx.ReportSubject(ctx, "alice")
+		return nil, status.Error(codes.Unauthenticated, "bad password")
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{FailBanUnary(d), AuthUnary(authFn)})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Login"}
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		ctx := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}})
+		_, _ = ic(ctx, nil, info, okHandler)
+	}
+	l := b.List()
+	if len(l) != 1 || l[0].Prefix.String() != "192.0.2.2/32" {
+		t.Fatalf("blocker list = %v, want 192.0.2.2/32 banned for failed logins as alice", l)
+	}
+}
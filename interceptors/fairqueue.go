@@ -0,0 +1,137 @@
+package interceptors
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/concurrency"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// anonymousTenant is the metrics label of requests without an Actor tenant.
+const anonymousTenant = "anonymous"
+
+// defaultPlan is the plan of every tenant when the rule has no PlanOf.
+const defaultPlan = "default"
+
+// fairQueueState holds the rule and the scheduler it configures.
+type fairQueueState struct {
+	rule  policy.FairQueueRule
+	queue *concurrency.FairQueue
+}
+
+func newFairQueueState(rule policy.FairQueueRule) *fairQueueState {
+	if rule.PlanOf == nil {
+		rule.PlanOf = func(context.Context, contextx.Actor) string { return defaultPlan }
+	}
+	return &fairQueueState{
+		rule: rule,
+		queue: concurrency.NewFair(concurrency.FairConfig{
+			Limit:        rule.Limit,
+			Queue:        rule.Queue,
+			KeyQueue:     rule.TenantQueue,
+			QueueTimeout: rule.QueueTimeout,
+			OnChange: func(tenant string, f, q int) {
+				tenant = cmp.Or(tenant, anonymousTenant)
+				if f != 0 {
+					metrics.FairQueueInFlight().WithLabelValues(tenant).Add(float64(f))
+				}
+				if q != 0 {
+					metrics.FairQueueQueued().WithLabelValues(tenant).Add(float64(q))
+				}
+			},
+		}),
+	}
+}
+
+// acquire takes a slot for the tenant of the request's Actor, weighted by
+// the tenant's plan, and returns the function that gives it back.
+func (s *fairQueueState) acquire(ctx context.Context) (func(), error) {
+	var tenant string
+	weight := 1.0
+	if a, ok := contextx.ActorFromContext(ctx); ok && a.Tenant != "" {
+		tenant = a.Tenant
+		if w, ok := s.rule.Weights[s.rule.PlanOf(ctx, a)]; ok {
+			weight = w
+		}
+	}
+	label := cmp.Or(tenant, anonymousTenant)
+
+	start := time.Now()
+	release, err := s.queue.Acquire(ctx, tenant, weight)
+	if err != nil {
+		return nil, rejectFairQueue(label, err)
+	}
+	metrics.FairQueueWait().WithLabelValues(label).Observe(time.Since(start).Seconds())
+	return release, nil
+}
+
+// rejectFairQueue counts the rejection and maps err to a gRPC status: a
+// tenant with the longest queue gets codes.ResourceExhausted, every other
+// rejection codes.Unavailable or its context status.
+func rejectFairQueue(tenant string, err error) error {
+	reason := "limit"
+	switch {
+	case errors.Is(err, concurrency.ErrKeyQueueFull):
+		metrics.FairQueueRejected().WithLabelValues(tenant, "tenant_queue_full").Inc()
+		return errTooManyConcurrent
+	case errors.Is(err, concurrency.ErrQueueFull):
+		reason = "queue_full"
+	case errors.Is(err, concurrency.ErrQueueTimeout):
+		reason = "queue_timeout"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		metrics.FairQueueRejected().WithLabelValues(tenant, "canceled").Inc()
+		return status.FromContextError(err).Err()
+	}
+	metrics.FairQueueRejected().WithLabelValues(tenant, reason).Inc()
+	return errOverloaded
+}
+
+// FairQueueUnary returns a unary server interceptor that shares rule.Limit
+// in-flight slots among tenants by weighted fair queuing. The tenant is
+// read from the contextx.Actor, so the interceptor must run after
+// authentication. A tenant whose queue is the longest when the queue
+// overflows is rejected with codes.ResourceExhausted; other rejections
+// yield codes.Unavailable.
+func FairQueueUnary(rule policy.FairQueueRule) grpc.UnaryServerInterceptor {
+	st := newFairQueueState(rule)
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		release, err := st.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// FairQueueStream returns a stream server interceptor that schedules streams
+// like [FairQueueUnary]. Streams get their own slots, separate from unary
+// calls; a stream holds its slot until the handler returns.
+func FairQueueStream(rule policy.FairQueueRule) grpc.StreamServerInterceptor {
+	st := newFairQueueState(rule)
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		release, err := st.acquire(ss.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,90 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestFairQueueUnary_TenantQueues(t *testing.T) {
+	ic := FairQueueUnary(policy.FairQueueRule{Limit: 1, Queue: 4, TenantQueue: 1})
+	rejected := metrics.FairQueueRejected().WithLabelValues("fq-big", "tenant_queue_full")
+	before := testutil.ToFloat64(rejected)
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Get"}
+	tenantCtx := func(tenant string) context.Context {
+		return contextx.WithActor(t.Context(), contextx.Actor{Subject: "u", Tenant: tenant})
+	}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := ic(tenantCtx("fq-big"), nil, info, blockingHandler(entered, release))
+		done <- err
+	}()
+	<-entered
+	if v := testutil.ToFloat64(metrics.FairQueueInFlight().WithLabelValues("fq-big")); v != 1 {
+		t.Fatalf("in-flight gauge = %v, want 1", v)
+	}
+
+	// One request of fq-big may wait; the next exceeds its tenant queue.
+	go func() {
+		_, err := ic(tenantCtx("fq-big"), nil, info, okHandler)
+		done <- err
+	}()
+	for testutil.ToFloat64(metrics.FairQueueQueued().WithLabelValues("fq-big")) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := ic(tenantCtx("fq-big"), nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	// Other tenants still get a place in the queue.
+	go func() {
+		_, err := ic(tenantCtx("fq-small"), nil, info, okHandler)
+		done <- err
+	}()
+	for testutil.ToFloat64(metrics.FairQueueQueued().WithLabelValues("fq-small")) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	for range 3 {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := testutil.ToFloat64(rejected) - before; v != 1 {
+		t.Fatalf("rejected counter rose by %v, want 1", v)
+	}
+}
+
+func TestFairQueueUnary_NoQueue(t *testing.T) {
+	ic := FairQueueUnary(policy.FairQueueRule{Limit: 1})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Get"}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := ic(t.Context(), nil, info, blockingHandler(entered, release))
+		done <- err
+	}()
+	<-entered
+
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// GroupIPOption customizes [GroupIPUnary] and [GroupIPStream].
+type GroupIPOption func(*groupIPState)
+
+// GroupIPClientAddr sets the resolver of the client IP that group rules are
+// evaluated against. By default the peer address is used as-is; pass the
+// server-wide *security.IPBlocker to honour its trusted proxies.
+func GroupIPClientAddr(r ClientAddrResolver) GroupIPOption {
+	return func(s *groupIPState) { s.addrs = r }
+}
+
+// GroupIPDryRun makes the interceptor report instead of reject requests when
+// def, or the [policy.Policy.DryRun] rule of their group, selects IPBlock.
+// def may be nil.
+func GroupIPDryRun(def *policy.DryRunRule) GroupIPOption {
+	return func(s *groupIPState) { s.dry = &dryRun{def: def, resolver: s.resolver} }
+}
+
+// groupIPState holds one filter per [policy.IPRule], built up front from the
+// groups of the resolver.
+type groupIPState struct {
+	resolver *policy.Resolver
+	filters  map[*policy.IPRule]*security.IPFilter
+	addrs    ClientAddrResolver
+	dry      *dryRun
+}
+
+func newGroupIPState(r *policy.Resolver, opts []GroupIPOption) *groupIPState {
+	st := &groupIPState{
+		resolver: r,
+		filters:  make(map[*policy.IPRule]*security.IPFilter),
+		addrs:    peerResolver{},
+	}
+	for _, pol := range r.Policies() {
+		if pol != nil && pol.IP != nil {
+			st.filters[pol.IP] = security.NewIPFilter(pol.IP.Allow, pol.IP.Deny)
+		}
+	}
+	for _, o := range opts {
+		o(st)
+	}
+	return st
+}
+
+// check applies the IP rule of the group matching fullMethod, if any, and
+// returns errBlocked for rejected requests that are neither exempt nor
+// shadowed. Requests whose client address cannot be determined are
+// rejected.
+func (s *groupIPState) check(ctx context.Context, fullMethod string) error {
+	if len(s.filters) == 0 {
+		return nil
+	}
+	_, pol, ok := s.resolver.Resolve(fullMethod)
+	if !ok || pol == nil || pol.IP == nil {
+		return nil
+	}
+	if bypass(ctx, middlewareIPBlock, fullMethod) {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if addr, ok := s.addrs.ClientAddr(ctx, md); ok && s.filters[pol.IP].Allowed(addr) {
+		return nil
+	}
+	return s.dry.enforce(ctx, middlewareIPBlock, fullMethod, pickIPBlock, errBlocked)
+}
+
+// GroupIPUnary returns a unary server interceptor that enforces the
+// [policy.Policy.IP] rules of the groups in r. Blocked requests receive
+// codes.PermissionDenied; methods of groups without a rule are not checked.
+func GroupIPUnary(r *policy.Resolver, opts ...GroupIPOption) grpc.UnaryServerInterceptor {
+	st := newGroupIPState(r, opts)
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if err := st.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GroupIPStream returns a stream server interceptor that enforces the
+// [policy.Policy.IP] rules of the groups in r, like [GroupIPUnary].
+func GroupIPStream(r *policy.Resolver, opts ...GroupIPOption) grpc.StreamServerInterceptor {
+	st := newGroupIPState(r, opts)
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := st.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
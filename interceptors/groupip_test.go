@@ -0,0 +1,104 @@
+package interceptors
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func peerAt(t *testing.T, ip string) context.Context {
+	return peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}})
+}
+
+func groupIPResolver() *policy.Resolver {
+	return policy.NewResolver(
+		policy.Group("admin").Prefix("/admin.").Policy(policy.Policy{
+			IP: &policy.IPRule{Allow: []netip.Prefix{netip.MustParsePrefix("10.8.0.0/16")}},
+		}),
+		policy.Group("public").Prefix("/public.").Policy(policy.Policy{
+			IP: &policy.IPRule{Deny: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}},
+		}),
+		policy.Group("open").Prefix("/open.").Policy(policy.Policy{AuthRequired: true}),
+	)
+}
+
+func TestGroupIP_EnforcesGroupRules(t *testing.T) {
+	ic := GroupIPUnary(groupIPResolver())
+	tests := []struct {
+		method, ip string
+		want       codes.Code
+	}{
+		{"/admin.Service/Delete", "10.8.1.2", codes.OK},
+		{"/admin.Service/Delete", "203.0.113.1", codes.PermissionDenied},
+		{"/public.Service/List", "203.0.113.1", codes.OK},
+		{"/public.Service/List", "192.0.2.9", codes.PermissionDenied},
+		{"/open.Service/List", "192.0.2.9", codes.OK},
+		{"/unmatched.Service/List", "192.0.2.9", codes.OK},
+	}
+	for _, tt := range tests {
+		info := &grpc.UnaryServerInfo{FullMethod: tt.method}
+		if _, err := ic(peerAt(t, tt.ip), nil, info, okHandler); codeOf(err) != tt.want {
+			t.Errorf("%s from %s: got %v, want %v", tt.method, tt.ip, err, tt.want)
+		}
+	}
+
+	// Without a peer the client address is unknown and the rule rejects.
+	info := &grpc.UnaryServerInfo{FullMethod: "/public.Service/List"}
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("request without peer: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestGroupIP_HonoursTrustedProxies(t *testing.T) {
+	b, err := security.NewIPBlocker(security.Config{Mode: security.DenyList, TrustedProxies: []string{"172.16.0.0/12"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := GroupIPUnary(groupIPResolver(), GroupIPClientAddr(b))
+	info := &grpc.UnaryServerInfo{FullMethod: "/admin.Service/Delete"}
+
+	ctx := metadata.NewIncomingContext(peerAt(t, "172.16.0.5"), metadata.Pairs("x-forwarded-for", "10.8.3.4"))
+	if _, err := ic(ctx, nil, info, okHandler); err != nil {
+		t.Fatalf("forwarded VPN client rejected: %v", err)
+	}
+	ctx = metadata.NewIncomingContext(peerAt(t, "172.16.0.5"), metadata.Pairs("x-forwarded-for", "203.0.113.1"))
+	if _, err := ic(ctx, nil, info, okHandler); codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("forwarded outside client: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestGroupIP_DryRunAndExemptions(t *testing.T) {
+	r := groupIPResolver()
+	info := &grpc.UnaryServerInfo{FullMethod: "/admin.Service/Delete"}
+	outside := peerAt(t, "203.0.113.1")
+
+	shadow := GroupIPUnary(r, GroupIPDryRun(&policy.DryRunRule{IPBlock: true}))
+	before := wouldReject(middlewareIPBlock, "admin")
+	if _, err := shadow(outside, nil, info, okHandler); err != nil {
+		t.Fatalf("dry run rejected: %v", err)
+	}
+	if got := wouldReject(middlewareIPBlock, "admin") - before; got != 1 {
+		t.Fatalf("would_reject = %v, want 1", got)
+	}
+
+	ex, err := security.NewExemptions(security.ExemptionConfig{CIDRs: []string{"203.0.113.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := ChainUnary([]grpc.UnaryServerInterceptor{ExemptUnary(ex), GroupIPUnary(r)})
+	beforeBypass := bypassed(middlewareIPBlock, security.ExemptCIDR)
+	if _, err := ic(outside, nil, info, okHandler); err != nil {
+		t.Fatalf("exempt request rejected: %v", err)
+	}
+	if got := bypassed(middlewareIPBlock, security.ExemptCIDR) - beforeBypass; got != 1 {
+		t.Fatalf("ipblock bypasses = %v, want 1", got)
+	}
+}
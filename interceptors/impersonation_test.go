@@ -0,0 +1,123 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func actorCtx(a contextx.Actor, md metadata.MD) context.Context {
+	return metadata.NewIncomingContext(contextx.WithActor(context.Background(), a), md)
+}
+
+func TestImpersonationUnary_GrantedWithScope(t *testing.T) {
+	ic := ImpersonationUnary(&policy.ImpersonationRule{
+		Scopes:            []string{"support:impersonate"},
+		CrossTenantScopes: []string{"support:any-tenant"},
+	}, nil)
+	support := contextx.Actor{Subject: "support-7", Tenant: "internal", Scopes: []string{"support:impersonate", "support:any-tenant"}}
+	ctx := actorCtx(support, metadata.Pairs("act-as", "customer-1", "act-as-tenant", "acme"))
+
+	var eff, orig contextx.Actor
+	_, err := ic(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}, func(ctx context.Context, _ any) (any, error) {
+		eff, _ = contextx.ActorFromContext(ctx)
+		orig, _ = contextx.ImpersonatorFromContext(ctx)
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if eff.Subject != "customer-1" || eff.Tenant != "acme" {
+		t.Fatalf("unexpected effective actor: %+v", eff)
+	}
+	if len(eff.Scopes) != 0 {
+		t.Fatalf("effective actor must not inherit scopes, got %v", eff.Scopes)
+	}
+	if orig.Subject != "support-7" {
+		t.Fatalf("unexpected impersonator: %+v", orig)
+	}
+}
+
+func TestImpersonationUnary_TenantSwitch(t *testing.T) {
+	ic := ImpersonationUnary(&policy.ImpersonationRule{
+		Scopes:            []string{"support:impersonate"},
+		CrossTenantScopes: []string{"support:any-tenant"},
+		Tenants:           []string{"demo"},
+	}, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}
+	plain := contextx.Actor{Subject: "support-7", Tenant: "internal", Scopes: []string{"support:impersonate"}}
+	tenantOf := func(ctx context.Context) (string, error) {
+		var tenant string
+		_, err := ic(ctx, nil, info, func(ctx context.Context, _ any) (any, error) {
+			a, _ := contextx.ActorFromContext(ctx)
+			tenant = a.Tenant
+			return "ok", nil
+		})
+		return tenant, err
+	}
+
+	// A plain impersonator cannot reach another tenant...
+	if _, err := tenantOf(actorCtx(plain, metadata.Pairs("act-as", "customer-1", "act-as-tenant", "acme"))); codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("cross-tenant impersonation: expected PermissionDenied, got %v", err)
+	}
+	// ...but stays in its own tenant or switches to an allow-listed one.
+	for _, tt := range []struct{ header, want string }{{"", "internal"}, {"internal", "internal"}, {"demo", "demo"}} {
+		md := metadata.Pairs("act-as", "customer-1")
+		if tt.header != "" {
+			md.Set("act-as-tenant", tt.header)
+		}
+		if got, err := tenantOf(actorCtx(plain, md)); err != nil || got != tt.want {
+			t.Fatalf("act-as-tenant %q: tenant %q, %v; want %q", tt.header, got, err, tt.want)
+		}
+	}
+}
+
+func TestImpersonationUnary_DeniedWithoutScope(t *testing.T) {
+	ic := ImpersonationUnary(&policy.ImpersonationRule{Scopes: []string{"support:impersonate"}}, nil)
+	ctx := actorCtx(contextx.Actor{Subject: "user-1"}, metadata.Pairs("act-as", "customer-1"))
+
+	_, err := ic(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}, okHandler)
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", codeOf(err))
+	}
+}
+
+func TestImpersonationUnary_NoHeaderPassthrough(t *testing.T) {
+	ic := ImpersonationUnary(nil, nil)
+	ctx := actorCtx(contextx.Actor{Subject: "user-1"}, nil)
+
+	_, err := ic(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}, func(ctx context.Context, _ any) (any, error) {
+		if _, ok := contextx.ImpersonatorFromContext(ctx); ok {
+			t.Fatal("request must not be marked as impersonated")
+		}
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestImpersonationUnary_GroupForbids(t *testing.T) {
+	resolver := policy.NewResolver(
+		policy.Group("billing").
+			Prefix("/billing.").
+			Policy(policy.Policy{Impersonation: &policy.ImpersonationRule{}}),
+	)
+	ic := ImpersonationUnary(&policy.ImpersonationRule{Scopes: []string{"support:impersonate"}}, resolver)
+	support := contextx.Actor{Subject: "support-7", Scopes: []string{"support:impersonate"}}
+	md := metadata.Pairs("act-as", "customer-1")
+
+	_, err := ic(actorCtx(support, md), nil, &grpc.UnaryServerInfo{FullMethod: "/billing.Invoices/Pay"}, okHandler)
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for billing group, got %v", codeOf(err))
+	}
+	_, err = ic(actorCtx(support, md), nil, &grpc.UnaryServerInfo{FullMethod: "/orders.Orders/Get"}, okHandler)
+	if err != nil {
+		t.Fatalf("default rule: unexpected error: %v", err)
+	}
+}
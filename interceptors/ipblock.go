@@ -3,6 +3,7 @@ package interceptors
 import (
 	"context"
 
+	"github.com/Keksclan/goRawrSquirrel/policy"
 	"github.com/Keksclan/goRawrSquirrel/security"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -13,18 +14,55 @@ import (
 // errBlocked is allocated once to avoid per-request allocations on the hot path.
 var errBlocked = status.Error(codes.PermissionDenied, "blocked")
 
+// IPBlockOption customizes [IPBlockUnary] and [IPBlockStream].
+type IPBlockOption func(*ipBlockState)
+
+// IPBlockDryRun makes the interceptor report instead of reject blocked
+// requests when def, or the [policy.Policy.DryRun] rule of the group r
+// resolves, selects IPBlock. Either may be nil.
+func IPBlockDryRun(def *policy.DryRunRule, r *policy.Resolver) IPBlockOption {
+	return func(s *ipBlockState) { s.dry = &dryRun{def: def, resolver: r} }
+}
+
+// ipBlockState holds the blocker and its optional dry-run rule.
+type ipBlockState struct {
+	blocker *security.IPBlocker
+	dry     *dryRun
+}
+
+// check evaluates the peer of ctx and returns errBlocked for denied
+// requests that are neither exempt nor shadowed.
+func (s *ipBlockState) check(ctx context.Context, fullMethod string) error {
+	if bypass(ctx, middlewareIPBlock, fullMethod) {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if s.blocker.Evaluate(ctx, md) {
+		return nil
+	}
+	return s.dry.enforce(ctx, middlewareIPBlock, fullMethod, pickIPBlock, errBlocked)
+}
+
+func newIPBlockState(b *security.IPBlocker, opts []IPBlockOption) *ipBlockState {
+	st := &ipBlockState{blocker: b}
+	for _, o := range opts {
+		o(st)
+	}
+	return st
+}
+
 // IPBlockUnary returns a unary server interceptor that denies requests when the
 // IPBlocker's Evaluate method returns false.
-func IPBlockUnary(b *security.IPBlocker) grpc.UnaryServerInterceptor {
+func IPBlockUnary(b *security.IPBlocker, opts ...IPBlockOption) grpc.UnaryServerInterceptor {
+	st := newIPBlockState(b, opts)
 	return func(
 		ctx context.Context,
 		req any,
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (any, error) {
-		md, _ := metadata.FromIncomingContext(ctx)
-		if !b.Evaluate(ctx, md) {
-			return nil, errBlocked
+		if err := st.check(ctx, info.FullMethod); err != nil {
+			return nil, err
 		}
 		return handler(ctx, req)
 	}
@@ -32,17 +70,16 @@ func IPBlockUnary(b *security.IPBlocker) grpc.UnaryServerInterceptor {
 
 // IPBlockStream returns a stream server interceptor that denies requests when
 // the IPBlocker's Evaluate method returns false.
-func IPBlockStream(b *security.IPBlocker) grpc.StreamServerInterceptor {
+func IPBlockStream(b *security.IPBlocker, opts ...IPBlockOption) grpc.StreamServerInterceptor {
+	st := newIPBlockState(b, opts)
 	return func(
 		srv any,
 		ss grpc.ServerStream,
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		ctx := ss.Context()
-		md, _ := metadata.FromIncomingContext(ctx)
-		if !b.Evaluate(ctx, md) {
-			return errBlocked
+		if err := st.check(ss.Context(), info.FullMethod); err != nil {
+			return err
 		}
 		return handler(srv, ss)
 	}
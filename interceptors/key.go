@@ -0,0 +1,53 @@
+package interceptors
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/security"
+	"google.golang.org/grpc/metadata"
+)
+
+// ClientAddrResolver resolves the effective client IP of a request. Both
+// *security.IPBlocker and *security.ClientResolver implement it.
+type ClientAddrResolver interface {
+	ClientAddr(ctx context.Context, md metadata.MD) (netip.Addr, bool)
+}
+
+// peerResolver resolves the client IP from the peer address only.
+type peerResolver struct{}
+
+func (peerResolver) ClientAddr(ctx context.Context, _ metadata.MD) (netip.Addr, bool) {
+	return security.PeerAddr(ctx)
+}
+
+// requestKey extracts the partition key selected by by. It returns "" when
+// the attribute is unavailable, which callers treat as one shared partition.
+func requestKey(ctx context.Context, by policy.KeyBy, addrs ClientAddrResolver) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	switch by {
+	case policy.KeyNone:
+		return ""
+	case policy.KeyPeerIP:
+		if addr, ok := addrs.ClientAddr(ctx, md); ok {
+			return addr.String()
+		}
+		return ""
+	case policy.KeySubject, policy.KeyTenant, policy.KeyClientID:
+		a, _ := contextx.ActorFromContext(ctx)
+		switch by {
+		case policy.KeySubject:
+			return a.Subject
+		case policy.KeyTenant:
+			return a.Tenant
+		default:
+			return a.ClientID
+		}
+	}
+	if name, ok := by.Metadata(); ok {
+		return firstValue(md, name)
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package interceptors
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/concurrency"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// HeaderCriticality is the request metadata key clients use to declare the
+// criticality of a call, e.g. "SHEDDABLE".
+const HeaderCriticality = "x-criticality"
+
+// retryPushbackTrailer tells gRPC clients with a retry policy how long to
+// wait before retrying.
+const retryPushbackTrailer = "grpc-retry-pushback-ms"
+
+// healthServicePrefix matches the standard gRPC health checking service,
+// which is never shed unless a group says otherwise.
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// shedThresholds maps a criticality to the load at which it is shed, so
+// that less critical traffic goes first. CriticalPlus is never shed.
+var shedThresholds = [...]float64{
+	policy.Sheddable:     0.8,
+	policy.SheddablePlus: 0.9,
+	policy.Critical:      1,
+	policy.CriticalPlus:  math.Inf(1),
+}
+
+// loadShedState holds the load meter, the policy resolver that assigns
+// criticality to groups, and the rejection, which is built once because the
+// pushback is fixed.
+type loadShedState struct {
+	shedder  *concurrency.Shedder
+	resolver *policy.Resolver
+	err      error
+	trailer  metadata.MD
+}
+
+func newLoadShedState(rule policy.LoadShedRule, r *policy.Resolver) *loadShedState {
+	retryAfter := rule.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	err := status.Error(codes.Unavailable, "server overloaded")
+	if st, e := status.New(codes.Unavailable, "server overloaded").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+	); e == nil {
+		err = st.Err()
+	}
+	return &loadShedState{
+		shedder: concurrency.NewShedder(concurrency.ShedConfig{
+			MaxInFlight:   rule.MaxInFlight,
+			TargetLatency: rule.TargetLatency,
+			Interval:      rule.Interval,
+		}),
+		resolver: r,
+		err:      err,
+		trailer:  metadata.Pairs(retryPushbackTrailer, strconv.FormatInt(retryAfter.Milliseconds(), 10)),
+	}
+}
+
+// criticality returns the criticality of a request: the group's, then
+// CriticalPlus for health checks, then the client's (capped at Critical),
+// and Critical by default.
+func (s *loadShedState) criticality(ctx context.Context, fullMethod string) policy.Criticality {
+	if s.resolver != nil {
+		if _, pol, ok := s.resolver.Resolve(fullMethod); ok && pol != nil && pol.Criticality != policy.CriticalityUnset {
+			return pol.Criticality
+		}
+	}
+	if strings.HasPrefix(fullMethod, healthServicePrefix) {
+		return policy.CriticalPlus
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(HeaderCriticality); len(v) > 0 {
+			if c, ok := policy.ParseCriticality(v[0]); ok {
+				return min(c, policy.Critical)
+			}
+		}
+	}
+	return policy.Critical
+}
+
+// admit admits the request or returns the rejection. setTrailer receives
+// the retry pushback of rejected requests. Only timed requests feed the
+// latency signal.
+func (s *loadShedState) admit(ctx context.Context, fullMethod string, timed bool, setTrailer func(metadata.MD)) (func(), error) {
+	c := s.criticality(ctx, fullMethod)
+	admit := s.shedder.AdmitUntimed
+	if timed {
+		admit = s.shedder.Admit
+	}
+	done, load, ok := admit(shedThresholds[c])
+	metrics.LoadShedLoad().Set(load)
+	if !ok {
+		metrics.LoadShedRejected().WithLabelValues(c.String()).Inc()
+		setTrailer(s.trailer)
+		return nil, s.err
+	}
+	return done, nil
+}
+
+// LoadShedUnary returns a unary server interceptor that rejects requests
+// under overload, least critical first, with codes.Unavailable. Rejections
+// carry RetryInfo and a grpc-retry-pushback-ms trailer so that clients back
+// off for rule.RetryAfter.
+//
+// The criticality of a request comes from the [policy.Policy.Criticality]
+// of its group, or else from the x-criticality metadata, where clients may
+// lower but not raise it above Critical. Health checks and groups marked
+// CriticalPlus are never shed.
+func LoadShedUnary(rule policy.LoadShedRule, r *policy.Resolver) grpc.UnaryServerInterceptor {
+	st := newLoadShedState(rule, r)
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		done, err := st.admit(ctx, info.FullMethod, true, func(md metadata.MD) { _ = grpc.SetTrailer(ctx, md) })
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+// LoadShedStream returns a stream server interceptor that sheds streams
+// like [LoadShedUnary]. Streams are metered separately from unary calls and
+// by in-flight count only: a stream's lifetime says nothing about load, so
+// rule.TargetLatency does not apply. A stream counts as in flight until the
+// handler returns.
+func LoadShedStream(rule policy.LoadShedRule, r *policy.Resolver) grpc.StreamServerInterceptor {
+	st := newLoadShedState(rule, r)
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		done, err := st.admit(ss.Context(), info.FullMethod, false, ss.SetTrailer)
+		if err != nil {
+			return err
+		}
+		defer done()
+		return handler(srv, ss)
+	}
+}
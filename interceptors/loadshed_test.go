@@ -0,0 +1,90 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func criticalityCtx(ctx context.Context, c string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(HeaderCriticality, c))
+}
+
+func TestLoadShedUnary_ShedsLeastCriticalFirst(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("admin").
+			Prefix("/admin.").
+			Policy(policy.Policy{Criticality: policy.CriticalPlus}),
+	)
+	ic := LoadShedUnary(policy.LoadShedRule{MaxInFlight: 10, RetryAfter: 2 * time.Second}, r)
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Get"}
+
+	// Occupy 8 of 10 slots: load 0.8.
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	for range 8 {
+		go func() {
+			_, err := ic(t.Context(), nil, info, blockingHandler(entered, release))
+			done <- err
+		}()
+		<-entered
+	}
+
+	_, err := ic(criticalityCtx(t.Context(), "SHEDDABLE"), nil, info, okHandler)
+	if codeOf(err) != codes.Unavailable {
+		t.Fatalf("sheddable: expected Unavailable, got %v", err)
+	}
+	var ri *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if v, ok := d.(*errdetails.RetryInfo); ok {
+			ri = v
+		}
+	}
+	if ri == nil || ri.GetRetryDelay().AsDuration() != 2*time.Second {
+		t.Fatalf("expected 2s RetryInfo, got %v", ri)
+	}
+
+	if _, err := ic(criticalityCtx(t.Context(), "SHEDDABLE_PLUS"), nil, info, okHandler); err != nil {
+		t.Fatalf("sheddable_plus at 0.8: %v", err)
+	}
+
+	// Fill up to 10: load 1.
+	for range 2 {
+		go func() {
+			_, err := ic(t.Context(), nil, info, blockingHandler(entered, release))
+			done <- err
+		}()
+		<-entered
+	}
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.Unavailable {
+		t.Fatalf("critical at full load: expected Unavailable, got %v", err)
+	}
+	// Clients cannot claim CriticalPlus.
+	if _, err := ic(criticalityCtx(t.Context(), "CRITICAL_PLUS"), nil, info, okHandler); codeOf(err) != codes.Unavailable {
+		t.Fatalf("client critical_plus: expected Unavailable, got %v", err)
+	}
+	// Admin and health methods are protected.
+	if _, err := ic(t.Context(), nil, &grpc.UnaryServerInfo{FullMethod: "/admin.Service/Drain"}, okHandler); err != nil {
+		t.Fatalf("admin: %v", err)
+	}
+	if _, err := ic(t.Context(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, okHandler); err != nil {
+		t.Fatalf("health: %v", err)
+	}
+
+	close(release)
+	for range 10 {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ic(criticalityCtx(t.Context(), "SHEDDABLE"), nil, info, okHandler); err != nil {
+		t.Fatalf("after recovery: %v", err)
+	}
+}
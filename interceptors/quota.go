@@ -0,0 +1,42 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/Keksclan/goRawrSquirrel/quota"
+	"google.golang.org/grpc"
+)
+
+// QuotaUnary returns a unary server interceptor that counts every call of an
+// authenticated tenant against its long-window quota in q. Calls over a
+// hard limit are rejected with codes.ResourceExhausted. Install it after
+// authentication so that the Actor's tenant is known.
+func QuotaUnary(q *quota.Quotas) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if err := q.Check(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// QuotaStream returns a stream server interceptor that counts every stream
+// of an authenticated tenant as one call against its quota in q.
+func QuotaStream(q *quota.Quotas) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := q.Check(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
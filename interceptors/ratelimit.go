@@ -2,71 +2,337 @@ package interceptors
 
 import (
 	"context"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/Keksclan/goRawrSquirrel/cache"
 	"github.com/Keksclan/goRawrSquirrel/policy"
 	"github.com/Keksclan/goRawrSquirrel/ratelimit"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 // errRateLimited is allocated once to avoid per-request allocations on the hot path.
+// It is returned only if attaching the RetryInfo and QuotaFailure details fails.
 var errRateLimited = status.Error(codes.ResourceExhausted, "rate limit exceeded")
 
-// rateLimitState holds the global limiter, an optional policy resolver, and a
-// cache of per-group limiters created lazily from resolved policies.
+// Response header keys set by [RateLimitHeaders].
+const (
+	HeaderRateLimitLimit     = "x-ratelimit-limit"
+	HeaderRateLimitRemaining = "x-ratelimit-remaining"
+	HeaderRateLimitReset     = "x-ratelimit-reset"
+)
+
+// Defaults for per-key buckets when a RateLimitRule leaves them unset.
+const (
+	defaultMaxKeys     = 10_000
+	defaultIdleTimeout = 10 * time.Minute
+)
+
+// rateLimitStage restricts which groups an interceptor instance handles.
+type rateLimitStage int
+
+const (
+	stageAll      rateLimitStage = iota // every group and the global limiter
+	stagePreAuth                        // skip groups keyed by an Actor attribute
+	stagePostAuth                       // only groups keyed by an Actor attribute
+)
+
+// RateLimitOption customizes [RateLimitUnary] and [RateLimitStream].
+type RateLimitOption func(*rateLimitState)
+
+// RateLimitClientAddr sets the resolver used for groups keyed by
+// [policy.KeyPeerIP]. By default the peer address is used as-is; pass an
+// *security.IPBlocker or *security.ClientResolver to honour trusted proxies.
+func RateLimitClientAddr(r ClientAddrResolver) RateLimitOption {
+	return func(s *rateLimitState) { s.addrs = r }
+}
+
+// RateLimitRedis makes per-group and per-key limiters share their buckets
+// across replicas through Redis, reusing the connection of l2. Bucket keys
+// are "group:<name>" and "group:<name>:<key>" under cfg.Prefix. The global
+// limiter passed to [RateLimitUnary] is used as-is; create it with
+// [ratelimit.NewRedisLimiter] to distribute it as well.
+func RateLimitRedis(l2 *cache.L2, cfg ratelimit.RedisConfig) RateLimitOption {
+	return func(s *rateLimitState) {
+		s.l2 = l2
+		s.redisCfg = cfg
+	}
+}
+
+// RateLimitHeaders makes the interceptor send x-ratelimit-limit,
+// x-ratelimit-remaining and x-ratelimit-reset (seconds until the bucket is
+// full) response headers on every request it limits.
+func RateLimitHeaders() RateLimitOption {
+	return func(s *rateLimitState) { s.headers = true }
+}
+
+// RateLimitCost sets the cost of requests whose group has no Cost rule of
+// its own. By default every request costs one token.
+func RateLimitCost(def *policy.CostRule) RateLimitOption {
+	return func(s *rateLimitState) { s.cost = def }
+}
+
+// RateLimitDryRun makes the interceptor report instead of reject requests
+// over the limit when def, or the [policy.Policy.DryRun] rule of their group,
+// selects RateLimit. def may be nil to use only the group rules.
+func RateLimitDryRun(def *policy.DryRunRule) RateLimitOption {
+	return func(s *rateLimitState) { s.dry = &dryRun{def: def} }
+}
+
+// RateLimitWarmUp scales the global, group and per-key limiters by the
+// factor of r, so that limits ramp up to their configured rate during a
+// warm-up period. Global algorithms that do not implement
+// [ratelimit.Scaler] are left as they are.
+func RateLimitWarmUp(r *warmup.Ramp) RateLimitOption {
+	return func(s *rateLimitState) { s.ramp = r }
+}
+
+// RateLimitPreAuth makes the interceptor skip groups whose rule is keyed by
+// an Actor attribute (subject, tenant, client ID), because no Actor exists
+// before authentication. Pair it with a [RateLimitPostAuth] instance.
+//
+// When [ExemptUnary] has subject or client ID rules, a request over the
+// global or group limit is not rejected right away, because its Actor may
+// still exempt it; the RateLimitPostAuth stage rejects it after
+// authentication unless it is exempt.
+func RateLimitPreAuth() RateLimitOption {
+	return func(s *rateLimitState) { s.stage = stagePreAuth }
+}
+
+// RateLimitPostAuth makes the interceptor handle only groups whose rule is
+// keyed by an Actor attribute, and settle the rejections a [RateLimitPreAuth]
+// stage held back for Actor exemptions. The global limiter is never
+// consulted. Install it after authentication.
+func RateLimitPostAuth() RateLimitOption {
+	return func(s *rateLimitState) { s.stage = stagePostAuth }
+}
+
+// rateLimitState holds the global limiter, an optional policy resolver, and
+// caches of per-group limiters and per-key pools created lazily from
+// resolved policies.
 type rateLimitState struct {
-	global   *ratelimit.Limiter
+	global   ratelimit.Algorithm
 	resolver *policy.Resolver
+	addrs    ClientAddrResolver
+	stage    rateLimitStage
+	headers  bool
+	cost     *policy.CostRule
+	l2       *cache.L2
+	redisCfg ratelimit.RedisConfig
+	dry      *dryRun
+	ramp     *warmup.Ramp
 
 	mu     sync.Mutex
 	groups map[string]*ratelimit.Limiter
+	pools  map[string]*ratelimit.Pool
+}
+
+// newRateLimitState applies opts on top of the defaults.
+func newRateLimitState(l ratelimit.Algorithm, r *policy.Resolver, opts []RateLimitOption) *rateLimitState {
+	st := &rateLimitState{
+		global:   l,
+		resolver: r,
+		addrs:    peerResolver{},
+		groups:   make(map[string]*ratelimit.Limiter),
+		pools:    make(map[string]*ratelimit.Pool),
+	}
+	for _, o := range opts {
+		o(st)
+	}
+	if st.dry != nil {
+		st.dry.resolver = r
+	}
+	return st
+}
+
+// limitScope identifies the bucket a request was charged to. It is turned
+// into a QuotaFailure subject only when the request is rejected.
+type limitScope struct {
+	group, key string
+	keyed      bool
 }
 
-// limiterFor returns the per-group limiter when the resolver matches
-// fullMethod to a group with a RateLimit policy. Otherwise it returns the
-// global limiter.
-func (s *rateLimitState) limiterFor(fullMethod string) *ratelimit.Limiter {
+// subject returns "global", "group:<name>" or "group:<name>:<key>".
+func (sc limitScope) subject() string {
+	switch {
+	case sc.group == "":
+		return "global"
+	case sc.keyed:
+		return "group:" + sc.group + ":" + sc.key
+	}
+	return "group:" + sc.group
+}
+
+// limiterFor returns the limiter that applies to the request: the per-key
+// limiter when the resolved group's rule is keyed, the per-group limiter
+// when it is not, and the global limiter when no group rule matches. It
+// returns nil when this stage does not handle the request. cost is the
+// resolved group's Cost rule, or the default one.
+func (s *rateLimitState) limiterFor(ctx context.Context, fullMethod string) (l ratelimit.Algorithm, scope limitScope, cost *policy.CostRule) {
+	cost = s.cost
 	if s.resolver != nil {
-		if _, pol, ok := s.resolver.Resolve(fullMethod); ok && pol != nil && pol.RateLimit != nil {
-			return s.groupLimiter(fullMethod, pol.RateLimit)
+		if name, pol, ok := s.resolver.Resolve(fullMethod); ok && pol != nil {
+			if pol.Cost != nil {
+				cost = pol.Cost
+			}
+			if rl := pol.RateLimit; rl != nil {
+				switch {
+				case s.stage == stagePreAuth && rl.Key.NeedsActor(),
+					s.stage == stagePostAuth && !rl.Key.NeedsActor():
+					return nil, limitScope{}, nil
+				case rl.Key != policy.KeyNone:
+					key := requestKey(ctx, rl.Key, s.addrs)
+					return s.pool(name, rl).Get(key), limitScope{group: name, key: key, keyed: true}, cost
+				}
+				return s.groupLimiter(name, rl), limitScope{group: name}, cost
+			}
 		}
 	}
-	return s.global
+	if s.stage == stagePostAuth {
+		return nil, limitScope{}, nil
+	}
+	return s.global, limitScope{}, cost
 }
 
 // groupLimiter returns (or lazily creates) a per-group limiter keyed by the
 // resolved group name.
-func (s *rateLimitState) groupLimiter(fullMethod string, rl *policy.RateLimitRule) *ratelimit.Limiter {
-	// Resolve again to get the group name (cheap — no allocations).
-	name, _, _ := s.resolver.Resolve(fullMethod)
-
+func (s *rateLimitState) groupLimiter(name string, rl *policy.RateLimitRule) *ratelimit.Limiter {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-
 	if l, ok := s.groups[name]; ok {
 		return l
 	}
-	l := ratelimit.NewLimiter(float64(rl.Rate)/rl.Window.Seconds(), rl.Rate)
+	rps := float64(rl.Rate) / rl.Window.Seconds()
+	var l *ratelimit.Limiter
+	if s.l2 != nil {
+		l = ratelimit.NewRedisLimiter(s.l2.Client(), "group:"+name, rps, rl.Rate, s.redisCfg)
+	} else {
+		l = ratelimit.NewLimiter(rps, rl.Rate)
+	}
 	s.groups[name] = l
 	return l
 }
 
+// pool returns (or lazily creates) the per-key limiter pool of a group.
+func (s *rateLimitState) pool(name string, rl *policy.RateLimitRule) *ratelimit.Pool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pools[name]; ok {
+		return p
+	}
+	maxKeys, idle := rl.MaxKeys, rl.IdleTimeout
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	rps := float64(rl.Rate) / rl.Window.Seconds()
+	var p *ratelimit.Pool
+	if s.l2 != nil {
+		p = ratelimit.NewRedisPool(s.l2.Client(), "group:"+name+":", rps, rl.Rate, maxKeys, idle, s.redisCfg)
+	} else {
+		p = ratelimit.NewPool(rps, rl.Rate, maxKeys, idle)
+	}
+	s.pools[name] = p
+	return p
+}
+
+// check charges the request, weighted by its cost, to its limiter. req is
+// nil for streams. It returns nil when the request may proceed and the
+// rejection status otherwise. When headers are enabled, setHeader receives
+// the quota headers in either case. Exempt requests are neither charged nor
+// sent headers.
+func (s *rateLimitState) check(ctx context.Context, fullMethod string, req any, setHeader func(metadata.MD) error) error {
+	if s.stage == stagePostAuth {
+		if err := settleDeferred(ctx, middlewareRateLimit, fullMethod); err != nil {
+			return err
+		}
+	}
+	l, scope, cost := s.limiterFor(ctx, fullMethod)
+	if l == nil || bypass(ctx, middlewareRateLimit, fullMethod) {
+		return nil
+	}
+	if sc, ok := l.(ratelimit.Scaler); ok && s.ramp != nil {
+		sc.SetScale(s.ramp.Factor())
+	}
+	r := l.ReserveN(ctx, cost.Cost(fullMethod, req))
+	if s.headers {
+		_ = setHeader(quotaHeaders(r))
+	}
+	if r.OK {
+		return nil
+	}
+	err := s.dry.enforce(ctx, middlewareRateLimit, fullMethod, pickRateLimit, rateLimitError(r, scope.subject()))
+	if err != nil && s.stage == stagePreAuth && deferForActor(ctx, err) {
+		return nil
+	}
+	return err
+}
+
+// quotaHeaders renders r as x-ratelimit-* response headers.
+func quotaHeaders(r ratelimit.Reservation) metadata.MD {
+	return metadata.Pairs(
+		HeaderRateLimitLimit, strconv.Itoa(r.Limit),
+		HeaderRateLimitRemaining, strconv.Itoa(r.Remaining),
+		HeaderRateLimitReset, strconv.FormatInt(int64((r.ResetAfter+time.Second-1)/time.Second), 10),
+	)
+}
+
+// rateLimitError builds a ResourceExhausted status carrying RetryInfo and a
+// QuotaFailure for subject, so that clients know when to retry and which
+// quota they exceeded.
+func rateLimitError(r ratelimit.Reservation, subject string) error {
+	// A request that can never be admitted gets no RetryInfo: retrying
+	// would not help.
+	if r.RetryAfter == ratelimit.InfDuration {
+		return quotaError(subject, "request cost exceeds bucket of "+strconv.Itoa(r.Limit)+" tokens")
+	}
+	return quotaError(subject, "bucket of "+strconv.Itoa(r.Limit)+" tokens exhausted",
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(r.RetryAfter)})
+}
+
+// quotaError builds a ResourceExhausted status with a QuotaFailure for
+// subject followed by details.
+func quotaError(subject, description string, details ...protoadapt.MessageV1) error {
+	details = append(details, &errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{
+		Subject:     subject,
+		Description: description,
+	}}})
+	st, err := status.New(codes.ResourceExhausted, "rate limit exceeded").WithDetails(details...)
+	if err != nil {
+		return errRateLimited
+	}
+	return st.Err()
+}
+
 // RateLimitUnary returns a unary server interceptor that rejects requests when
 // the applicable rate limiter has been exhausted. When a policy resolver is
 // provided and the method matches a group with a RateLimit rule, that
-// per-group limiter is used; otherwise the global limiter applies.
-func RateLimitUnary(l *ratelimit.Limiter, r *policy.Resolver) grpc.UnaryServerInterceptor {
-	st := &rateLimitState{global: l, resolver: r, groups: make(map[string]*ratelimit.Limiter)}
+// per-group limiter (or, for keyed rules, the caller's per-key limiter) is
+// used; otherwise the global limiter applies. l may be any
+// [ratelimit.Algorithm], or nil to disable the global limit. Group limits
+// always use token buckets.
+func RateLimitUnary(l ratelimit.Algorithm, r *policy.Resolver, opts ...RateLimitOption) grpc.UnaryServerInterceptor {
+	st := newRateLimitState(l, r, opts)
 	return func(
 		ctx context.Context,
 		req any,
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (any, error) {
-		if !st.limiterFor(info.FullMethod).Allow() {
-			return nil, errRateLimited
+		if err := st.check(ctx, info.FullMethod, req, func(md metadata.MD) error {
+			return grpc.SetHeader(ctx, md)
+		}); err != nil {
+			return nil, err
 		}
 		return handler(ctx, req)
 	}
@@ -74,16 +340,16 @@ func RateLimitUnary(l *ratelimit.Limiter, r *policy.Resolver) grpc.UnaryServerIn
 
 // RateLimitStream returns a stream server interceptor that rejects requests
 // when the applicable rate limiter has been exhausted.
-func RateLimitStream(l *ratelimit.Limiter, r *policy.Resolver) grpc.StreamServerInterceptor {
-	st := &rateLimitState{global: l, resolver: r, groups: make(map[string]*ratelimit.Limiter)}
+func RateLimitStream(l ratelimit.Algorithm, r *policy.Resolver, opts ...RateLimitOption) grpc.StreamServerInterceptor {
+	st := newRateLimitState(l, r, opts)
 	return func(
 		srv any,
 		ss grpc.ServerStream,
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		if !st.limiterFor(info.FullMethod).Allow() {
-			return errRateLimited
+		if err := st.check(ss.Context(), info.FullMethod, nil, ss.SetHeader); err != nil {
+			return err
 		}
 		return handler(srv, ss)
 	}
@@ -2,13 +2,20 @@ package interceptors
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
 	"github.com/Keksclan/goRawrSquirrel/policy"
 	"github.com/Keksclan/goRawrSquirrel/ratelimit"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -44,6 +51,35 @@ func TestRateLimitUnary_GlobalOnly(t *testing.T) {
 	}
 }
 
+func TestRateLimitUnary_CustomAlgorithm(t *testing.T) {
+	now := time.Unix(960_000, 0)
+	global := ratelimit.NewFixedWindow(2, time.Minute, ratelimit.WithClock(func() time.Time { return now }))
+	ic := RateLimitUnary(global, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+
+	for range 2 {
+		if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(30 * time.Second)
+	_, err := ic(t.Context(), nil, info, okHandler)
+	var ri *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if v, ok := d.(*errdetails.RetryInfo); ok {
+			ri = v
+		}
+	}
+	if ri == nil || ri.GetRetryDelay().AsDuration() != 30*time.Second {
+		t.Fatalf("expected RetryInfo until the window ends, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+		t.Fatalf("next window: %v", err)
+	}
+}
+
 func TestRateLimitUnary_PerGroupOverridesGlobal(t *testing.T) {
 	// Global: burst=100 (very generous).
 	global := ratelimit.NewLimiter(1000, 100)
@@ -124,3 +160,246 @@ func TestRateLimitUnary_ExactBeatsPrefixPolicy(t *testing.T) {
 		}
 	}
 }
+
+func keyedResolver(by policy.KeyBy) *policy.Resolver {
+	return policy.NewResolver(
+		policy.Group("keyed").
+			Prefix("/api.Service/").
+			Policy(policy.Policy{
+				RateLimit: &policy.RateLimitRule{Rate: 1, Window: time.Minute, Key: by},
+			}),
+	)
+}
+
+func TestRateLimitUnary_PerPeerIP(t *testing.T) {
+	ic := RateLimitUnary(nil, keyedResolver(policy.KeyPeerIP))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Do"}
+
+	a := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}})
+	b := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1}})
+
+	if _, err := ic(a, nil, info, okHandler); err != nil {
+		t.Fatalf("first request from a: %v", err)
+	}
+	if _, err := ic(a, nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("second request from a: expected ResourceExhausted, got %v", codeOf(err))
+	}
+	if _, err := ic(b, nil, info, okHandler); err != nil {
+		t.Fatalf("b must have its own bucket: %v", err)
+	}
+}
+
+func TestRateLimitUnary_PerMetadataKey(t *testing.T) {
+	ic := RateLimitUnary(nil, keyedResolver(policy.KeyMetadata("X-API-Key")))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Do"}
+
+	k1 := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-api-key", "k1"))
+	k2 := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-api-key", "k2"))
+
+	if _, err := ic(k1, nil, info, okHandler); err != nil {
+		t.Fatalf("k1: %v", err)
+	}
+	if _, err := ic(k1, nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("k1 again: expected ResourceExhausted, got %v", codeOf(err))
+	}
+	if _, err := ic(k2, nil, info, okHandler); err != nil {
+		t.Fatalf("k2: %v", err)
+	}
+}
+
+func TestRateLimitUnary_ActorKeyStages(t *testing.T) {
+	r := keyedResolver(policy.KeySubject)
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Do"}
+
+	// The pre-auth stage must ignore actor-keyed groups entirely.
+	pre := RateLimitUnary(ratelimit.NewLimiter(0.001, 1), r, RateLimitPreAuth())
+	for i := range 3 {
+		if _, err := pre(t.Context(), nil, info, okHandler); err != nil {
+			t.Fatalf("pre-auth request %d: %v", i, err)
+		}
+	}
+
+	post := RateLimitUnary(nil, r, RateLimitPostAuth())
+	alice := contextx.WithActor(t.Context(), contextx.Actor{Subject: "alice"})
+	bob := contextx.WithActor(t.Context(), contextx.Actor{Subject: "bob"})
+
+	if _, err := post(alice, nil, info, okHandler); err != nil {
+		t.Fatalf("alice: %v", err)
+	}
+	if _, err := post(alice, nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("alice again: expected ResourceExhausted, got %v", codeOf(err))
+	}
+	if _, err := post(bob, nil, info, okHandler); err != nil {
+		t.Fatalf("bob: %v", err)
+	}
+
+	// The post-auth stage never consults the global limiter.
+	other := &grpc.UnaryServerInfo{FullMethod: "/other.Service/Do"}
+	for i := range 3 {
+		if _, err := post(t.Context(), nil, other, okHandler); err != nil {
+			t.Fatalf("unmatched request %d: %v", i, err)
+		}
+	}
+}
+
+func TestRateLimitUnary_RedisFallsBackToLocal(t *testing.T) {
+	l2 := cache.NewL2("localhost:1", "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+
+	resolver := policy.NewResolver(
+		policy.Group("heavy").
+			Exact("/api.Service/Heavy").
+			Policy(policy.Policy{
+				RateLimit: &policy.RateLimitRule{Rate: 1, Window: time.Minute},
+			}),
+	)
+	ic := RateLimitUnary(nil, resolver, RateLimitRedis(l2, ratelimit.RedisConfig{Timeout: 20 * time.Millisecond}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Heavy"}
+
+	if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if _, err := ic(t.Context(), nil, info, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the local fallback to limit, got %v", codeOf(err))
+	}
+}
+
+// headerRecorder is a grpc.ServerTransportStream that records headers.
+type headerRecorder struct{ md metadata.MD }
+
+func (h *headerRecorder) Method() string { return "" }
+func (h *headerRecorder) SetHeader(md metadata.MD) error {
+	h.md = metadata.Join(h.md, md)
+	return nil
+}
+func (h *headerRecorder) SendHeader(md metadata.MD) error { return h.SetHeader(md) }
+func (h *headerRecorder) SetTrailer(metadata.MD) error    { return nil }
+
+func TestRateLimitUnary_RejectionCarriesDetails(t *testing.T) {
+	ic := RateLimitUnary(nil, keyedResolver(policy.KeyMetadata("x-api-key")))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Do"}
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-api-key", "k1"))
+
+	if _, err := ic(ctx, nil, info, okHandler); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	_, err := ic(ctx, nil, info, okHandler)
+	st, _ := status.FromError(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", st.Code())
+	}
+
+	var retry *errdetails.RetryInfo
+	var quota *errdetails.QuotaFailure
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.RetryInfo:
+			retry = d
+		case *errdetails.QuotaFailure:
+			quota = d
+		}
+	}
+	if retry == nil || retry.GetRetryDelay().AsDuration() <= 0 {
+		t.Fatalf("expected positive RetryInfo delay, got %v", retry)
+	}
+	if quota == nil || len(quota.GetViolations()) != 1 ||
+		quota.GetViolations()[0].GetSubject() != "group:keyed:k1" {
+		t.Fatalf("unexpected QuotaFailure: %v", quota)
+	}
+}
+
+func TestRateLimitUnary_QuotaHeaders(t *testing.T) {
+	ic := RateLimitUnary(ratelimit.NewLimiter(1, 3), nil, RateLimitHeaders())
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+
+	rec := &headerRecorder{}
+	ctx := grpc.NewContextWithServerTransportStream(t.Context(), rec)
+	if _, err := ic(ctx, nil, info, okHandler); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		HeaderRateLimitLimit:     "3",
+		HeaderRateLimitRemaining: "2",
+		HeaderRateLimitReset:     "1",
+	}
+	for k, v := range want {
+		if got := rec.md.Get(k); len(got) != 1 || got[0] != v {
+			t.Errorf("%s = %v, want %s", k, got, v)
+		}
+	}
+}
+
+func TestRateLimitUnary_CostWeighted(t *testing.T) {
+	resolver := policy.NewResolver(
+		policy.Group("api").
+			Prefix("/api.Service/").
+			Policy(policy.Policy{
+				RateLimit: &policy.RateLimitRule{Rate: 10, Window: time.Hour},
+				Cost: &policy.CostRule{
+					Methods: map[string]int{"/api.Service/Export": 8},
+					Func: func(_ string, req any) int {
+						if items, ok := req.([]string); ok {
+							return len(items)
+						}
+						return 0
+					},
+				},
+			}),
+	)
+	ic := RateLimitUnary(nil, resolver)
+	export := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Export"}
+	get := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Get"}
+	batch := &grpc.UnaryServerInfo{FullMethod: "/api.Service/Batch"}
+
+	if _, err := ic(t.Context(), nil, export, okHandler); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	// 2 tokens left: a batch of 3 is rejected, a Get passes.
+	if _, err := ic(t.Context(), []string{"a", "b", "c"}, batch, okHandler); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("batch of 3: expected ResourceExhausted, got %v", codeOf(err))
+	}
+	if _, err := ic(t.Context(), nil, get, okHandler); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+}
+
+func TestRateLimitUnary_CostAboveBurstHasNoRetryInfo(t *testing.T) {
+	ic := RateLimitUnary(ratelimit.NewLimiter(1, 5), nil, RateLimitCost(&policy.CostRule{Default: 6}))
+	_, err := ic(t.Context(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}, okHandler)
+	st, _ := status.FromError(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", st.Code())
+	}
+	for _, d := range st.Details() {
+		if _, ok := d.(*errdetails.RetryInfo); ok {
+			t.Fatal("a request that can never pass must not carry RetryInfo")
+		}
+	}
+}
+
+func TestRateLimitUnary_WarmUp(t *testing.T) {
+	now := time.Unix(960_000, 0)
+	clock := func() time.Time { return now }
+	ramp := warmup.New(warmup.Config{Duration: time.Minute, From: 0.2, Now: clock})
+	global := ratelimit.NewFixedWindow(10, time.Hour, ratelimit.WithClock(clock))
+	ic := RateLimitUnary(global, nil, RateLimitWarmUp(ramp))
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+
+	admit := func() int {
+		n := 0
+		for {
+			if _, err := ic(t.Context(), nil, info, okHandler); err != nil {
+				return n
+			}
+			n++
+		}
+	}
+	if n := admit(); n != 2 {
+		t.Fatalf("admitted %d at the start of the ramp, want 2", n)
+	}
+	now = now.Add(time.Minute)
+	if n := admit(); n != 8 {
+		t.Fatalf("admitted %d more after the ramp, want 8", n)
+	}
+}
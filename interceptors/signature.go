@@ -0,0 +1,80 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/Keksclan/goRawrSquirrel/auth/hmacsig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SignatureOption customizes [SignatureUnary] and [SignatureStream].
+type SignatureOption func(*signatureState)
+
+// SignatureOptional lets requests that carry no signature headers at all
+// through unchanged, so that a later [AuthUnary] or [AuthStream] can
+// authenticate them by other means such as a bearer token. Requests that
+// carry any signature header are still verified in full.
+func SignatureOptional() SignatureOption {
+	return func(s *signatureState) { s.optional = true }
+}
+
+// signatureState holds the verifier and its options.
+type signatureState struct {
+	v        *hmacsig.Verifier
+	optional bool
+}
+
+// verify checks the signature of a call to fullMethod carrying msg and
+// returns the context to continue with.
+func (s *signatureState) verify(ctx context.Context, fullMethod string, msg any) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if s.optional && !hmacsig.IsSigned(md) {
+		return ctx, nil
+	}
+	return s.v.Verify(ctx, fullMethod, md, msg)
+}
+
+func newSignatureState(v *hmacsig.Verifier, opts []SignatureOption) *signatureState {
+	st := &signatureState{v: v}
+	for _, o := range opts {
+		o(st)
+	}
+	return st
+}
+
+// SignatureUnary returns a unary server interceptor that verifies the HMAC
+// signature over the request message before forwarding to the handler.
+func SignatureUnary(v *hmacsig.Verifier, opts ...SignatureOption) grpc.UnaryServerInterceptor {
+	st := newSignatureState(v, opts)
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		newCtx, err := st.verify(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(newCtx, req)
+	}
+}
+
+// SignatureStream returns a stream server interceptor that verifies the HMAC
+// signature when the stream is opened. Streams are signed without a body.
+func SignatureStream(v *hmacsig.Verifier, opts ...SignatureOption) grpc.StreamServerInterceptor {
+	st := newSignatureState(v, opts)
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		newCtx, err := st.verify(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: newCtx})
+	}
+}
@@ -0,0 +1,197 @@
+package interceptors
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// StreamLimitOption customizes [StreamMessageLimit].
+type StreamLimitOption func(*streamLimitState)
+
+// StreamLimitClientAddr sets the resolver used for rules keyed by
+// [policy.KeyPeerIP]. By default the peer address is used as-is; pass an
+// *security.IPBlocker or *security.ClientResolver to honour trusted proxies.
+func StreamLimitClientAddr(r ClientAddrResolver) StreamLimitOption {
+	return func(s *streamLimitState) { s.addrs = r }
+}
+
+// StreamLimitDryRun makes the interceptor report instead of reject messages
+// when def, or the [policy.Policy.DryRun] rule of their group, selects
+// RateLimit. Blocking rules do not wait in dry-run mode either. def may be
+// nil.
+func StreamLimitDryRun(def *policy.DryRunRule) StreamLimitOption {
+	return func(s *streamLimitState) { s.dry = &dryRun{def: def, resolver: s.resolver} }
+}
+
+// streamLimitState holds the server-wide default rule, an optional policy
+// resolver whose groups may override it, and the per-key pools of keyed
+// rules. Pools are indexed by rule so that the default and every group
+// override get their own.
+type streamLimitState struct {
+	def      *policy.StreamRateLimitRule
+	resolver *policy.Resolver
+	addrs    ClientAddrResolver
+	dry      *dryRun
+
+	mu    sync.Mutex
+	pools map[*policy.StreamRateLimitRule]*streamPools
+}
+
+// streamPools holds the receive and send buckets of a keyed rule.
+type streamPools struct {
+	recv, send *ratelimit.Pool
+}
+
+// ruleFor returns the group's StreamRateLimit rule when the resolver matches
+// fullMethod to a group that defines one. Otherwise it returns the default.
+func (s *streamLimitState) ruleFor(fullMethod string) (string, *policy.StreamRateLimitRule) {
+	if s.resolver != nil {
+		if name, pol, ok := s.resolver.Resolve(fullMethod); ok && pol != nil && pol.StreamRateLimit != nil {
+			return name, pol.StreamRateLimit
+		}
+	}
+	return "", s.def
+}
+
+// rateAndBurst converts rule into limiter parameters.
+func rateAndBurst(rule *policy.StreamRateLimitRule) (float64, int) {
+	burst := rule.Burst
+	if burst <= 0 {
+		burst = rule.Rate
+	}
+	return float64(rule.Rate) / rule.Window.Seconds(), burst
+}
+
+// limiters returns the receive and (if rule.Send) send limiters for a new
+// stream: shared per-key limiters for keyed rules, fresh ones otherwise.
+func (s *streamLimitState) limiters(ctx context.Context, rule *policy.StreamRateLimitRule) (recv, send *ratelimit.Limiter, key string) {
+	rps, burst := rateAndBurst(rule)
+	if rule.Key == policy.KeyNone {
+		recv = ratelimit.NewLimiter(rps, burst)
+		if rule.Send {
+			send = ratelimit.NewLimiter(rps, burst)
+		}
+		return recv, send, ""
+	}
+
+	s.mu.Lock()
+	p, ok := s.pools[rule]
+	if !ok {
+		p = &streamPools{recv: ratelimit.NewPool(rps, burst, defaultMaxKeys, defaultIdleTimeout)}
+		if rule.Send {
+			p.send = ratelimit.NewPool(rps, burst, defaultMaxKeys, defaultIdleTimeout)
+		}
+		s.pools[rule] = p
+	}
+	s.mu.Unlock()
+
+	key = requestKey(ctx, rule.Key, s.addrs)
+	recv = p.recv.Get(key)
+	if p.send != nil {
+		send = p.send.Get(key)
+	}
+	return recv, send, key
+}
+
+// rateLimitedStream charges every received (and optionally sent) message to
+// a token bucket.
+type rateLimitedStream struct {
+	grpc.ServerStream
+	recv, send *ratelimit.Limiter
+	block      bool
+	scope      limitScope
+
+	// shadow reports rejections instead of failing the stream; group and
+	// method label the reports.
+	shadow        bool
+	group, method string
+}
+
+// take charges one message to l, waiting for a token in blocking mode.
+func (s *rateLimitedStream) take(l *ratelimit.Limiter) error {
+	ctx := s.Context()
+	if s.shadow {
+		if r := l.Reserve(ctx); !r.OK {
+			reportWouldReject(ctx, middlewareRateLimit, s.group, s.method, rateLimitError(r, "stream:"+s.scope.subject()))
+		}
+		return nil
+	}
+	if s.block {
+		if err := l.Wait(ctx); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		return nil
+	}
+	if r := l.Reserve(ctx); !r.OK {
+		return rateLimitError(r, "stream:"+s.scope.subject())
+	}
+	return nil
+}
+
+// RecvMsg charges a message once it has been received, so that io.EOF at
+// the end of the stream and failed receives cost nothing.
+func (s *rateLimitedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.take(s.recv)
+}
+
+func (s *rateLimitedStream) SendMsg(m any) error {
+	if s.send != nil {
+		if err := s.take(s.send); err != nil {
+			return err
+		}
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// StreamMessageLimit returns a stream server interceptor that rate-limits
+// the messages of each stream. def applies to every streaming method unless
+// the resolver matches a group with its own StreamRateLimit rule; either may
+// be nil.
+//
+// Without a Key each stream gets its own bucket; with a Key all streams of
+// the same caller share one. When the bucket is empty the stream either
+// waits (rule.Block) or fails with codes.ResourceExhausted carrying
+// RetryInfo and QuotaFailure details. Streams exempted by [ExemptStream] are
+// not limited.
+func StreamMessageLimit(def *policy.StreamRateLimitRule, r *policy.Resolver, opts ...StreamLimitOption) grpc.StreamServerInterceptor {
+	st := &streamLimitState{
+		def:      def,
+		resolver: r,
+		addrs:    peerResolver{},
+		pools:    make(map[*policy.StreamRateLimitRule]*streamPools),
+	}
+	for _, o := range opts {
+		o(st)
+	}
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		name, rule := st.ruleFor(info.FullMethod)
+		if rule == nil || bypass(ss.Context(), middlewareRateLimit, info.FullMethod) {
+			return handler(srv, ss)
+		}
+		recv, send, key := st.limiters(ss.Context(), rule)
+		group, shadow := st.dry.shadowed(info.FullMethod, pickRateLimit)
+		return handler(srv, &rateLimitedStream{
+			ServerStream: ss,
+			recv:         recv,
+			send:         send,
+			block:        rule.Block,
+			scope:        limitScope{group: name, key: key, keyed: rule.Key != policy.KeyNone},
+			shadow:       shadow,
+			group:        group,
+			method:       info.FullMethod,
+		})
+	}
+}
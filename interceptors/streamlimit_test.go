@@ -0,0 +1,168 @@
+package interceptors
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// msgStream is a ServerStream whose RecvMsg and SendMsg always succeed.
+type msgStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *msgStream) Context() context.Context { return s.ctx }
+func (s *msgStream) RecvMsg(any) error        { return nil }
+func (s *msgStream) SendMsg(any) error        { return nil }
+
+// runStream invokes ic with a handler that calls fn on the wrapped stream.
+func runStream(t *testing.T, ic grpc.StreamServerInterceptor, ctx context.Context, fn func(grpc.ServerStream) error) error {
+	t.Helper()
+	info := &grpc.StreamServerInfo{FullMethod: "/api.Service/Chat"}
+	return ic(nil, &msgStream{ctx: ctx}, info, func(_ any, ss grpc.ServerStream) error {
+		return fn(ss)
+	})
+}
+
+func TestStreamMessageLimit_FailsWhenExhausted(t *testing.T) {
+	ic := StreamMessageLimit(&policy.StreamRateLimitRule{Rate: 2, Window: time.Hour}, nil)
+
+	err := runStream(t, ic, t.Context(), func(ss grpc.ServerStream) error {
+		for i := range 2 {
+			if err := ss.RecvMsg(nil); err != nil {
+				t.Fatalf("message %d: %v", i, err)
+			}
+		}
+		// Sends are not limited by default.
+		for range 5 {
+			if err := ss.SendMsg(nil); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+		}
+		return ss.RecvMsg(nil)
+	})
+	if codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	// A new stream gets a fresh bucket.
+	if err := runStream(t, ic, t.Context(), func(ss grpc.ServerStream) error { return ss.RecvMsg(nil) }); err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+}
+
+// eofStream is a ServerStream whose client has closed its send side.
+type eofStream struct{ msgStream }
+
+func (s *eofStream) RecvMsg(any) error { return io.EOF }
+
+func TestStreamMessageLimit_EOFIsFree(t *testing.T) {
+	rule := &policy.StreamRateLimitRule{Rate: 1, Window: time.Hour, Key: policy.KeyPeerIP}
+	ic := StreamMessageLimit(rule, nil)
+	info := &grpc.StreamServerInfo{FullMethod: "/api.Service/Chat"}
+	ctx := peerAt(t, "192.0.2.1")
+
+	// The end of a stream must not use up the caller's shared bucket.
+	for range 3 {
+		err := ic(nil, &eofStream{msgStream{ctx: ctx}}, info, func(_ any, ss grpc.ServerStream) error {
+			return ss.RecvMsg(nil)
+		})
+		if err != io.EOF {
+			t.Fatalf("RecvMsg = %v, want io.EOF", err)
+		}
+	}
+	if err := runStream(t, ic, ctx, func(ss grpc.ServerStream) error { return ss.RecvMsg(nil) }); err != nil {
+		t.Fatalf("first message after EOFs: %v", err)
+	}
+}
+
+func TestStreamMessageLimit_SendLimited(t *testing.T) {
+	ic := StreamMessageLimit(&policy.StreamRateLimitRule{Rate: 1, Window: time.Hour, Send: true}, nil)
+
+	err := runStream(t, ic, t.Context(), func(ss grpc.ServerStream) error {
+		if err := ss.SendMsg(nil); err != nil {
+			t.Fatalf("first send: %v", err)
+		}
+		return ss.SendMsg(nil)
+	})
+	if codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+}
+
+func TestStreamMessageLimit_KeySharedAcrossStreams(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("chat").
+			Prefix("/api.Service/").
+			Policy(policy.Policy{
+				StreamRateLimit: &policy.StreamRateLimitRule{
+					Rate: 1, Window: time.Hour, Key: policy.KeyMetadata("x-user"),
+				},
+			}),
+	)
+	ic := StreamMessageLimit(nil, r)
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-user", "u1"))
+	recv := func(ss grpc.ServerStream) error { return ss.RecvMsg(nil) }
+
+	if err := runStream(t, ic, ctx, recv); err != nil {
+		t.Fatalf("first stream: %v", err)
+	}
+	if err := runStream(t, ic, ctx, recv); codeOf(err) != codes.ResourceExhausted {
+		t.Fatalf("second stream of the same key: expected ResourceExhausted, got %v", err)
+	}
+	other := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-user", "u2"))
+	if err := runStream(t, ic, other, recv); err != nil {
+		t.Fatalf("other key: %v", err)
+	}
+}
+
+func TestStreamMessageLimit_BlockWaitsForToken(t *testing.T) {
+	// 20 messages/s with burst 1: the second message waits ~50ms.
+	ic := StreamMessageLimit(&policy.StreamRateLimitRule{Rate: 20, Window: time.Second, Burst: 1, Block: true}, nil)
+
+	start := time.Now()
+	err := runStream(t, ic, t.Context(), func(ss grpc.ServerStream) error {
+		if err := ss.RecvMsg(nil); err != nil {
+			return err
+		}
+		return ss.RecvMsg(nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("expected to block for a token, took %v", elapsed)
+	}
+
+	// Cancelling the stream ends the wait.
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	slow := StreamMessageLimit(&policy.StreamRateLimitRule{Rate: 1, Window: time.Hour, Block: true}, nil)
+	err = runStream(t, slow, ctx, func(ss grpc.ServerStream) error {
+		_ = ss.RecvMsg(nil)
+		return ss.RecvMsg(nil)
+	})
+	if codeOf(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestStreamMessageLimit_NoRuleIsPassThrough(t *testing.T) {
+	ic := StreamMessageLimit(nil, nil)
+	err := runStream(t, ic, t.Context(), func(ss grpc.ServerStream) error {
+		if _, ok := ss.(*msgStream); !ok {
+			t.Fatalf("expected the original stream, got %T", ss)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
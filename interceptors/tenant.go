@@ -0,0 +1,116 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/tenant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errTenantDenied is allocated once to avoid per-request allocations on the hot path.
+var errTenantDenied = status.Error(codes.PermissionDenied, "tenant access denied")
+
+// tenantState holds the server-wide default rule and an optional policy
+// resolver whose groups may override it.
+type tenantState struct {
+	def      *policy.TenantRule
+	resolver *policy.Resolver
+}
+
+// ruleFor returns the group's Tenant rule when the resolver matches
+// fullMethod to a group that defines one. Otherwise it returns the default.
+func (s *tenantState) ruleFor(fullMethod string) *policy.TenantRule {
+	if s.resolver != nil {
+		if _, pol, ok := s.resolver.Resolve(fullMethod); ok && pol != nil && pol.Tenant != nil {
+			return pol.Tenant
+		}
+	}
+	return s.def
+}
+
+// checkTenant compares the target tenant of msg (and the incoming metadata) with
+// the Actor stored in ctx. requireTarget controls whether a missing target
+// is an error.
+func checkTenant(ctx context.Context, rule *policy.TenantRule, msg any, requireTarget bool) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	target, ok, conflict := tenant.Target(rule, md, msg)
+	if conflict {
+		return errTenantDenied
+	}
+	if !ok {
+		if requireTarget {
+			return errTenantDenied
+		}
+		return nil
+	}
+	actor, found := contextx.ActorFromContext(ctx)
+	if !found || !tenant.Allowed(rule, actor, target) {
+		return errTenantDenied
+	}
+	return nil
+}
+
+// TenantUnary returns a unary server interceptor that rejects requests whose
+// target tenant differs from the authenticated Actor's tenant with
+// codes.PermissionDenied. def applies to every method unless the resolver
+// matches a group with its own Tenant rule; either may be nil.
+func TenantUnary(def *policy.TenantRule, r *policy.Resolver) grpc.UnaryServerInterceptor {
+	st := &tenantState{def: def, resolver: r}
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if rule := st.ruleFor(info.FullMethod); rule != nil {
+			if err := checkTenant(ctx, rule, req, rule.RequireTarget); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TenantStream returns a stream server interceptor that enforces tenant
+// isolation. The metadata target is checked when the stream opens; when the
+// rule names a request field, every received message is checked as well.
+func TenantStream(def *policy.TenantRule, r *policy.Resolver) grpc.StreamServerInterceptor {
+	st := &tenantState{def: def, resolver: r}
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		rule := st.ruleFor(info.FullMethod)
+		if rule == nil {
+			return handler(srv, ss)
+		}
+		// With a field rule the target may only arrive with the messages.
+		if err := checkTenant(ss.Context(), rule, nil, rule.RequireTarget && rule.Field == ""); err != nil {
+			return err
+		}
+		if rule.Field == "" {
+			return handler(srv, ss)
+		}
+		return handler(srv, &tenantStream{ServerStream: ss, rule: rule})
+	}
+}
+
+// tenantStream checks the target tenant of every received message.
+type tenantStream struct {
+	grpc.ServerStream
+	rule *policy.TenantRule
+}
+
+func (s *tenantStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return checkTenant(s.Context(), s.rule, m, s.rule.RequireTarget)
+}
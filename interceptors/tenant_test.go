@@ -0,0 +1,121 @@
+package interceptors
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func tenantCtx(tenant string, md metadata.MD) context.Context {
+	ctx := contextx.WithActor(context.Background(), contextx.Actor{Subject: "u", Tenant: tenant})
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func TestTenantUnary_MetadataMismatchDenied(t *testing.T) {
+	ic := TenantUnary(&policy.TenantRule{Metadata: "x-tenant-id"}, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}
+
+	_, err := ic(tenantCtx("acme", metadata.Pairs("x-tenant-id", "acme")), nil, info, okHandler)
+	if err != nil {
+		t.Fatalf("same tenant: unexpected error: %v", err)
+	}
+	_, err = ic(tenantCtx("acme", metadata.Pairs("x-tenant-id", "globex")), nil, info, okHandler)
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", codeOf(err))
+	}
+}
+
+func TestTenantUnary_RequestField(t *testing.T) {
+	ic := TenantUnary(&policy.TenantRule{Field: "name"}, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}
+	req := &descriptorpb.FileDescriptorProto{Name: proto.String("globex")}
+
+	_, err := ic(tenantCtx("acme", nil), req, info, okHandler)
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", codeOf(err))
+	}
+}
+
+func TestTenantUnary_GroupOverridesDefault(t *testing.T) {
+	resolver := policy.NewResolver(
+		policy.Group("admin").
+			Prefix("/admin.").
+			Policy(policy.Policy{Tenant: &policy.TenantRule{
+				Metadata:    "x-tenant-id",
+				AdminScopes: []string{"tenants:any"},
+			}}),
+	)
+	ic := TenantUnary(&policy.TenantRule{Metadata: "x-tenant-id"}, resolver)
+	md := metadata.Pairs("x-tenant-id", "globex")
+
+	admin := contextx.WithActor(context.Background(), contextx.Actor{Tenant: "acme", Scopes: []string{"tenants:any"}})
+	admin = metadata.NewIncomingContext(admin, md)
+
+	_, err := ic(admin, nil, &grpc.UnaryServerInfo{FullMethod: "/admin.Tenants/Get"}, okHandler)
+	if err != nil {
+		t.Fatalf("admin group: unexpected error: %v", err)
+	}
+	_, err = ic(admin, nil, &grpc.UnaryServerInfo{FullMethod: "/public.Tenants/Get"}, okHandler)
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("default rule has no admin scopes: expected PermissionDenied, got %v", codeOf(err))
+	}
+}
+
+func TestTenantUnary_RequireTarget(t *testing.T) {
+	ic := TenantUnary(&policy.TenantRule{Metadata: "x-tenant-id", RequireTarget: true}, nil)
+	_, err := ic(tenantCtx("acme", nil), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}, okHandler)
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", codeOf(err))
+	}
+}
+
+func TestTenantUnary_MissingActorDenied(t *testing.T) {
+	ic := TenantUnary(&policy.TenantRule{Metadata: "x-tenant-id"}, nil)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant-id", "acme"))
+	_, err := ic(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}, okHandler)
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", codeOf(err))
+	}
+}
+
+// recvStream is a fake server stream that yields queued messages.
+type recvStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	msgs []string
+}
+
+func (s *recvStream) Context() context.Context { return s.ctx }
+
+func (s *recvStream) RecvMsg(m any) error {
+	if len(s.msgs) == 0 {
+		return io.EOF
+	}
+	m.(*descriptorpb.FileDescriptorProto).Name = proto.String(s.msgs[0])
+	s.msgs = s.msgs[1:]
+	return nil
+}
+
+func TestTenantStream_ChecksEveryMessage(t *testing.T) {
+	ic := TenantStream(&policy.TenantRule{Field: "name"}, nil)
+	ss := &recvStream{ctx: tenantCtx("acme", nil), msgs: []string{"acme", "globex"}}
+
+	err := ic(nil, ss, &grpc.StreamServerInfo{FullMethod: "/svc/Upload"}, func(_ any, s grpc.ServerStream) error {
+		for {
+			if err := s.RecvMsg(&descriptorpb.FileDescriptorProto{}); err != nil {
+				return err
+			}
+		}
+	})
+	if codeOf(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied on second message, got %v", err)
+	}
+}
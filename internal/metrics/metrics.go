@@ -0,0 +1,195 @@
+// Package metrics holds the Prometheus collectors of the built-in
+// middleware. Collectors are created and registered with the default
+// registry on first use, so only features that are actually enabled show up
+// in Server.MetricsHandler.
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "rawr"
+
+// register registers c with the default registry. If an identical collector
+// is already registered (e.g. by another Server in the same process), the
+// existing one is returned instead.
+func register[C prometheus.Collector](c C) C {
+	if err := prometheus.DefaultRegisterer.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+// ConcurrencyInFlight counts requests holding a concurrency slot, by group.
+var ConcurrencyInFlight = sync.OnceValue(func() *prometheus.GaugeVec {
+	return register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "concurrency",
+		Name:      "in_flight",
+		Help:      "Requests currently holding a concurrency slot.",
+	}, []string{"group"}))
+})
+
+// ConcurrencyQueued counts requests waiting for a concurrency slot, by group.
+var ConcurrencyQueued = sync.OnceValue(func() *prometheus.GaugeVec {
+	return register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "concurrency",
+		Name:      "queued",
+		Help:      "Requests waiting for a concurrency slot.",
+	}, []string{"group"}))
+})
+
+// ConcurrencyLimit is the current concurrency limit, by group. It changes
+// over time for adaptive limits.
+var ConcurrencyLimit = sync.OnceValue(func() *prometheus.GaugeVec {
+	return register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "concurrency",
+		Name:      "limit",
+		Help:      "Current concurrency limit.",
+	}, []string{"group"}))
+})
+
+// ConcurrencyRejected counts requests rejected by the concurrency limiter,
+// by group and reason ("limit", "queue_full", "queue_timeout", "canceled").
+var ConcurrencyRejected = sync.OnceValue(func() *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "concurrency",
+		Name:      "rejected_total",
+		Help:      "Requests rejected by the concurrency limiter.",
+	}, []string{"group", "reason"}))
+})
+
+// WouldReject counts requests that a middleware in dry-run mode would have
+// rejected, by middleware ("ratelimit", "ipblock", "auth") and group.
+var WouldReject = sync.OnceValue(func() *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "would_reject_total",
+		Help:      "Requests that a middleware in dry-run mode would have rejected.",
+	}, []string{"middleware", "group"}))
+})
+
+// ExemptBypassed counts requests that skipped a middleware because an
+// exemption rule matched, by middleware ("ratelimit", "concurrency",
+// "ipblock") and reason ("cidr", "subject", "client_id", "token").
+var ExemptBypassed = sync.OnceValue(func() *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "exempt",
+		Name:      "bypassed_total",
+		Help:      "Requests that skipped a middleware because an exemption rule matched.",
+	}, []string{"middleware", "reason"}))
+})
+
+// FailBanBans counts clients banned after repeated failures, by reason
+// ("ip", "actor").
+var FailBanBans = sync.OnceValue(func() *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "failban",
+		Name:      "bans_total",
+		Help:      "Clients banned after repeated failures.",
+	}, []string{"reason"}))
+})
+
+// LoadShedLoad is the load seen by the load shedder on the latest request;
+// 1 means fully loaded.
+var LoadShedLoad = sync.OnceValue(func() prometheus.Gauge {
+	return register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "loadshed",
+		Name:      "load",
+		Help:      "Server load seen by the load shedder.",
+	}))
+})
+
+// LoadShedRejected counts requests shed under overload, by criticality.
+var LoadShedRejected = sync.OnceValue(func() *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "loadshed",
+		Name:      "rejected_total",
+		Help:      "Requests shed under overload.",
+	}, []string{"criticality"}))
+})
+
+// FairQueueInFlight counts requests holding a fair-queue slot, by tenant.
+var FairQueueInFlight = sync.OnceValue(func() *prometheus.GaugeVec {
+	return register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "fairqueue",
+		Name:      "in_flight",
+		Help:      "Requests currently holding a fair-queue slot.",
+	}, []string{"tenant"}))
+})
+
+// FairQueueQueued counts requests waiting for a fair-queue slot, by tenant.
+var FairQueueQueued = sync.OnceValue(func() *prometheus.GaugeVec {
+	return register(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "fairqueue",
+		Name:      "queued",
+		Help:      "Requests waiting for a fair-queue slot.",
+	}, []string{"tenant"}))
+})
+
+// FairQueueWait observes how long admitted requests waited for a fair-queue
+// slot, by tenant.
+var FairQueueWait = sync.OnceValue(func() *prometheus.HistogramVec {
+	return register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "fairqueue",
+		Name:      "wait_seconds",
+		Help:      "Time admitted requests waited for a fair-queue slot.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"tenant"}))
+})
+
+// FairQueueRejected counts requests rejected by the fair queue, by tenant
+// and reason ("limit", "queue_full", "tenant_queue_full", "queue_timeout",
+// "canceled").
+var FairQueueRejected = sync.OnceValue(func() *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fairqueue",
+		Name:      "rejected_total",
+		Help:      "Requests rejected by the fair queue.",
+	}, []string{"tenant", "reason"}))
+})
+
+// warmUpFactor is the factor reported by the warm-up gauge.
+var warmUpFactor atomic.Pointer[func() float64]
+
+// registerWarmUp registers the warm-up gauge once per process.
+var registerWarmUp = sync.OnceFunc(func() {
+	register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "warmup",
+		Name:      "factor",
+		Help:      "Fraction of rate and concurrency limits available during warm-up.",
+	}, func() float64 {
+		return (*warmUpFactor.Load())()
+	}))
+})
+
+// WarmUpFactor makes the warm-up gauge report factor, the fraction of
+// capacity available during the warm-up period. The gauge is registered on
+// the first call; later calls replace the factor, so it follows the ramp of
+// the latest Server.
+func WarmUpFactor(factor func() float64) {
+	warmUpFactor.Store(&factor)
+	registerWarmUp()
+}
@@ -3,13 +3,17 @@ package gorawrsquirrel
 import (
 	"context"
 	"net"
+	"net/netip"
 	"testing"
 
+	"github.com/Keksclan/goRawrSquirrel/policy"
 	"github.com/Keksclan/goRawrSquirrel/security"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
@@ -67,6 +71,75 @@ func TestIPBlockIntegrationBlockedReturnsPermissionDenied(t *testing.T) {
 	}
 }
 
+func TestGroupIPIntegrationOnlyRestrictsItsGroup(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("watch").Exact("/grpc.health.v1.Health/Watch").Policy(policy.Policy{
+			IP: &policy.IPRule{Allow: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")}},
+		}),
+	)
+	srv := NewServer(WithResolver(r))
+	healthpb.RegisterHealthServer(srv.GRPC(), &stubHealthServer{})
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = srv.GRPC().Serve(lis)
+	}()
+	t.Cleanup(func() { srv.GRPC().Stop() })
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	// The stub does not implement Check; Unimplemented shows the call
+	// reached the handler.
+	if _, err := client.Check(t.Context(), &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected Check outside the group to reach the handler, got %v", err)
+	}
+	// The bufconn peer is outside the allowed range of the Watch group.
+	stream, err := client.Watch(t.Context(), &healthpb.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for Watch, got %v", err)
+	}
+}
+
+func TestGroupIPHonoursClientResolverWithoutBlocker(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("admin").Prefix("/admin.").Policy(policy.Policy{
+			IP: &policy.IPRule{Allow: []netip.Prefix{netip.MustParsePrefix("10.8.0.0/16")}},
+		}),
+	)
+	clients, err := security.NewClientResolver([]string{"172.16.0.0/12"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := buildUnary(WithResolver(r), WithClientResolver(clients))
+	info := &grpc.UnaryServerInfo{FullMethod: "/admin.Service/Delete"}
+	ok := func(context.Context, any) (any, error) { return "ok", nil }
+	viaProxy := func(client string) context.Context {
+		ctx := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("172.16.0.5"), Port: 1}})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", client))
+	}
+
+	if _, err := ic(viaProxy("10.8.3.4"), nil, info, ok); err != nil {
+		t.Fatalf("forwarded VPN client rejected: %v", err)
+	}
+	if _, err := ic(viaProxy("203.0.113.1"), nil, info, ok); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("forwarded outside client: got %v, want PermissionDenied", err)
+	}
+}
+
 // stubHealthServer is a minimal health server that always returns SERVING.
 type stubHealthServer struct {
 	healthpb.UnimplementedHealthServer
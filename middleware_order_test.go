@@ -3,10 +3,32 @@ package gorawrsquirrel
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/Keksclan/goRawrSquirrel/auth/hmacsig"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/interceptors"
+	"github.com/Keksclan/goRawrSquirrel/policy"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// buildUnary applies opts the way NewServer does and returns the resulting
+// unary chain.
+func buildUnary(opts ...Option) grpc.UnaryServerInterceptor {
+	var cfg config
+	for _, o := range opts {
+		o(&cfg)
+	}
+	for _, fn := range cfg.deferred {
+		fn(&cfg)
+	}
+	unary, _ := cfg.middlewares.Build()
+	return interceptors.ChainUnary(unary)
+}
+
 func TestMiddlewareOrderDeterminesExecution(t *testing.T) {
 	var log []string
 
@@ -103,3 +125,106 @@ func TestMiddlewareOrderStableForSameOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestMiddlewareOrder_RateLimitSeesLaterResolver(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("limited").Prefix("/limited.").Policy(policy.Policy{
+			RateLimit: &policy.RateLimitRule{Rate: 1, Window: time.Hour},
+		}),
+	)
+	info := &grpc.UnaryServerInfo{FullMethod: "/limited.Service/Call"}
+	ok := func(context.Context, any) (any, error) { return "ok", nil }
+
+	// The rate limiter is built after all options are applied, so the group
+	// rule applies no matter whether WithResolver comes first or last.
+	for name, opts := range map[string][]Option{
+		"resolver first": {WithResolver(r), WithRateLimitGlobal(1000, 1000)},
+		"resolver last":  {WithRateLimitGlobal(1000, 1000), WithResolver(r)},
+	} {
+		ic := buildUnary(opts...)
+		if _, err := ic(t.Context(), nil, info, ok); err != nil {
+			t.Fatalf("%s: first call: %v", name, err)
+		}
+		if _, err := ic(t.Context(), nil, info, ok); status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("%s: second call: got %v, want ResourceExhausted from the group rule", name, err)
+		}
+	}
+}
+
+func TestMiddlewareOrder_SigningRunsBeforeAuth(t *testing.T) {
+	v, err := hmacsig.NewVerifier(hmacsig.Config{
+		Keys:   map[string][]byte{"partner-a": []byte("secret")},
+		Nonces: hmacsig.NewMemoryNonces(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var authCalled bool
+	authFn := func(ctx context.Context, _ string, _ metadata.MD) (context.Context, error) {
+		authCalled = true
+		return ctx, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc.Service/Call"}
+	ok := func(context.Context, any) (any, error) { return "ok", nil }
+	badSig := metadata.NewIncomingContext(t.Context(), metadata.Pairs(hmacsig.HeaderKeyID, "partner-a"))
+
+	for name, opts := range map[string][]Option{
+		"signing first": {WithRequestSigning(v), WithAuth(authFn)},
+		"auth first":    {WithAuth(authFn), WithRequestSigning(v)},
+	} {
+		// A badly signed request is rejected before the AuthFunc runs.
+		authCalled = false
+		_, err := buildUnary(opts...)(badSig, nil, info, ok)
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%s: got %v, want Unauthenticated", name, err)
+		}
+		if authCalled {
+			t.Fatalf("%s: AuthFunc ran before request signing", name)
+		}
+
+		// An unsigned request is left to the AuthFunc.
+		if _, err := buildUnary(opts...)(t.Context(), nil, info, ok); err != nil {
+			t.Fatalf("%s: unsigned request: %v", name, err)
+		}
+		if !authCalled {
+			t.Fatalf("%s: AuthFunc did not see the unsigned request", name)
+		}
+	}
+
+	// Without WithAuth every request must be signed.
+	if _, err := buildUnary(WithRequestSigning(v))(t.Context(), nil, info, ok); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("signing only: got %v, want Unauthenticated", err)
+	}
+}
+
+func TestWithResolver_EnforcesGroupTenantAndImpersonationRules(t *testing.T) {
+	r := policy.NewResolver(
+		policy.Group("tenants").Exact("/svc.Service/Tenant").Policy(policy.Policy{
+			Tenant: &policy.TenantRule{Metadata: "x-tenant-id"},
+		}),
+		policy.Group("support").Exact("/svc.Service/Support").Policy(policy.Policy{
+			Impersonation: &policy.ImpersonationRule{Scopes: []string{"support"}},
+		}),
+	)
+	authFn := func(ctx context.Context, _ string, _ metadata.MD) (context.Context, error) {
+		return contextx.WithActor(ctx, contextx.Actor{Subject: "alice", Tenant: "acme", Scopes: []string{"support"}}), nil
+	}
+	ok := func(ctx context.Context, _ any) (any, error) {
+		a, _ := contextx.ActorFromContext(ctx)
+		return a.Subject, nil
+	}
+	// Neither WithTenantGuard nor WithImpersonation is configured.
+	ic := buildUnary(WithResolver(r), WithAuth(authFn))
+
+	ctx := metadata.NewIncomingContext(t.Context(), metadata.Pairs("x-tenant-id", "globex"))
+	_, err := ic(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc.Service/Tenant"}, ok)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("cross-tenant request: got %v, want PermissionDenied", err)
+	}
+
+	ctx = metadata.NewIncomingContext(t.Context(), metadata.Pairs("act-as", "bob"))
+	got, err := ic(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc.Service/Support"}, ok)
+	if err != nil || got != "bob" {
+		t.Fatalf("impersonation: got %v, %v; want bob", got, err)
+	}
+}
@@ -4,24 +4,41 @@ import (
 	"math/rand"
 
 	"github.com/Keksclan/goRawrSquirrel/auth"
+	"github.com/Keksclan/goRawrSquirrel/auth/hmacsig"
 	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/concurrency"
 	"github.com/Keksclan/goRawrSquirrel/interceptors"
+	"github.com/Keksclan/goRawrSquirrel/internal/metrics"
 	"github.com/Keksclan/goRawrSquirrel/policy"
+	"github.com/Keksclan/goRawrSquirrel/quota"
 	"github.com/Keksclan/goRawrSquirrel/ratelimit"
 	"github.com/Keksclan/goRawrSquirrel/security"
 	"github.com/Keksclan/goRawrSquirrel/tracing"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
 	"google.golang.org/grpc"
 )
 
 // Middleware order constants. Lower values execute first.
 const (
-	orderTracing     = 5
-	orderRecovery    = 10
-	orderIPBlock     = 20
-	orderRateLimit   = 25
-	orderAuth        = 28
-	orderRequestID   = 30
-	orderInterceptor = 100
+	orderTracing       = 5
+	orderRecovery      = 10
+	orderExempt        = 15
+	orderIPBlock       = 20
+	orderFailBan       = 21
+	orderLoadShed      = 22
+	orderGroupIP       = 23
+	orderRateLimit     = 25
+	orderSigning       = 27
+	orderAuth          = 28
+	orderImpersonation = 29
+	orderRequestID     = 30
+	orderTenant        = 31
+	orderRateLimitKey  = 32
+	orderFairQueue     = 33
+	orderStreamLimit   = 34
+	orderConcurrency   = 35
+	orderQuota         = 36
+	orderInterceptor   = 100
 )
 
 // Option configures a Server.
@@ -52,10 +69,61 @@ func WithRecovery() Option {
 }
 
 // WithResolver sets the policy resolver used for method-level policy lookup.
+//
+// Groups with a [policy.Policy.IP] rule only admit the client addresses it
+// allows. The rules are checked after the [WithIPBlocker] blocker, against
+// the client address resolved with its trusted proxies; without a blocker
+// the [WithClientResolver] resolver or else the peer address is used.
+//
+// Groups with a [policy.Policy.Tenant] or [policy.Policy.Impersonation] rule
+// are enforced even without [WithTenantGuard] or [WithImpersonation]; the
+// server-wide default is then no tenant check and no impersonation.
 func WithResolver(r *policy.Resolver) Option {
 	return func(c *config) {
 		c.resolver = r
+		c.deferred = append(c.deferred, func(c *config) {
+			if r == nil || c.resolver != r {
+				return
+			}
+			if hasRule(r, func(p *policy.Policy) bool { return p.IP != nil }) {
+				opts := []interceptors.GroupIPOption{interceptors.GroupIPDryRun(c.dryRun)}
+				if addrs := c.clientAddrs(); addrs != nil {
+					opts = append(opts, interceptors.GroupIPClientAddr(addrs))
+				}
+				c.middlewares.Add(orderGroupIP, interceptors.GroupIPUnary(r, opts...), interceptors.GroupIPStream(r, opts...))
+			}
+			if !c.impersonation && hasRule(r, func(p *policy.Policy) bool { return p.Impersonation != nil }) {
+				c.middlewares.Add(orderImpersonation, interceptors.ImpersonationUnary(nil, r), interceptors.ImpersonationStream(nil, r))
+			}
+			if !c.tenantGuard && hasRule(r, func(p *policy.Policy) bool { return p.Tenant != nil }) {
+				c.middlewares.Add(orderTenant, interceptors.TenantUnary(nil, r), interceptors.TenantStream(nil, r))
+			}
+		})
+	}
+}
+
+// hasRule reports whether the policy of any group of r satisfies set.
+func hasRule(r *policy.Resolver, set func(*policy.Policy) bool) bool {
+	for _, pol := range r.Policies() {
+		if pol != nil && set(pol) {
+			return true
+		}
 	}
+	return false
+}
+
+// WithClientResolver sets how middleware that identify clients by IP, such
+// as per-group IP rules, peer-IP rate-limit keys and failure bans, determine
+// the client address when no [WithIPBlocker] blocker is configured. Without
+// either, the peer address is used as-is; behind a proxy or load balancer
+// pass a resolver that trusts it.
+//
+// Example:
+//
+//	r, _ := security.NewClientResolver([]string{"10.0.0.0/24"}, nil)
+//	gs.NewServer(gs.WithClientResolver(r), gs.WithResolver(groups))
+func WithClientResolver(r *security.ClientResolver) Option {
+	return func(c *config) { c.clientResolver = r }
 }
 
 // WithIPBlocker registers an IP-blocking middleware that uses b to decide
@@ -69,7 +137,69 @@ func WithResolver(r *policy.Resolver) Option {
 func WithIPBlocker(b *security.IPBlocker) Option {
 	return func(c *config) {
 		c.ipBlocker = b
-		c.middlewares.Add(orderIPBlock, interceptors.IPBlockUnary(b), interceptors.IPBlockStream(b))
+		c.deferred = append(c.deferred, func(c *config) {
+			dry := interceptors.IPBlockDryRun(c.dryRun, c.resolver)
+			c.middlewares.Add(orderIPBlock, interceptors.IPBlockUnary(b, dry), interceptors.IPBlockStream(b, dry))
+		})
+	}
+}
+
+// WithExemptions exempts requests matched by e from rate limiting,
+// concurrency limits and IP blocking, e.g. monitoring probes and internal
+// batch jobs. e is evaluated once per request, right after recovery: client
+// CIDRs and signed bypass tokens (see [security.SignBypassToken]) apply to
+// every middleware, while subject and client ID rules need the
+// authenticated Actor and thus apply to the rate limits, the stream message
+// limits and the concurrency limit but not to IP blocking. With such rules,
+// a request over the global or a group rate limit is only rejected after
+// authentication, once it is known not to be exempt. Every bypass is logged
+// and counted in rawr_exempt_bypassed_total{middleware,reason}.
+//
+// Example:
+//
+//	ex, _ := security.NewExemptions(security.ExemptionConfig{
+//		CIDRs:      []string{"10.20.0.0/16"},
+//		Subjects:   []string{"svc-nightly-export"},
+//		BypassKeys: map[string][]byte{"ops": opsSecret},
+//	})
+//	gs.NewServer(gs.WithExemptions(ex))
+func WithExemptions(e *security.Exemptions) Option {
+	return func(c *config) {
+		c.middlewares.Add(orderExempt, interceptors.ExemptUnary(e), interceptors.ExemptStream(e))
+	}
+}
+
+// WithFailBan reports failed requests to d, which bans client IPs that fail
+// too often (by default: ten codes.Unauthenticated within a minute) in the
+// [security.IPBlocker] it was created with, for longer on every repeat
+// offense. Pass the same blocker to [WithIPBlocker] so that bans take
+// effect; its trusted proxies are used to resolve the client IP.
+//
+// The detector runs right after IP blocking, so requests rejected by a ban
+// are not counted again, and sees the status of everything behind it,
+// including authentication; with ByActor, an AuthFunc should report the
+// subject of a failed attempt with contextx.ReportSubject. Bans are logged
+// and exported as rawr_failban_bans_total{reason="ip|actor"}.
+//
+// Example:
+//
+//	blocker, _ := security.NewIPBlocker(security.Config{Mode: security.DenyList})
+//	d, _ := security.NewBanDetector(blocker, security.BanConfig{
+//		MaxFailures: 20,
+//		Window:      time.Minute,
+//		ByActor:     true,
+//		Allow:       []string{"10.0.0.0/8"},
+//	})
+//	gs.NewServer(gs.WithIPBlocker(blocker), gs.WithFailBan(d), gs.WithAuth(authFn))
+func WithFailBan(d *security.BanDetector) Option {
+	return func(c *config) {
+		c.deferred = append(c.deferred, func(c *config) {
+			var opts []interceptors.FailBanOption
+			if addrs := c.clientAddrs(); addrs != nil {
+				opts = append(opts, interceptors.FailBanClientAddr(addrs))
+			}
+			c.middlewares.Add(orderFailBan, interceptors.FailBanUnary(d, opts...), interceptors.FailBanStream(d, opts...))
+		})
 	}
 }
 
@@ -91,7 +221,41 @@ func WithIPBlocker(b *security.IPBlocker) Option {
 //	})
 func WithAuth(fn auth.AuthFunc) Option {
 	return func(c *config) {
-		c.middlewares.Add(orderAuth, interceptors.AuthUnary(fn), interceptors.AuthStream(fn))
+		c.auth = true
+		c.deferred = append(c.deferred, func(c *config) {
+			dry := interceptors.AuthDryRun(c.dryRun, c.resolver)
+			c.middlewares.Add(orderAuth, interceptors.AuthUnary(fn, dry), interceptors.AuthStream(fn, dry))
+		})
+	}
+}
+
+// WithRequestSigning registers a middleware that verifies HMAC request
+// signatures produced by [hmacsig.Signer]. It runs after IP blocking and rate
+// limiting and right before [WithAuth], so that an AuthFunc combined with it
+// sees the Actor of the signing key. Invalid, stale or replayed requests are
+// rejected with codes.Unauthenticated.
+//
+// When [WithAuth] is configured as well, requests without any signature
+// headers are passed on unchanged so that the AuthFunc can authenticate them,
+// e.g. by bearer token; an AuthFunc should then accept requests that already
+// carry an Actor. Without WithAuth every request must be signed.
+//
+// Example:
+//
+//	v, _ := hmacsig.NewVerifier(hmacsig.Config{
+//		Keys:   map[string][]byte{"partner-a": secret},
+//		Nonces: l2,
+//	})
+//	gs.NewServer(gs.WithRequestSigning(v))
+func WithRequestSigning(v *hmacsig.Verifier) Option {
+	return func(c *config) {
+		c.deferred = append(c.deferred, func(c *config) {
+			var opts []interceptors.SignatureOption
+			if c.auth {
+				opts = append(opts, interceptors.SignatureOptional())
+			}
+			c.middlewares.Add(orderSigning, interceptors.SignatureUnary(v, opts...), interceptors.SignatureStream(v, opts...))
+		})
 	}
 }
 
@@ -102,19 +266,430 @@ func WithAuth(fn auth.AuthFunc) Option {
 //
 // When a [policy.Resolver] has been configured via [WithResolver] and a method
 // matches a group with a RateLimit rule, the per-group limit is used instead
-// of the global one.
+// of the global one. A rule with a Key gets one bucket per key; peer-IP keys
+// honour the trusted proxies of the [WithIPBlocker] blocker or of
+// [WithClientResolver], and keys derived
+// from the authenticated Actor are enforced in a second stage that runs after
+// authentication and the tenant guard.
+//
+// The limiter is built once all options are applied, so WithResolver,
+// WithIPBlocker and the Redis options take effect regardless of whether they
+// are passed before or after WithRateLimitGlobal.
 //
 // Example:
 //
 //	// Allow 500 sustained req/s with bursts up to 100.
 //	gs.WithRateLimitGlobal(500, 100)
 func WithRateLimitGlobal(rps float64, burst int) Option {
+	return withRateLimit(func(c *config) ratelimit.Algorithm {
+		if c.rateLimitRedis != nil && c.l2 != nil {
+			return ratelimit.NewRedisLimiter(c.l2.Client(), "global", rps, burst, *c.rateLimitRedis)
+		}
+		return ratelimit.NewLimiter(rps, burst)
+	})
+}
+
+// WithRateLimitAlgorithm is like [WithRateLimitGlobal] but gates requests
+// with a, which may be any [ratelimit.Algorithm]: a fixed or sliding window
+// from the ratelimit package, or a custom one. Per-group limits still use
+// token buckets, and a is not distributed by [WithRateLimitDistributed].
+//
+// Example:
+//
+//	// At most 1000 requests in any one-minute interval.
+//	gs.WithRateLimitAlgorithm(ratelimit.NewSlidingWindowLog(1000, time.Minute))
+func WithRateLimitAlgorithm(a ratelimit.Algorithm) Option {
+	return withRateLimit(func(*config) ratelimit.Algorithm { return a })
+}
+
+// withRateLimit registers the rate-limit interceptors with the global
+// algorithm returned by global, which runs after all options are applied.
+func withRateLimit(global func(c *config) ratelimit.Algorithm) Option {
 	return func(c *config) {
-		l := ratelimit.NewLimiter(rps, burst)
-		c.middlewares.Add(orderRateLimit,
-			interceptors.RateLimitUnary(l, c.resolver),
-			interceptors.RateLimitStream(l, c.resolver),
-		)
+		c.deferred = append(c.deferred, func(c *config) {
+			l := global(c)
+			opts := []interceptors.RateLimitOption{interceptors.RateLimitDryRun(c.dryRun)}
+			if addrs := c.clientAddrs(); addrs != nil {
+				opts = append(opts, interceptors.RateLimitClientAddr(addrs))
+			}
+			if c.rateLimitRedis != nil && c.l2 != nil {
+				opts = append(opts, interceptors.RateLimitRedis(c.l2, *c.rateLimitRedis))
+			}
+			if c.rateLimitHeaders {
+				opts = append(opts, interceptors.RateLimitHeaders())
+			}
+			if c.rateLimitCost != nil {
+				opts = append(opts, interceptors.RateLimitCost(c.rateLimitCost))
+			}
+			if c.warmUp != nil {
+				opts = append(opts, interceptors.RateLimitWarmUp(c.warmUp))
+			}
+			pre := append(opts[:len(opts):len(opts)], interceptors.RateLimitPreAuth())
+			c.middlewares.Add(orderRateLimit,
+				interceptors.RateLimitUnary(l, c.resolver, pre...),
+				interceptors.RateLimitStream(l, c.resolver, pre...),
+			)
+			// The second stage also settles rejections the first held back
+			// for Actor exemptions, so it is installed even without groups.
+			post := append(opts[:len(opts):len(opts)], interceptors.RateLimitPostAuth())
+			c.middlewares.Add(orderRateLimitKey,
+				interceptors.RateLimitUnary(nil, c.resolver, post...),
+				interceptors.RateLimitStream(nil, c.resolver, post...),
+			)
+		})
+	}
+}
+
+// WithRateLimitDistributed makes the limiters of [WithRateLimitGlobal] share
+// their buckets across replicas through Redis, so that the configured limits
+// hold for the whole fleet rather than per instance. It reuses the connection
+// configured by [WithCacheRedis] and has no effect without it.
+//
+// Buckets use the generic cell rate algorithm in a Lua script. If Redis is
+// unreachable, each replica falls back to an in-process limiter; set
+// cfg.FallbackDivisor to the replica count to keep the aggregate limit close
+// to the configured one during an outage.
+//
+// Example:
+//
+//	gs.NewServer(
+//		gs.WithCacheRedis("localhost:6379", "", 0),
+//		gs.WithRateLimitGlobal(500, 100),
+//		gs.WithRateLimitDistributed(ratelimit.RedisConfig{FallbackDivisor: 20}),
+//	)
+func WithRateLimitDistributed(cfg ratelimit.RedisConfig) Option {
+	return func(c *config) {
+		c.rateLimitRedis = &cfg
+	}
+}
+
+// WithRateLimitCost sets how many tokens requests consume from the limiters
+// of [WithRateLimitGlobal] when their group has no [policy.Policy.Cost] of
+// its own. By default every request costs one token.
+//
+// Example:
+//
+//	gs.WithRateLimitCost(policy.CostRule{
+//		Methods: map[string]int{"/export.Export/Run": 100},
+//		Func: func(_ string, req any) int {
+//			if b, ok := req.(*pb.BatchRequest); ok {
+//				return len(b.Items)
+//			}
+//			return 0 // fall back to Methods / 1
+//		},
+//	})
+func WithRateLimitCost(def policy.CostRule) Option {
+	return func(c *config) {
+		c.rateLimitCost = &def
+	}
+}
+
+// WithRateLimitHeaders makes the limiters of [WithRateLimitGlobal] send
+// x-ratelimit-limit, x-ratelimit-remaining and x-ratelimit-reset response
+// headers. Rejections always carry errdetails.RetryInfo and QuotaFailure,
+// with or without this option.
+func WithRateLimitHeaders() Option {
+	return func(c *config) {
+		c.rateLimitHeaders = true
+	}
+}
+
+// WithStreamRateLimit limits the messages received (and, with def.Send,
+// sent) on streaming RPCs. Groups resolved via [WithResolver] may override
+// def by setting [policy.Policy.StreamRateLimit]; a zero def limits only
+// those groups. Keyed rules share one bucket per key across all streams of
+// that key, otherwise every stream gets its own.
+//
+// Example:
+//
+//	// 50 messages/s per stream, blocking instead of failing.
+//	gs.WithStreamRateLimit(policy.StreamRateLimitRule{
+//		Rate:   50,
+//		Window: time.Second,
+//		Block:  true,
+//	})
+func WithStreamRateLimit(def policy.StreamRateLimitRule) Option {
+	return func(c *config) {
+		c.deferred = append(c.deferred, func(c *config) {
+			var d *policy.StreamRateLimitRule
+			if def.Rate > 0 {
+				d = &def
+			}
+			opts := []interceptors.StreamLimitOption{interceptors.StreamLimitDryRun(c.dryRun)}
+			if addrs := c.clientAddrs(); addrs != nil {
+				opts = append(opts, interceptors.StreamLimitClientAddr(addrs))
+			}
+			c.middlewares.Add(orderStreamLimit, nil, interceptors.StreamMessageLimit(d, c.resolver, opts...))
+		})
+	}
+}
+
+// WithLoadShedding rejects requests with codes.Unavailable when the server
+// is overloaded, least critical first: Sheddable requests at 80% load,
+// SheddablePlus at 90% and Critical at 100%. Load is the larger of the
+// in-flight ratio (rule.MaxInFlight) and the standing-latency ratio of unary
+// calls (rule.TargetLatency); unary calls and streams are metered
+// separately, streams by in-flight count only.
+// Rejections carry RetryInfo and a grpc-retry-pushback-ms trailer.
+//
+// Criticality is taken from [policy.Policy.Criticality] of the resolved
+// group or else from the x-criticality request metadata; it defaults to
+// Critical. Mark health and admin groups CriticalPlus to never shed them;
+// grpc.health.v1.Health is CriticalPlus by default. The shedder runs right
+// after IP blocking, before any other work is spent on a request. Load and
+// rejections are exported as rawr_loadshed_load and
+// rawr_loadshed_rejected_total{criticality}.
+//
+// Example:
+//
+//	gs.WithLoadShedding(policy.LoadShedRule{
+//		MaxInFlight:   1000,
+//		TargetLatency: 50 * time.Millisecond,
+//	})
+func WithLoadShedding(rule policy.LoadShedRule) Option {
+	return func(c *config) {
+		c.deferred = append(c.deferred, func(c *config) {
+			c.middlewares.Add(orderLoadShed,
+				interceptors.LoadShedUnary(rule, c.resolver),
+				interceptors.LoadShedStream(rule, c.resolver),
+			)
+		})
+	}
+}
+
+// WithConcurrencyLimit bounds the number of requests handled at the same
+// time. Groups resolved via [WithResolver] may override def by setting
+// [policy.Policy.MaxConcurrent]; a zero def limits only those groups.
+// Requests beyond the limit wait in a bounded queue, if configured, and are
+// otherwise rejected: with codes.Unavailable when a group is full and with
+// codes.ResourceExhausted when a key (def.Key) uses all of its slots.
+//
+// Rules with Adaptive set adjust their limit from handler latency and
+// overload errors (see [WithConcurrencyAlgorithm]).
+//
+// In-flight and queued requests and the current limit are exported as the
+// gauges rawr_concurrency_in_flight, rawr_concurrency_queued and
+// rawr_concurrency_limit, labelled by group.
+//
+// Example:
+//
+//	gs.WithConcurrencyLimit(policy.ConcurrencyRule{
+//		Limit:        200,
+//		Queue:        50,
+//		QueueTimeout: 100 * time.Millisecond,
+//	})
+func WithConcurrencyLimit(def policy.ConcurrencyRule) Option {
+	return func(c *config) {
+		c.deferred = append(c.deferred, func(c *config) {
+			var d *policy.ConcurrencyRule
+			if def.Limit > 0 {
+				d = &def
+			}
+			var opts []interceptors.ConcurrencyOption
+			if addrs := c.clientAddrs(); addrs != nil {
+				opts = append(opts, interceptors.ConcurrencyClientAddr(addrs))
+			}
+			if c.concurrencyAlg != nil {
+				opts = append(opts, interceptors.ConcurrencyAlgorithm(c.concurrencyAlg))
+			}
+			if c.warmUp != nil {
+				opts = append(opts, interceptors.ConcurrencyWarmUp(c.warmUp))
+			}
+			c.middlewares.Add(orderConcurrency,
+				interceptors.ConcurrencyUnary(d, c.resolver, opts...),
+				interceptors.ConcurrencyStream(d, c.resolver, opts...),
+			)
+		})
+	}
+}
+
+// WithFairQueue shares rule.Limit in-flight slots among tenants by
+// weighted fair queuing, so that a single busy tenant cannot starve the
+// others. The tenant is that of the authenticated contextx.Actor; its
+// weight is rule.Weights of the plan returned by rule.PlanOf. While all
+// slots are taken, requests wait in per-tenant queues and free slots go to
+// the tenant furthest behind its weighted share. Unary calls and streams
+// are scheduled separately.
+//
+// The scheduler needs the Actor, so it runs after authentication and the
+// actor-keyed rate limits, before the bulkhead of [WithConcurrencyLimit].
+// A tenant rejected for having the longest queue gets
+// codes.ResourceExhausted; other rejections codes.Unavailable. In-flight and
+// queued requests, queue wait and rejections are exported as
+// rawr_fairqueue_in_flight, rawr_fairqueue_queued,
+// rawr_fairqueue_wait_seconds and rawr_fairqueue_rejected_total, labelled
+// by tenant.
+//
+// Example:
+//
+//	gs.WithFairQueue(policy.FairQueueRule{
+//		Limit:        200,
+//		Queue:        500,
+//		TenantQueue:  50,
+//		QueueTimeout: 250 * time.Millisecond,
+//		Weights:      map[string]float64{"free": 1, "pro": 4},
+//		PlanOf:       func(ctx context.Context, a contextx.Actor) string { return plans.Of(a.Tenant) },
+//	})
+func WithFairQueue(rule policy.FairQueueRule) Option {
+	return func(c *config) {
+		c.middlewares.Add(orderFairQueue, interceptors.FairQueueUnary(rule), interceptors.FairQueueStream(rule))
+	}
+}
+
+// WithConcurrencyAlgorithm sets the algorithm that adjusts adaptive
+// concurrency limits (rules with Adaptive set, see [WithConcurrencyLimit]).
+// newAlg is called once per group. The default is [concurrency.AIMD];
+// [concurrency.Gradient2] and [concurrency.Vegas] are provided as well, and
+// any [concurrency.Algorithm] can be plugged in.
+//
+// Example:
+//
+//	gs.WithConcurrencyAlgorithm(func() concurrency.Algorithm {
+//		return &concurrency.Gradient2{Tolerance: 2}
+//	})
+func WithConcurrencyAlgorithm(newAlg func() concurrency.Algorithm) Option {
+	return func(c *config) {
+		c.concurrencyAlg = newAlg
+	}
+}
+
+// WithWarmUp ramps the limits of [WithRateLimitGlobal] (global, group and
+// per-key) and [WithConcurrencyLimit] up from cfg.From of their configured
+// value to full capacity over cfg.Duration, so that a fresh replica with
+// cold caches is not flooded. The ramp starts in [NewServer] and can be
+// restarted through [Server.WarmUp], e.g. right before serving.
+//
+// Rate limits are scaled in rate and burst, concurrency limits in slots;
+// adaptive limits keep adapting while only the scaled share is admitted.
+// The current factor is exported as rawr_warmup_factor; with several
+// servers in one process, it reports the ramp of the latest one.
+//
+// Example:
+//
+//	gs.WithWarmUp(warmup.Config{
+//		Duration: 2 * time.Minute,
+//		From:     0.2,
+//		Curve:    warmup.Exponential,
+//	})
+func WithWarmUp(cfg warmup.Config) Option {
+	return func(c *config) {
+		c.warmUp = warmup.New(cfg)
+		metrics.WarmUpFactor(c.warmUp.Factor)
+	}
+}
+
+// WithDryRun runs the middleware selected by def in shadow mode: requests
+// they would reject are logged and counted in rawr_would_reject_total
+// (labelled by middleware and group), but proceed. Groups resolved via
+// [WithResolver] may override def by setting [policy.Policy.DryRun], which
+// also works without this option.
+//
+// It covers [WithRateLimitGlobal], [WithStreamRateLimit], [WithIPBlocker]
+// and [WithAuth]. Requests that fail authentication in shadow mode reach the
+// handler without an Actor.
+//
+// Example:
+//
+//	// Try out a new deny list before enforcing it.
+//	gs.NewServer(
+//		gs.WithIPBlocker(newBlocker),
+//		gs.WithDryRun(policy.DryRunRule{IPBlock: true}),
+//	)
+func WithDryRun(def policy.DryRunRule) Option {
+	return func(c *config) {
+		c.dryRun = &def
+	}
+}
+
+// WithQuota enforces long-window (hourly, daily, weekly, monthly) call
+// quotas per tenant. Counters live in the Redis instance configured with
+// [WithCacheRedis] and are aligned to calendar windows in cfg.Location;
+// NewServer panics if WithCacheRedis is missing. The plan of a tenant is
+// chosen by cfg.PlanOf. Calls over a hard limit are rejected with
+// codes.ResourceExhausted carrying QuotaFailure and RetryInfo details;
+// crossing a soft limit is logged and reported to cfg.OnSoft.
+//
+// The quota is charged last, after authentication and every other limit,
+// so that rejected calls do not use it up. Remaining quota can be queried
+// through [Server.Quotas].
+//
+// Example:
+//
+//	gs.WithQuota(quota.Config{
+//		Plans: map[string]quota.Plan{
+//			"free": {Limits: []quota.Limit{{Period: quota.Day, Soft: 800, Hard: 1000}}},
+//			"pro":  {Limits: []quota.Limit{{Period: quota.Month, Hard: 1_000_000}}},
+//		},
+//		PlanOf: func(ctx context.Context, a contextx.Actor) string { return plans.Of(a.Tenant) },
+//	})
+func WithQuota(cfg quota.Config) Option {
+	return func(c *config) {
+		c.deferred = append(c.deferred, func(c *config) {
+			if c.l2 == nil {
+				panic("gorawrsquirrel: WithQuota requires WithCacheRedis")
+			}
+			c.quotas = quota.New(c.l2, cfg)
+			c.middlewares.Add(orderQuota, interceptors.QuotaUnary(c.quotas), interceptors.QuotaStream(c.quotas))
+		})
+	}
+}
+
+// WithTenantGuard enables tenant isolation. For every request the target
+// tenant is read from def.Metadata or the request field at def.Field and
+// compared with the tenant of the authenticated contextx.Actor. Mismatches
+// are rejected with codes.PermissionDenied unless the Actor holds one of
+// def.AdminScopes. Groups resolved via [WithResolver] may override def by
+// setting [policy.Policy.Tenant].
+//
+// The guard runs after authentication and impersonation, so it compares
+// against the effective Actor.
+//
+// Example:
+//
+//	gs.WithTenantGuard(policy.TenantRule{
+//		Metadata:    "x-tenant-id",
+//		Field:       "parent.tenant_id",
+//		AdminScopes: []string{"tenants:any"},
+//	})
+func WithTenantGuard(def policy.TenantRule) Option {
+	return func(c *config) {
+		c.tenantGuard = true
+		c.deferred = append(c.deferred, func(c *config) {
+			c.middlewares.Add(orderTenant,
+				interceptors.TenantUnary(&def, c.resolver),
+				interceptors.TenantStream(&def, c.resolver),
+			)
+		})
+	}
+}
+
+// WithImpersonation lets an authenticated Actor act on behalf of another
+// subject by sending the "act-as" (and optionally "act-as-tenant") metadata
+// header. The caller must hold one of def.Scopes; groups resolved via
+// [WithResolver] may override def by setting [policy.Policy.Impersonation].
+// The impersonated subject stays in the caller's tenant unless the caller
+// holds one of def.CrossTenantScopes or the requested tenant is listed in
+// def.Tenants. Unauthorized attempts are rejected with
+// codes.PermissionDenied.
+//
+// Downstream handlers see the impersonated subject as the Actor and can read
+// the original caller with contextx.ImpersonatorFromContext. Every attempt is
+// logged via log/slog and recorded on the active trace span.
+//
+// Example:
+//
+//	gs.WithImpersonation(policy.ImpersonationRule{
+//		Scopes: []string{"support:impersonate"},
+//	})
+func WithImpersonation(def policy.ImpersonationRule) Option {
+	return func(c *config) {
+		c.impersonation = true
+		c.deferred = append(c.deferred, func(c *config) {
+			c.middlewares.Add(orderImpersonation,
+				interceptors.ImpersonationUnary(&def, c.resolver),
+				interceptors.ImpersonationStream(&def, c.resolver),
+			)
+		})
 	}
 }
 
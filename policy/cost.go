@@ -0,0 +1,33 @@
+package policy
+
+// CostRule sets how many rate-limit tokens a request consumes, so that
+// expensive methods or large requests are charged accordingly. Costs below
+// 1 fall through to the next source; the final fallback is 1.
+type CostRule struct {
+	// Default is the cost of every method of the group.
+	Default int
+	// Methods overrides Default for individual full method names, e.g.
+	// "/export.Export/Run".
+	Methods map[string]int
+	// Func, if set, computes the cost of a unary request from its message,
+	// e.g. from the number of items in a batch. It takes precedence over
+	// Methods and Default. Streams are charged the static cost when they
+	// open.
+	Func func(fullMethod string, req any) int
+}
+
+// Cost returns the cost of a request to fullMethod. req is nil for streams.
+func (c *CostRule) Cost(fullMethod string, req any) int {
+	if c == nil {
+		return 1
+	}
+	if c.Func != nil && req != nil {
+		if n := c.Func(fullMethod, req); n > 0 {
+			return n
+		}
+	}
+	if n := c.Methods[fullMethod]; n > 0 {
+		return n
+	}
+	return max(c.Default, 1)
+}
@@ -0,0 +1,37 @@
+package policy
+
+import "testing"
+
+func TestCostRule_Cost(t *testing.T) {
+	var none *CostRule
+	if got := none.Cost("/svc/Get", nil); got != 1 {
+		t.Fatalf("nil rule: got %d, want 1", got)
+	}
+
+	c := &CostRule{
+		Default: 2,
+		Methods: map[string]int{"/svc/Export": 100},
+		Func: func(_ string, req any) int {
+			if items, ok := req.([]string); ok {
+				return len(items)
+			}
+			return 0
+		},
+	}
+	tests := []struct {
+		method string
+		req    any
+		want   int
+	}{
+		{"/svc/Get", nil, 2},
+		{"/svc/Export", nil, 100},
+		{"/svc/Export", "not a batch", 100},
+		{"/svc/Batch", []string{"a", "b", "c"}, 3},
+		{"/svc/Batch", []string{}, 2},
+	}
+	for _, tt := range tests {
+		if got := c.Cost(tt.method, tt.req); got != tt.want {
+			t.Errorf("Cost(%s, %v) = %d, want %d", tt.method, tt.req, got, tt.want)
+		}
+	}
+}
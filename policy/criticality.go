@@ -0,0 +1,50 @@
+package policy
+
+import "strings"
+
+// Criticality ranks requests for load shedding: under overload the least
+// critical requests are rejected first. The zero value means unset.
+type Criticality int
+
+const (
+	// CriticalityUnset leaves the criticality to the request metadata or
+	// the default, Critical.
+	CriticalityUnset Criticality = iota
+	// Sheddable requests are rejected first, e.g. batch jobs and prefetches.
+	Sheddable
+	// SheddablePlus requests tolerate being rejected and retried later.
+	SheddablePlus
+	// Critical is the default for interactive traffic.
+	Critical
+	// CriticalPlus requests are never shed, e.g. health checks and admin
+	// methods. Only policy can grant it; clients cannot claim it.
+	CriticalPlus
+)
+
+// criticalityNames are the metadata values of the levels, following the
+// naming used by gRPC load balancers.
+var criticalityNames = [...]string{
+	Sheddable:     "SHEDDABLE",
+	SheddablePlus: "SHEDDABLE_PLUS",
+	Critical:      "CRITICAL",
+	CriticalPlus:  "CRITICAL_PLUS",
+}
+
+// String returns the metadata value of c, e.g. "SHEDDABLE_PLUS".
+func (c Criticality) String() string {
+	if c > CriticalityUnset && int(c) < len(criticalityNames) {
+		return criticalityNames[c]
+	}
+	return "UNSET"
+}
+
+// ParseCriticality parses a metadata value such as "sheddable_plus"
+// (case-insensitive). It reports false for unknown values.
+func ParseCriticality(s string) (Criticality, bool) {
+	for c, name := range criticalityNames {
+		if c != 0 && strings.EqualFold(s, name) {
+			return Criticality(c), true
+		}
+	}
+	return CriticalityUnset, false
+}
@@ -0,0 +1,20 @@
+package policy
+
+import "testing"
+
+func TestParseCriticality(t *testing.T) {
+	for _, c := range []Criticality{Sheddable, SheddablePlus, Critical, CriticalPlus} {
+		got, ok := ParseCriticality(c.String())
+		if !ok || got != c {
+			t.Errorf("round trip of %v: got %v, %v", c, got, ok)
+		}
+	}
+	if got, ok := ParseCriticality("sheddable_plus"); !ok || got != SheddablePlus {
+		t.Errorf("lower case: got %v, %v", got, ok)
+	}
+	for _, s := range []string{"", "UNSET", "urgent"} {
+		if _, ok := ParseCriticality(s); ok {
+			t.Errorf("%q must not parse", s)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package policy
 
 import (
+	"context"
+	"net/netip"
 	"regexp"
 	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
 )
 
 // RateLimitRule describes a rate-limiting policy for a group of methods.
@@ -11,12 +15,180 @@ type RateLimitRule struct {
 	Rate int
 	// Window is the time window for the rate limit.
 	Window time.Duration
+	// Key partitions the limit so that every distinct key gets its own
+	// bucket. The zero value shares one bucket across the group.
+	Key KeyBy
+	// MaxKeys bounds the number of per-key buckets kept in memory; the
+	// least recently used bucket is evicted first. Defaults to 10 000.
+	MaxKeys int
+	// IdleTimeout evicts per-key buckets that have not been used for the
+	// given duration, but never before they have refilled, i.e. within
+	// Window. Defaults to ten minutes.
+	IdleTimeout time.Duration
+}
+
+// StreamRateLimitRule limits the messages exchanged on a streaming RPC, as
+// opposed to RateLimitRule which only charges the stream when it opens.
+type StreamRateLimitRule struct {
+	// Rate is the maximum number of messages allowed within Window.
+	Rate int
+	// Window is the time window for the rate limit.
+	Window time.Duration
+	// Burst is the bucket capacity. Defaults to Rate.
+	Burst int
+	// Key shares one bucket among all streams with the same key. The zero
+	// value gives every stream its own bucket.
+	Key KeyBy
+	// Send also limits messages sent by the server, in a separate bucket
+	// with the same parameters. By default only received messages count.
+	Send bool
+	// Block waits for a token instead of failing the stream with
+	// codes.ResourceExhausted. Waiting on RecvMsg stops reading from the
+	// transport, so flow control pushes back on the client.
+	Block bool
+}
+
+// ConcurrencyRule bounds the number of requests of a group that are handled
+// at the same time (a bulkhead), so that slow handlers cannot pile up.
+type ConcurrencyRule struct {
+	// Limit is the maximum number of requests in flight.
+	Limit int
+	// Key applies Limit per key instead of to the whole group.
+	Key KeyBy
+	// Queue is the maximum number of requests waiting for a slot. Zero
+	// rejects immediately when all slots are taken.
+	Queue int
+	// QueueTimeout bounds the wait in the queue. Zero waits until the
+	// request deadline.
+	QueueTimeout time.Duration
+	// Adaptive adjusts the limit of the group from observed latency and
+	// overload errors, starting at Limit and staying within MinLimit and
+	// MaxLimit. It has no effect on keyed rules.
+	Adaptive bool
+	// MinLimit is the lower bound of an adaptive limit. Defaults to 1.
+	MinLimit int
+	// MaxLimit is the upper bound of an adaptive limit. Defaults to 1000.
+	MaxLimit int
+}
+
+// LoadShedRule describes when the server counts as overloaded. Load is the
+// larger of the in-flight ratio and the standing-latency ratio; requests are
+// shed by criticality as it approaches 1.
+type LoadShedRule struct {
+	// MaxInFlight is the number of requests in flight at which the server
+	// is fully loaded. Zero ignores the in-flight count.
+	MaxInFlight int
+	// TargetLatency is the standing latency of unary calls at which the
+	// server is fully loaded. Standing latency is the lowest latency, from
+	// admission to completion, seen during Interval: it only rises when
+	// every call is slow. Streams are not timed. Zero ignores latency.
+	TargetLatency time.Duration
+	// Interval is the period over which the lowest latency is taken.
+	// Defaults to 100ms.
+	Interval time.Duration
+	// RetryAfter is the pushback sent to shed clients. Defaults to one
+	// second.
+	RetryAfter time.Duration
+}
+
+// FairQueueRule shares a pool of in-flight slots among tenants by weighted
+// fair queuing, so that one busy tenant cannot starve the others. Tenants
+// are taken from the authenticated contextx.Actor; requests without one
+// share a single queue.
+type FairQueueRule struct {
+	// Limit is the number of requests in flight across all tenants.
+	Limit int
+	// Queue is the maximum number of requests waiting for a slot across
+	// all tenants. When it is full, the tenant with the longest queue loses
+	// its newest request to make room. Zero rejects immediately when all
+	// slots are taken.
+	Queue int
+	// TenantQueue is the maximum number of requests of one tenant waiting
+	// for a slot. Zero bounds them by Queue only.
+	TenantQueue int
+	// QueueTimeout bounds the wait in the queue. Zero waits until the
+	// request deadline.
+	QueueTimeout time.Duration
+	// Weights maps plan names to the relative share of their tenants: a
+	// tenant with weight 2 gets twice the slots of a tenant with weight 1
+	// while both are waiting. Plans without an entry weigh 1.
+	Weights map[string]float64
+	// PlanOf returns the plan name of an authenticated Actor. Defaults to
+	// "default" for everyone.
+	PlanOf func(ctx context.Context, a contextx.Actor) string
+}
+
+// TenantRule describes how the target tenant of a request is determined and
+// which scopes may cross tenant boundaries.
+type TenantRule struct {
+	// Metadata is the incoming metadata key carrying the target tenant.
+	Metadata string
+	// Field is a dot-separated path to a string field of the request
+	// message, e.g. "parent.tenant_id".
+	Field string
+	// AdminScopes lists Actor scopes that may access any tenant.
+	AdminScopes []string
+	// RequireTarget rejects requests whose target tenant cannot be
+	// determined. By default such requests are allowed.
+	RequireTarget bool
+}
+
+// ImpersonationRule describes who may act on behalf of another Actor.
+type ImpersonationRule struct {
+	// Scopes lists Actor scopes that permit impersonation; the caller needs
+	// at least one of them. An empty list forbids impersonation.
+	Scopes []string
+	// Header is the metadata key naming the subject to act as.
+	// Defaults to "act-as".
+	Header string
+	// TenantHeader is the metadata key naming the tenant of the subject.
+	// Defaults to "act-as-tenant"; when absent the caller's tenant is kept.
+	// Naming another tenant is refused unless CrossTenantScopes or Tenants
+	// permit it.
+	TenantHeader string
+	// CrossTenantScopes lists Actor scopes that permit impersonating a
+	// subject of any other tenant.
+	CrossTenantScopes []string
+	// Tenants lists the tenants every permitted impersonator may switch to,
+	// in addition to its own.
+	Tenants []string
+}
+
+// IPRule restricts which client addresses may call the methods of a group.
+// It is evaluated after the server-wide IP blocker, against the same
+// effective client address.
+type IPRule struct {
+	// Allow, if not empty, admits only clients within one of the ranges.
+	Allow []netip.Prefix
+	// Deny rejects clients within any of the ranges, even if Allow admits
+	// them.
+	Deny []netip.Prefix
+}
+
+// DryRunRule selects middleware that run in shadow mode: they evaluate every
+// request as usual, but a request they would reject is only logged and
+// counted, and then proceeds. It lets new rules be validated against
+// production traffic before they are enforced.
+type DryRunRule struct {
+	// RateLimit shadows call and per-message stream rate limits.
+	RateLimit bool
+	// IPBlock shadows the IP blocker and the IP rules of groups.
+	IPBlock bool
+	// Auth shadows authentication. Requests that fail it proceed without
+	// an Actor.
+	Auth bool
 }
 
 // Policy holds the configuration that applies to every gRPC method matched by
 // a [Group]. Fields are evaluated by the middleware stack: RateLimit overrides
-// the global rate limiter, Timeout caps handler execution time, and
-// AuthRequired enforces authentication for the matched methods.
+// the global rate limiter, Cost weights requests against it, StreamRateLimit
+// limits messages on streams, MaxConcurrent bounds in-flight requests,
+// Criticality ranks the group for load shedding, Timeout caps handler
+// execution time, AuthRequired enforces authentication for the matched
+// methods, IP restricts the client addresses admitted to the group, Tenant
+// and Impersonation override the corresponding server-wide rules (and apply
+// on their own when no server-wide rule is configured), and DryRun
+// selects middleware that only report what they would reject.
 //
 // Example:
 //
@@ -25,9 +197,17 @@ type RateLimitRule struct {
 //		AuthRequired: true,
 //	}
 type Policy struct {
-	RateLimit    *RateLimitRule
-	Timeout      time.Duration
-	AuthRequired bool
+	RateLimit       *RateLimitRule
+	Cost            *CostRule
+	StreamRateLimit *StreamRateLimitRule
+	MaxConcurrent   *ConcurrencyRule
+	Criticality     Criticality
+	Timeout         time.Duration
+	AuthRequired    bool
+	IP              *IPRule
+	Tenant          *TenantRule
+	Impersonation   *ImpersonationRule
+	DryRun          *DryRunRule
 }
 
 // matchKind distinguishes the three matching strategies.
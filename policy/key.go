@@ -0,0 +1,42 @@
+package policy
+
+import "strings"
+
+// KeyBy selects the request attribute used to partition a limit so that
+// every distinct value gets its own budget. The zero value shares a single
+// budget across the whole group.
+type KeyBy string
+
+const (
+	// KeyNone shares one budget across all callers of the group.
+	KeyNone KeyBy = ""
+	// KeyPeerIP partitions by client IP (trusted proxies are honoured when
+	// an IP blocker is configured).
+	KeyPeerIP KeyBy = "ip"
+	// KeySubject partitions by the authenticated Actor's Subject.
+	KeySubject KeyBy = "subject"
+	// KeyTenant partitions by the authenticated Actor's Tenant.
+	KeyTenant KeyBy = "tenant"
+	// KeyClientID partitions by the authenticated Actor's ClientID.
+	KeyClientID KeyBy = "client_id"
+)
+
+// metadataPrefix marks a KeyBy created by [KeyMetadata].
+const metadataPrefix = "metadata:"
+
+// KeyMetadata partitions by the first value of the incoming metadata key
+// name.
+func KeyMetadata(name string) KeyBy {
+	return KeyBy(metadataPrefix + strings.ToLower(name))
+}
+
+// Metadata returns the metadata key for a KeyBy created by [KeyMetadata].
+func (k KeyBy) Metadata() (name string, ok bool) {
+	return strings.CutPrefix(string(k), metadataPrefix)
+}
+
+// NeedsActor reports whether the key is derived from the authenticated
+// Actor and can therefore only be evaluated after authentication.
+func (k KeyBy) NeedsActor() bool {
+	return k == KeySubject || k == KeyTenant || k == KeyClientID
+}
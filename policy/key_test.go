@@ -0,0 +1,27 @@
+package policy
+
+import "testing"
+
+func TestKeyMetadata(t *testing.T) {
+	k := KeyMetadata("X-API-Key")
+	name, ok := k.Metadata()
+	if !ok || name != "x-api-key" {
+		t.Fatalf("got (%q, %v), want (x-api-key, true)", name, ok)
+	}
+	if _, ok := KeyPeerIP.Metadata(); ok {
+		t.Fatal("KeyPeerIP is not a metadata key")
+	}
+}
+
+func TestKeyBy_NeedsActor(t *testing.T) {
+	for _, k := range []KeyBy{KeySubject, KeyTenant, KeyClientID} {
+		if !k.NeedsActor() {
+			t.Fatalf("%q should need an actor", k)
+		}
+	}
+	for _, k := range []KeyBy{KeyNone, KeyPeerIP, KeyMetadata("x")} {
+		if k.NeedsActor() {
+			t.Fatalf("%q should not need an actor", k)
+		}
+	}
+}
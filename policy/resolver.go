@@ -1,5 +1,7 @@
 package policy
 
+import "iter"
+
 // Resolver holds a set of method groups and resolves a full gRPC method name
 // to the best-matching group and its associated policy.
 type Resolver struct {
@@ -45,3 +47,15 @@ func (res *Resolver) Resolve(fullMethod string) (groupName string, pol *Policy,
 	}
 	return groupName, pol, ok
 }
+
+// Policies returns the name and policy of every group, in registration
+// order. Groups without a policy yield nil.
+func (res *Resolver) Policies() iter.Seq2[string, *Policy] {
+	return func(yield func(string, *Policy) bool) {
+		for _, g := range res.groups {
+			if !yield(g.name, g.policy) {
+				return
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -194,3 +195,29 @@ func TestResolve_RateLimitPolicy(t *testing.T) {
 		t.Fatalf("got rate %d, want 100", pol.RateLimit.Rate)
 	}
 }
+
+func TestResolver_Policies(t *testing.T) {
+	r := NewResolver(
+		Group("admin").Prefix("/admin.").Policy(Policy{AuthRequired: true}),
+		Group("bare").Prefix("/bare."),
+		Group("public").Prefix("/public.").Policy(Policy{Timeout: time.Second}),
+	)
+
+	var names []string
+	for name, pol := range r.Policies() {
+		names = append(names, name)
+		if (name == "bare") != (pol == nil) {
+			t.Fatalf("group %q: policy = %v", name, pol)
+		}
+	}
+	if got := strings.Join(names, ","); got != "admin,bare,public" {
+		t.Fatalf("got groups %s, want admin,bare,public", got)
+	}
+
+	for name := range r.Policies() {
+		if name != "admin" {
+			t.Fatalf("iteration continued after break at %q", name)
+		}
+		break
+	}
+}
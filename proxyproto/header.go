@@ -0,0 +1,226 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by reads from a [Conn] whose header could not be read.
+var (
+	// ErrNoHeader reports a connection from a trusted source that did not
+	// start with a PROXY protocol header.
+	ErrNoHeader = errors.New("proxyproto: missing PROXY protocol header")
+	// ErrInvalidHeader reports a malformed header. The returned errors wrap
+	// it with the details.
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+)
+
+// Signatures of the two protocol versions.
+var (
+	sigV1 = []byte("PROXY ")
+	sigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	maxV1Len = 107 // longest valid v1 line, including CRLF
+	v2Fixed  = 16  // signature, version/command, family and length
+)
+
+// Command tells whether a header carries the addresses of a proxied client.
+type Command int
+
+const (
+	// Local marks connections the proxy opened itself, e.g. health checks,
+	// and v1 headers with protocol UNKNOWN. They carry no client address.
+	Local Command = iota
+	// Proxy marks connections relayed on behalf of a client.
+	Proxy
+)
+
+// Header is a parsed PROXY protocol header.
+type Header struct {
+	// Version is 1 for the text format and 2 for the binary format.
+	Version int
+	// Command is Local or Proxy.
+	Command Command
+	// Source and Destination are the client address and the address the
+	// client connected to. They are nil when the header carries no
+	// addresses.
+	Source, Destination net.Addr
+}
+
+// readHeader reads a header from br. It returns nil without consuming any
+// input if br does not start with a header signature.
+func readHeader(br *bufio.Reader) (*Header, error) {
+	for _, v := range []struct {
+		sig  []byte
+		read func(*bufio.Reader) (*Header, error)
+	}{{sigV1, readV1}, {sigV2, readV2}} {
+		ok, err := hasPrefix(br, v.sig)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return v.read(br)
+		}
+	}
+	return nil, nil
+}
+
+// hasPrefix reports whether the input starts with sig. It peeks one more
+// byte at a time, so that a mismatch is detected as soon as the first
+// differing byte arrives. Input that ends early does not match; other read
+// errors, such as a timeout, are returned.
+func hasPrefix(br *bufio.Reader, sig []byte) (bool, error) {
+	for i := range sig {
+		b, err := br.Peek(i + 1)
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b[i] != sig[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// readV1 reads a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(br *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		b, err := br.Peek(min(len(line)+1, maxV1Len))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+			break
+		}
+		if len(b) == maxV1Len {
+			return nil, fmt.Errorf("%w: v1 line longer than %d bytes", ErrInvalidHeader, maxV1Len)
+		}
+		line = b
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if _, err := br.Discard(len(line)); err != nil || !ok {
+		return nil, fmt.Errorf("%w: v1 line not terminated by CRLF", ErrInvalidHeader)
+	}
+
+	f := strings.Split(s, " ")
+	h := &Header{Version: 1}
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, s)
+	}
+	src, err := parseV1Addr(f[2], f[4], f[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(f[3], f[5], f[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	h.Command, h.Source, h.Destination = Proxy, src, dst
+	return h, nil
+}
+
+// parseV1Addr parses an address and port of a v1 header.
+func parseV1Addr(ip, port string, is4 bool) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != is4 || addr.Zone() != "" {
+		return nil, fmt.Errorf("%w: bad address %q", ErrInvalidHeader, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("%w: bad port %q", ErrInvalidHeader, port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readV2 reads a binary header.
+func readV2(br *bufio.Reader) (*Header, error) {
+	var fixed [v2Fixed]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	verCmd, fam := fixed[12], fixed[13]
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidHeader, verCmd>>4)
+	}
+
+	h := &Header{Version: 2}
+	switch verCmd & 0xf {
+	case 0:
+		return h, nil // LOCAL: ignore the address block
+	case 1:
+		h.Command = Proxy
+	default:
+		return nil, fmt.Errorf("%w: command %d", ErrInvalidHeader, verCmd&0xf)
+	}
+
+	// The address block may be followed by TLVs, which are skipped.
+	dgram := fam&0xf == 2
+	switch fam >> 4 {
+	case 0: // UNSPEC
+	case 1: // INET
+		if len(body) < 12 {
+			return nil, fmt.Errorf("%w: short IPv4 address block", ErrInvalidHeader)
+		}
+		h.Source = inetAddr(netip.AddrFrom4([4]byte(body[0:4])), body[8:10], dgram)
+		h.Destination = inetAddr(netip.AddrFrom4([4]byte(body[4:8])), body[10:12], dgram)
+	case 2: // INET6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("%w: short IPv6 address block", ErrInvalidHeader)
+		}
+		h.Source = inetAddr(netip.AddrFrom16([16]byte(body[0:16])), body[32:34], dgram)
+		h.Destination = inetAddr(netip.AddrFrom16([16]byte(body[16:32])), body[34:36], dgram)
+	case 3: // UNIX
+		if len(body) < 216 {
+			return nil, fmt.Errorf("%w: short unix address block", ErrInvalidHeader)
+		}
+		network := "unix"
+		if dgram {
+			network = "unixgram"
+		}
+		h.Source = &net.UnixAddr{Name: unixPath(body[0:108]), Net: network}
+		h.Destination = &net.UnixAddr{Name: unixPath(body[108:216]), Net: network}
+	default:
+		return nil, fmt.Errorf("%w: address family %d", ErrInvalidHeader, fam>>4)
+	}
+	return h, nil
+}
+
+// inetAddr builds the address of an INET or INET6 block.
+func inetAddr(ip netip.Addr, port []byte, dgram bool) net.Addr {
+	ap := netip.AddrPortFrom(ip, binary.BigEndian.Uint16(port))
+	if dgram {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+// unixPath returns the NUL-terminated path in b.
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,216 @@
+// Package proxyproto accepts connections relayed by TCP load balancers that
+// speak the PROXY protocol (versions 1 and 2), and reports the client
+// address from the PROXY header as the connection's remote address.
+//
+// Behind a layer-4 load balancer every peer address is the balancer, and
+// there are no forwarding headers to consult. Wrapping the listener makes
+// gRPC peer information, and with it IP blocking, rate-limit keys and logs,
+// see the real client:
+//
+//	lis, _ := net.Listen("tcp", ":50051")
+//	pl, err := proxyproto.NewListener(lis, proxyproto.Config{
+//		TrustedSources: []string{"10.0.0.0/24"}, // the load balancers
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	srv.GRPC().Serve(pl)
+//
+// Headers are only honoured on connections from trusted sources; anyone
+// else could claim an arbitrary address.
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/security"
+)
+
+// defaultReadHeaderTimeout is used when Config.ReadHeaderTimeout is zero.
+const defaultReadHeaderTimeout = 5 * time.Second
+
+// Config holds the parameters of a [Listener].
+type Config struct {
+	// TrustedSources lists the CIDRs or plain IPs of the proxies whose
+	// headers are honoured. Connections from other addresses are passed
+	// through unchanged. It must not be empty; list "0.0.0.0/0" and "::/0"
+	// only if the listener is unreachable except through the proxies.
+	TrustedSources []string
+
+	// Optional accepts connections from trusted sources that send no
+	// header. By default reads from such connections fail with
+	// ErrNoHeader.
+	Optional bool
+
+	// ReadHeaderTimeout bounds the time a trusted source may take to send
+	// the header. An earlier read deadline set on the connection, e.g. by
+	// grpc.ConnectionTimeout, takes precedence. Defaults to five seconds.
+	ReadHeaderTimeout time.Duration
+}
+
+// Listener wraps a [net.Listener] and returns connections that read the
+// PROXY header of trusted sources before any other data.
+type Listener struct {
+	net.Listener
+	cfg     Config
+	trusted *security.IPFilter
+}
+
+// NewListener wraps l. It returns an error if TrustedSources is empty or
+// contains an invalid entry.
+func NewListener(l net.Listener, cfg Config) (*Listener, error) {
+	if len(cfg.TrustedSources) == 0 {
+		return nil, errors.New("proxyproto: no trusted sources")
+	}
+	ps := make([]netip.Prefix, 0, len(cfg.TrustedSources))
+	for _, s := range cfg.TrustedSources {
+		p, err := security.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("proxyproto: invalid trusted source: %w", err)
+		}
+		ps = append(ps, p)
+	}
+	if cfg.ReadHeaderTimeout <= 0 {
+		cfg.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	return &Listener{Listener: l, cfg: cfg, trusted: security.NewIPFilter(ps, nil)}, nil
+}
+
+// Accept waits for the next connection. It does not read the header, so
+// that a slow client cannot hold up the accept loop; the header is read on
+// the first call to Read, RemoteAddr, LocalAddr or Header of the returned
+// *Conn. Deadlines set on the connection before then, such as the handshake
+// deadline of grpc.ConnectionTimeout, are kept.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: c, cfg: &l.cfg, trusted: l.isTrusted(c.RemoteAddr())}, nil
+}
+
+// isTrusted reports whether addr belongs to a trusted source.
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	return ok && l.trusted.Allowed(ip)
+}
+
+// Conn is a connection accepted by a [Listener]. Once its header has been
+// read, RemoteAddr and LocalAddr report the addresses it carries.
+type Conn struct {
+	net.Conn
+	cfg     *Config
+	trusted bool
+
+	once sync.Once
+	br   *bufio.Reader // nil for untrusted sources
+	hdr  *Header
+	err  error
+
+	// mu guards readDeadline, the read deadline last set by the caller,
+	// which init restores once the header has been read.
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// init reads the header of a trusted source once. The read is bounded by
+// Config.ReadHeaderTimeout or by a read deadline the caller set before,
+// whichever is earlier; the caller's deadline is restored afterwards.
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if !c.trusted {
+			return
+		}
+		c.br = bufio.NewReader(c.Conn)
+		c.mu.Lock()
+		dl := time.Now().Add(c.cfg.ReadHeaderTimeout)
+		if !c.readDeadline.IsZero() && c.readDeadline.Before(dl) {
+			dl = c.readDeadline
+		}
+		err := c.Conn.SetReadDeadline(dl)
+		c.mu.Unlock()
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.hdr, c.err = readHeader(c.br)
+		if c.hdr == nil && c.err == nil && !c.cfg.Optional {
+			c.err = ErrNoHeader
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.Conn.SetReadDeadline(c.readDeadline); err != nil && c.err == nil {
+			c.err = err
+		}
+	})
+}
+
+// SetDeadline sets the read and write deadlines of the connection. A read
+// deadline set before the header has been read also bounds the header, and
+// stays in effect afterwards.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the connection, like
+// SetDeadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Header returns the PROXY header of the connection. It returns nil and no
+// error for untrusted sources and for trusted sources that sent no header
+// when Config.Optional is set.
+func (c *Conn) Header() (*Header, error) {
+	c.init()
+	return c.hdr, c.err
+}
+
+// Read reads data following the header. It fails if the header could not
+// be read.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.br != nil {
+		return c.br.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, or the address of
+// the connected peer if there is none.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.hdr != nil && c.hdr.Source != nil {
+		return c.hdr.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the header, or the local
+// address of the connection if there is none.
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.hdr != nil && c.hdr.Destination != nil {
+		return c.hdr.Destination
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,296 @@
+package proxyproto
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+// v2Header builds a binary header with the given version/command and family
+// bytes around body.
+func v2Header(verCmd, fam byte, body []byte) []byte {
+	h := append([]byte{}, sigV2...)
+	h = append(h, verCmd, fam)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(body)))
+	return append(h, body...)
+}
+
+func TestReadHeader(t *testing.T) {
+	inet := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	inet6 := make([]byte, 36)
+	inet6[0], inet6[1], inet6[15] = 0x20, 0x01, 1 // 2001::1
+	inet6[16], inet6[17], inet6[31] = 0x20, 0x01, 2
+	binary.BigEndian.PutUint16(inet6[32:], 1234)
+	binary.BigEndian.PutUint16(inet6[34:], 443)
+	tlv := append(append([]byte{}, inet...), 0x04, 0x00, 0x01, 0xff) // trailing NOOP TLV
+
+	tests := []struct {
+		name     string
+		in       string
+		version  int
+		cmd      Command
+		src, dst string
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", 1, Proxy, "192.0.2.1:56324", "198.51.100.1:443"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n", 1, Proxy, "[2001:db8::1]:1234", "[2001:db8::2]:443"},
+		{"v1 unknown", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", 1, Local, "", ""},
+		{"v2 inet", string(v2Header(0x21, 0x11, inet)), 2, Proxy, "192.0.2.1:56324", "198.51.100.1:443"},
+		{"v2 inet with tlv", string(v2Header(0x21, 0x11, tlv)), 2, Proxy, "192.0.2.1:56324", "198.51.100.1:443"},
+		{"v2 inet6", string(v2Header(0x21, 0x21, inet6)), 2, Proxy, "[2001::1]:1234", "[2001::2]:443"},
+		{"v2 local", string(v2Header(0x20, 0x11, inet)), 2, Local, "", ""},
+		{"v2 unspec", string(v2Header(0x21, 0x00, nil)), 2, Proxy, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.in + "payload"))
+			h, err := readHeader(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Version != tt.version || h.Command != tt.cmd {
+				t.Fatalf("header = v%d cmd %d, want v%d cmd %d", h.Version, h.Command, tt.version, tt.cmd)
+			}
+			if got := addrString(h.Source); got != tt.src {
+				t.Fatalf("Source = %q, want %q", got, tt.src)
+			}
+			if got := addrString(h.Destination); got != tt.dst {
+				t.Fatalf("Destination = %q, want %q", got, tt.dst)
+			}
+			if rest, _ := io.ReadAll(br); string(rest) != "payload" {
+				t.Fatalf("data after header = %q, want payload", rest)
+			}
+		})
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+func TestReadHeader_Invalid(t *testing.T) {
+	tests := []struct{ name, in string }{
+		{"v1 missing fields", "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n"},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\n"},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n"},
+		{"v1 leading zero port", "PROXY TCP4 192.0.2.1 198.51.100.1 080 443\r\n"},
+		{"v1 no crlf", "PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n"},
+		{"v1 too long", "PROXY " + strings.Repeat("x", 120) + "\r\n"},
+		{"v1 truncated", "PROXY TCP4 192.0.2.1"},
+		{"v2 bad version", string(v2Header(0x11, 0x11, make([]byte, 12)))},
+		{"v2 bad command", string(v2Header(0x22, 0x11, make([]byte, 12)))},
+		{"v2 short inet", string(v2Header(0x21, 0x11, make([]byte, 8)))},
+		{"v2 truncated", string(v2Header(0x21, 0x11, make([]byte, 12))[:20])},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readHeader(bufio.NewReader(strings.NewReader(tt.in)))
+			if !errors.Is(err, ErrInvalidHeader) {
+				t.Fatalf("err = %v, want ErrInvalidHeader", err)
+			}
+		})
+	}
+}
+
+func TestReadHeader_NoHeader(t *testing.T) {
+	// The HTTP/2 preface shares its first byte with the v1 signature.
+	br := bufio.NewReader(strings.NewReader("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"))
+	h, err := readHeader(br)
+	if h != nil || err != nil {
+		t.Fatalf("readHeader = %+v, %v; want no header", h, err)
+	}
+	if rest, _ := io.ReadAll(br); !strings.HasPrefix(string(rest), "PRI *") {
+		t.Fatalf("input consumed: %q", rest)
+	}
+}
+
+// serve accepts one connection on a listener wrapped with cfg and returns
+// it together with the client side.
+func serve(t *testing.T, cfg Config) (server *Conn, client net.Conn) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	pl, err := NewListener(lis, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	c, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c.(*Conn), client
+}
+
+func TestListener_TrustedSource(t *testing.T) {
+	server, client := serve(t, Config{TrustedSources: []string{"127.0.0.1"}})
+	if _, err := io.WriteString(client, "PROXY TCP4 203.0.113.7 198.51.100.1 40000 443\r\nhello"); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.RemoteAddr().String(); got != "203.0.113.7:40000" {
+		t.Fatalf("RemoteAddr = %s, want 203.0.113.7:40000", got)
+	}
+	if got := server.LocalAddr().String(); got != "198.51.100.1:443" {
+		t.Fatalf("LocalAddr = %s, want 198.51.100.1:443", got)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Read = %q, %v; want hello", buf, err)
+	}
+}
+
+func TestListener_UntrustedSourceIsPassedThrough(t *testing.T) {
+	server, client := serve(t, Config{TrustedSources: []string{"192.0.2.0/24"}})
+	const in = "PROXY TCP4 203.0.113.7 198.51.100.1 40000 443\r\n"
+	if _, err := io.WriteString(client, in); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.RemoteAddr().String(); got != client.LocalAddr().String() {
+		t.Fatalf("RemoteAddr = %s, want the peer %s", got, client.LocalAddr())
+	}
+	buf := make([]byte, len(in))
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != in {
+		t.Fatalf("Read = %q, %v; want the header as data", buf, err)
+	}
+}
+
+func TestListener_MissingHeader(t *testing.T) {
+	server, client := serve(t, Config{TrustedSources: []string{"127.0.0.0/8"}})
+	if _, err := io.WriteString(client, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Read(make([]byte, 5)); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("Read err = %v, want ErrNoHeader", err)
+	}
+
+	server, client = serve(t, Config{TrustedSources: []string{"127.0.0.0/8"}, Optional: true})
+	if _, err := io.WriteString(client, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("Read = %q, %v; want hello", buf, err)
+	}
+	if h, err := server.Header(); h != nil || err != nil {
+		t.Fatalf("Header = %+v, %v; want none", h, err)
+	}
+}
+
+func TestListener_ReadHeaderTimeout(t *testing.T) {
+	server, _ := serve(t, Config{TrustedSources: []string{"127.0.0.1"}, ReadHeaderTimeout: 20 * time.Millisecond})
+	_, err := server.Header()
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("Header err = %v, want a timeout", err)
+	}
+}
+
+func TestListener_KeepsCallerDeadline(t *testing.T) {
+	server, client := serve(t, Config{TrustedSources: []string{"127.0.0.1"}})
+	// grpc sets its handshake deadline before the first read.
+	if err := server.SetDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(client, "PROXY TCP4 203.0.113.7 198.51.100.1 40000 443\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Header(); err != nil {
+		t.Fatal(err)
+	}
+	// Reading the header must not have cleared the deadline. The timer
+	// keeps the test from hanging if it did.
+	stop := time.AfterFunc(5*time.Second, func() { server.Conn.Close() })
+	defer stop.Stop()
+	_, err := server.Read(make([]byte, 1))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("Read err = %v, want the caller's deadline to expire", err)
+	}
+}
+
+func TestNewListener_Validation(t *testing.T) {
+	if _, err := NewListener(nil, Config{}); err == nil {
+		t.Fatal("expected an error without trusted sources")
+	}
+	if _, err := NewListener(nil, Config{TrustedSources: []string{"not-an-ip"}}); err == nil {
+		t.Fatal("expected an error for an invalid trusted source")
+	}
+}
+
+// peerHealth reports the peer address of every Check in its status.
+type peerHealth struct {
+	healthpb.UnimplementedHealthServer
+	addrs chan string
+}
+
+func (h *peerHealth) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	p, _ := peer.FromContext(ctx)
+	h.addrs <- p.Addr.String()
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func TestListener_GRPCPeerIsClient(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := NewListener(lis, Config{TrustedSources: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	h := &peerHealth{addrs: make(chan string, 1)}
+	healthpb.RegisterHealthServer(srv, h)
+	go func() { _ = srv.Serve(pl) }()
+	t.Cleanup(srv.Stop)
+
+	// The dialer plays the load balancer and prepends a v2 header.
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		c, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		body := []byte{203, 0, 113, 9, 127, 0, 0, 1, 0x9c, 0x40, 0x01, 0xbb}
+		if _, err := c.Write(v2Header(0x21, 0x11, body)); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	conn, err := grpc.NewClient("passthrough:///"+lis.Addr().String(),
+		grpc.WithContextDialer(dial),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-h.addrs; got != "203.0.113.9:40000" {
+		t.Fatalf("peer = %s, want 203.0.113.9:40000", got)
+	}
+}
@@ -0,0 +1,351 @@
+// Package quota enforces long-window call quotas per tenant, such as the
+// daily and monthly allowances of billing plans.
+//
+// Counters live in Redis, one per tenant and calendar window (hour, day,
+// ISO week or month, aligned in a configurable time zone), and expire
+// shortly after their window ends. A single Lua script checks every window
+// of a plan and increments them only if none would exceed its hard limit,
+// so replicas share the counters without races. Crossing a soft limit is
+// logged and reported through a callback, but the call proceeds.
+//
+// Like the L2 cache, enforcement fails open: while Redis is unreachable
+// calls are admitted and not counted.
+package quota
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Defaults applied by [New] when the corresponding Config field is zero.
+const (
+	defaultPrefix  = "quota:"
+	defaultPlan    = "default"
+	defaultTimeout = 100 * time.Millisecond
+
+	// expiryGrace keeps a counter readable for a while after its window
+	// ended.
+	expiryGrace = time.Hour
+)
+
+// errQuotaExceeded is returned when the rejection details cannot be
+// attached. It is allocated once to avoid per-request allocations.
+var errQuotaExceeded = status.Error(codes.ResourceExhausted, "quota exceeded")
+
+// Period is the length of a calendar-aligned quota window.
+type Period int
+
+const (
+	Hour  Period = iota // starts at the full hour
+	Day                 // starts at midnight
+	Week                // starts on Monday at midnight (ISO 8601)
+	Month               // starts on the first of the month at midnight
+)
+
+// String returns the lower-case period name, e.g. "day".
+func (p Period) String() string {
+	switch p {
+	case Hour:
+		return "hour"
+	case Day:
+		return "day"
+	case Week:
+		return "week"
+	case Month:
+		return "month"
+	}
+	return "period(" + strconv.Itoa(int(p)) + ")"
+}
+
+// adjective returns "hourly", "daily", "weekly" or "monthly".
+func (p Period) adjective() string {
+	if p == Day {
+		return "daily"
+	}
+	return p.String() + "ly"
+}
+
+// Window returns the start of the window of period p containing t and the
+// start of the next one, both in t's location.
+func (p Period) Window(t time.Time) (start, end time.Time) {
+	y, m, d := t.Date()
+	loc := t.Location()
+	switch p {
+	case Hour:
+		start = time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+		return start, start.Add(time.Hour)
+	case Week:
+		offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+		start = time.Date(y, m, d-offset, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 7)
+	case Month:
+		start = time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start = time.Date(y, m, d, 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// Limit is the allowance of one period. A zero Hard or Soft disables that
+// threshold.
+type Limit struct {
+	Period Period
+	// Soft is the number of calls after which a warning is raised.
+	Soft int64
+	// Hard is the number of calls after which further calls are rejected
+	// until the window ends.
+	Hard int64
+}
+
+// Plan is a named set of limits, e.g. {Day: 10k, Month: 200k}.
+type Plan struct {
+	Limits []Limit
+}
+
+// Usage reports the state of one window of a tenant's plan.
+type Usage struct {
+	Limit
+	// Used is the number of calls counted in the current window.
+	Used int64
+	// Remaining is Hard - Used, floored at zero, or -1 without a hard limit.
+	Remaining int64
+	// ResetAt is when the current window ends.
+	ResetAt time.Time
+}
+
+// Config holds the quota settings.
+type Config struct {
+	// Plans maps plan names to their limits.
+	Plans map[string]Plan
+
+	// PlanOf returns the plan name of an authenticated Actor. Defaults to
+	// "default" for everyone. Unknown plan names are not limited.
+	PlanOf func(ctx context.Context, a contextx.Actor) string
+
+	// OnSoft, if set, is called when a call crosses the soft limit of a
+	// window, once per window and tenant (unless counters are reset).
+	OnSoft func(ctx context.Context, tenant string, u Usage)
+
+	// Location aligns windows to a time zone. Defaults to UTC.
+	Location *time.Location
+
+	// Prefix is prepended to every Redis key. Defaults to "quota:".
+	Prefix string
+
+	// Timeout bounds each Redis round trip. Defaults to 100ms.
+	Timeout time.Duration
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Quotas enforces and reports tenant quotas. All methods are safe for
+// concurrent use.
+type Quotas struct {
+	rdb *redis.Client
+	cfg Config
+}
+
+// New creates Quotas whose counters are stored through the connection of
+// l2.
+func New(l2 *cache.L2, cfg Config) *Quotas {
+	if cfg.PlanOf == nil {
+		cfg.PlanOf = func(context.Context, contextx.Actor) string { return defaultPlan }
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.UTC
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultPrefix
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Quotas{rdb: l2.Client(), cfg: cfg}
+}
+
+// consumeScript increments the counters of every window by ARGV[1] unless
+// one of them would exceed its hard limit.
+//
+// KEYS[i]          counter of window i
+// ARGV[1]          cost
+// ARGV[1+i]        hard limit of window i (0 = none)
+// ARGV[1+#KEYS+i]  expiry of window i in unix milliseconds
+//
+// Returns {1, used_1, ..., used_n} on success or {0, i, j, ...} listing
+// every window that would overflow.
+var consumeScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+local n = #KEYS
+local over = {0}
+for i = 1, n do
+  local used = tonumber(redis.call("GET", KEYS[i]) or "0")
+  local hard = tonumber(ARGV[1 + i])
+  if hard > 0 and used + cost > hard then
+    over[#over + 1] = i
+  end
+end
+if #over > 1 then
+  return over
+end
+local out = {1}
+for i = 1, n do
+  out[i + 1] = redis.call("INCRBY", KEYS[i], cost)
+  redis.call("PEXPIREAT", KEYS[i], ARGV[1 + n + i])
+end
+return out
+`)
+
+// window is one limit of a plan resolved for a point in time.
+type window struct {
+	Limit
+	key        string
+	start, end time.Time
+}
+
+// windows resolves the plan of tenant at now. It returns nil when the plan
+// has no limits.
+func (q *Quotas) windows(tenant string, plan Plan, now time.Time) []window {
+	now = now.In(q.cfg.Location)
+	ws := make([]window, 0, len(plan.Limits))
+	for _, l := range plan.Limits {
+		start, end := l.Period.Window(now)
+		// The hash tag keeps a tenant's counters in one cluster slot.
+		key := q.cfg.Prefix + "{" + tenant + "}:" + l.Period.String() + ":" + strconv.FormatInt(start.Unix(), 10)
+		ws = append(ws, window{Limit: l, key: key, start: start, end: end})
+	}
+	return ws
+}
+
+// Check counts one call of the Actor in ctx against its tenant's quota. It
+// returns nil when the call may proceed and a ResourceExhausted status with
+// QuotaFailure and RetryInfo details when a hard limit is reached. Calls
+// without an Actor tenant or with a plan without limits are not counted.
+func (q *Quotas) Check(ctx context.Context) error {
+	a, ok := contextx.ActorFromContext(ctx)
+	if !ok || a.Tenant == "" {
+		return nil
+	}
+	plan, ok := q.cfg.Plans[q.cfg.PlanOf(ctx, a)]
+	if !ok || len(plan.Limits) == 0 {
+		return nil
+	}
+	ws := q.windows(a.Tenant, plan, q.cfg.Now())
+
+	keys := make([]string, len(ws))
+	args := make([]any, 1+2*len(ws))
+	args[0] = 1
+	for i, w := range ws {
+		keys[i] = w.key
+		args[1+i] = w.Hard
+		args[1+len(ws)+i] = w.end.Add(expiryGrace).UnixMilli()
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, q.cfg.Timeout)
+	defer cancel()
+	res, err := consumeScript.Run(rctx, q.rdb, keys, args...).Int64Slice()
+	if err != nil || len(res) < 1 {
+		// Fail open: an unreachable Redis must not take the API down.
+		slog.WarnContext(ctx, "quota check skipped", "tenant", a.Tenant, "error", err)
+		return nil
+	}
+
+	if res[0] == 0 {
+		over := make([]window, 0, len(res)-1)
+		for _, i := range res[1:] {
+			over = append(over, ws[i-1])
+		}
+		return exceeded(a.Tenant, over, q.cfg.Now())
+	}
+	for i, w := range ws {
+		// Calls are counted one at a time, so the crossing is exact.
+		used := res[1+i]
+		if w.Soft > 0 && used == w.Soft+1 {
+			u := usage(w, used)
+			slog.WarnContext(ctx, "quota soft limit exceeded",
+				"tenant", a.Tenant, "period", w.Period.String(), "used", used, "soft", w.Soft, "hard", w.Hard)
+			if q.cfg.OnSoft != nil {
+				q.cfg.OnSoft(ctx, a.Tenant, u)
+			}
+		}
+	}
+	return nil
+}
+
+// Usage returns the current usage of every window of tenant's plan. Unlike
+// [Quotas.Check] it reports Redis errors.
+func (q *Quotas) Usage(ctx context.Context, tenant, plan string) ([]Usage, error) {
+	p, ok := q.cfg.Plans[plan]
+	if !ok {
+		return nil, nil
+	}
+	ws := q.windows(tenant, p, q.cfg.Now())
+	if len(ws) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(ws))
+	for i, w := range ws {
+		keys[i] = w.key
+	}
+
+	vals, err := q.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Usage, len(ws))
+	for i, w := range ws {
+		var used int64
+		if s, ok := vals[i].(string); ok {
+			used, _ = strconv.ParseInt(s, 10, 64)
+		}
+		out[i] = usage(w, used)
+	}
+	return out, nil
+}
+
+// usage builds the Usage of w at used calls.
+func usage(w window, used int64) Usage {
+	remaining := int64(-1)
+	if w.Hard > 0 {
+		remaining = max(w.Hard-used, 0)
+	}
+	return Usage{Limit: w.Limit, Used: used, Remaining: remaining, ResetAt: w.end}
+}
+
+// exceeded builds the rejection of a call that would overflow every window
+// in over. The call is admitted again only once all of them have reset, so
+// RetryInfo points at the latest end.
+func exceeded(tenant string, over []window, now time.Time) error {
+	var reset time.Time
+	violations := make([]*errdetails.QuotaFailure_Violation, 0, len(over))
+	for _, w := range over {
+		if w.end.After(reset) {
+			reset = w.end
+		}
+		violations = append(violations, &errdetails.QuotaFailure_Violation{
+			Subject:     "tenant:" + tenant,
+			Description: w.Period.adjective() + " quota of " + strconv.FormatInt(w.Hard, 10) + " calls exhausted",
+		})
+	}
+	st, err := status.New(codes.ResourceExhausted, "quota exceeded").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(reset.Sub(now))},
+		&errdetails.QuotaFailure{Violations: violations},
+	)
+	if err != nil {
+		return errQuotaExceeded
+	}
+	return st.Err()
+}
@@ -0,0 +1,155 @@
+package quota
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPeriod_Window(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	// Thursday, 2026-10-15 13:45 in Berlin.
+	now := time.Date(2026, 10, 15, 13, 45, 0, 0, berlin)
+
+	tests := []struct {
+		p          Period
+		start, end time.Time
+	}{
+		{Hour, time.Date(2026, 10, 15, 13, 0, 0, 0, berlin), time.Date(2026, 10, 15, 14, 0, 0, 0, berlin)},
+		{Day, time.Date(2026, 10, 15, 0, 0, 0, 0, berlin), time.Date(2026, 10, 16, 0, 0, 0, 0, berlin)},
+		{Week, time.Date(2026, 10, 12, 0, 0, 0, 0, berlin), time.Date(2026, 10, 19, 0, 0, 0, 0, berlin)},
+		{Month, time.Date(2026, 10, 1, 0, 0, 0, 0, berlin), time.Date(2026, 11, 1, 0, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		start, end := tt.p.Window(now)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s: got [%v, %v), want [%v, %v)", tt.p, start, end, tt.start, tt.end)
+		}
+	}
+
+	// Sunday belongs to the week that started the Monday before.
+	sunday := time.Date(2026, 10, 18, 23, 0, 0, 0, berlin)
+	if start, _ := Week.Window(sunday); !start.Equal(time.Date(2026, 10, 12, 0, 0, 0, 0, berlin)) {
+		t.Errorf("week of Sunday starts %v", start)
+	}
+}
+
+func TestExceeded_RetryAfterLatestReset(t *testing.T) {
+	now := time.Date(2026, 10, 15, 13, 45, 0, 0, time.UTC)
+	q := New(cache.NewL2("localhost:1", "", 0), Config{})
+	ws := q.windows("acme", Plan{Limits: []Limit{
+		{Period: Day, Hard: 10},
+		{Period: Month, Hard: 100},
+	}}, now)
+
+	// With both windows exhausted, retrying after the daily reset would
+	// still hit the monthly limit.
+	st, _ := status.FromError(exceeded("acme", []window{ws[1], ws[0]}, now))
+	var ri *errdetails.RetryInfo
+	var qf *errdetails.QuotaFailure
+	for _, d := range st.Details() {
+		switch v := d.(type) {
+		case *errdetails.RetryInfo:
+			ri = v
+		case *errdetails.QuotaFailure:
+			qf = v
+		}
+	}
+	if want := ws[1].end.Sub(now); ri == nil || ri.GetRetryDelay().AsDuration() != want {
+		t.Fatalf("RetryInfo = %v, want %v", ri, want)
+	}
+	if len(qf.GetViolations()) != 2 {
+		t.Fatalf("expected a violation per exhausted window, got %v", qf)
+	}
+}
+
+func TestQuotas_FailOpenWithoutRedis(t *testing.T) {
+	l2 := cache.NewL2("localhost:1", "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+	q := New(l2, Config{
+		Plans:   map[string]Plan{"default": {Limits: []Limit{{Period: Day, Hard: 1}}}},
+		Timeout: 20 * time.Millisecond,
+	})
+
+	ctx := contextx.WithActor(t.Context(), contextx.Actor{Subject: "u", Tenant: "acme"})
+	for i := range 3 {
+		if err := q.Check(ctx); err != nil {
+			t.Fatalf("call %d: expected fail-open, got %v", i, err)
+		}
+	}
+	if _, err := q.Usage(ctx, "acme", "default"); err == nil {
+		t.Fatal("Usage must report the Redis error")
+	}
+}
+
+func TestQuotas_NoTenantIsNotCounted(t *testing.T) {
+	q := New(cache.NewL2("localhost:1", "", 0), Config{})
+	if err := q.Check(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestQuotas_EnforcesHardLimit(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set, skipping Redis integration test")
+	}
+	l2 := cache.NewL2(addr, "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+
+	var soft []Usage
+	q := New(l2, Config{
+		Plans: map[string]Plan{"free": {Limits: []Limit{
+			{Period: Day, Soft: 1, Hard: 2},
+			{Period: Month, Hard: 100},
+		}}},
+		PlanOf: func(context.Context, contextx.Actor) string { return "free" },
+		OnSoft: func(_ context.Context, _ string, u Usage) { soft = append(soft, u) },
+		Prefix: "test:quota:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":",
+	})
+	ctx := contextx.WithActor(t.Context(), contextx.Actor{Subject: "u", Tenant: "acme"})
+
+	for i := range 2 {
+		if err := q.Check(ctx); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if len(soft) != 1 || soft[0].Period != Day || soft[0].Used != 2 {
+		t.Fatalf("expected one soft-limit report for day, got %+v", soft)
+	}
+
+	err := q.Check(ctx)
+	st, _ := status.FromError(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	var qf *errdetails.QuotaFailure
+	for _, d := range st.Details() {
+		if v, ok := d.(*errdetails.QuotaFailure); ok {
+			qf = v
+		}
+	}
+	if qf == nil || qf.GetViolations()[0].GetSubject() != "tenant:acme" {
+		t.Fatalf("unexpected QuotaFailure %v", qf)
+	}
+
+	us, err := q.Usage(ctx, "acme", "free")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rejected call is not counted in any window.
+	if us[0].Used != 2 || us[0].Remaining != 0 || us[1].Used != 2 || us[1].Remaining != 98 {
+		t.Fatalf("unexpected usage %+v", us)
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Algorithm decides whether requests may proceed. [Limiter] (token bucket),
+// [FixedWindow], [SlidingWindowLog] and [SlidingWindowCounter] implement it;
+// custom algorithms can be passed to the rate-limit interceptors as well.
+// Implementations must be safe for concurrent use.
+type Algorithm interface {
+	// Allow reports whether a single request may proceed.
+	Allow() bool
+	// AllowN reports whether a request costing n may proceed.
+	AllowN(n int) bool
+	// Reserve admits a single request if possible and reports the
+	// resulting state, so that callers can tell clients when to retry.
+	Reserve(ctx context.Context) Reservation
+	// ReserveN is like Reserve for a request costing n. A rejected request
+	// consumes nothing; one that can never be admitted has a RetryAfter of
+	// [InfDuration].
+	ReserveN(ctx context.Context, n int) Reservation
+}
+
+// Scaler is implemented by algorithms whose capacity can be reduced at run
+// time, e.g. during a warm-up period. All algorithms of this package
+// implement it.
+type Scaler interface {
+	// SetScale sets the fraction of the configured rate and capacity that
+	// is available, in (0, 1]. Values outside that range are clamped.
+	SetScale(f float64)
+}
+
+// Compile-time checks.
+var (
+	_ Algorithm = (*Limiter)(nil)
+	_ Algorithm = (*FixedWindow)(nil)
+	_ Algorithm = (*SlidingWindowLog)(nil)
+	_ Algorithm = (*SlidingWindowCounter)(nil)
+
+	_ Scaler = (*Limiter)(nil)
+	_ Scaler = (*FixedWindow)(nil)
+	_ Scaler = (*SlidingWindowLog)(nil)
+	_ Scaler = (*SlidingWindowCounter)(nil)
+)
+
+// Option configures a limiter created by this package.
+type Option func(*options)
+
+type options struct {
+	now func() time.Time
+}
+
+// WithClock makes the limiter read the time from now instead of time.Now,
+// e.g. a fake clock in tests.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// clampScale clamps a scale factor to (0, 1].
+func clampScale(f float64) float64 {
+	if f <= 0 || f > 1 || math.IsNaN(f) {
+		return 1
+	}
+	return f
+}
+
+// newOptions applies opts on top of the defaults.
+func newOptions(opts []Option) options {
+	o := options{now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -1,22 +1,181 @@
-// Package ratelimit provides a token-bucket rate limiter backed by
-// golang.org/x/time/rate for use as a global gRPC request gate.
+// Package ratelimit provides rate-limiting algorithms for use as gRPC request
+// gates: a token bucket backed by golang.org/x/time/rate, which can
+// optionally share its bucket across replicas through Redis, and fixed and
+// sliding windows. All of them implement [Algorithm].
 package ratelimit
 
-import "golang.org/x/time/rate"
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+	"golang.org/x/time/rate"
+)
+
+var (
+	errCooldown    = errors.New("ratelimit: redis in cooldown")
+	errUnavailable = errors.New("ratelimit: redis unavailable")
+)
+
+// InfDuration is the RetryAfter of a request that can never be admitted,
+// e.g. because its cost exceeds the burst.
+const InfDuration = time.Duration(math.MaxInt64)
 
 // Limiter wraps a token-bucket limiter that decides whether an incoming
-// request should be allowed.
+// request should be allowed. A Limiter created by [NewRedisLimiter] keeps its
+// bucket in Redis and uses the in-process bucket only as a fallback.
 type Limiter struct {
-	lim *rate.Limiter
+	lim    *rate.Limiter
+	remote *remote
+	now    func() time.Time
+
+	// rps and burst are the configured parameters of lim; scale holds the
+	// bits of the factor set by SetScale, zero meaning 1. scaleMu keeps
+	// scale and the limit and burst of lim in step across SetScale calls.
+	rps     float64
+	burst   int
+	scaleMu sync.Mutex
+	scale   atomic.Uint64
+}
+
+// Reservation describes the outcome of a rate-limit decision together with
+// the bucket state, so that callers can tell clients when to retry.
+type Reservation struct {
+	// OK reports whether the request may proceed.
+	OK bool
+	// Limit is the bucket capacity (burst).
+	Limit int
+	// Remaining is the number of requests that would be admitted right now.
+	Remaining int
+	// RetryAfter is how long to wait before the request would be admitted.
+	// Zero when OK is true.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
 }
 
 // NewLimiter creates a Limiter that permits rps requests per second with the
 // given burst size.
-func NewLimiter(rps float64, burst int) *Limiter {
-	return &Limiter{lim: rate.NewLimiter(rate.Limit(rps), burst)}
+func NewLimiter(rps float64, burst int, opts ...Option) *Limiter {
+	return &Limiter{lim: rate.NewLimiter(rate.Limit(rps), burst), now: newOptions(opts).now, rps: rps, burst: burst}
+}
+
+// SetScale implements [Scaler]. It scales both the shared bucket of a
+// distributed limiter and its local fallback.
+func (l *Limiter) SetScale(f float64) {
+	f = clampScale(f)
+	bits := math.Float64bits(f)
+	l.scaleMu.Lock()
+	defer l.scaleMu.Unlock()
+	if old := l.scale.Swap(bits); old == bits || old == 0 && f == 1 {
+		return
+	}
+	now := l.now()
+	l.lim.SetLimitAt(now, rate.Limit(l.rps*f))
+	l.lim.SetBurstAt(now, warmup.Scale(l.burst, f))
+}
+
+// factor returns the factor set by SetScale.
+func (l *Limiter) factor() float64 {
+	if bits := l.scale.Load(); bits != 0 {
+		return math.Float64frombits(bits)
+	}
+	return 1
 }
 
 // Allow reports whether a single request may proceed.
 func (l *Limiter) Allow() bool {
-	return l.lim.Allow()
+	return l.AllowContext(context.Background())
+}
+
+// AllowN reports whether a request costing n tokens may proceed.
+func (l *Limiter) AllowN(n int) bool {
+	return l.ReserveN(context.Background(), n).OK
+}
+
+// AllowContext is like [Limiter.Allow] but bounds the Redis round trip of a
+// distributed limiter by ctx.
+func (l *Limiter) AllowContext(ctx context.Context) bool {
+	return l.Reserve(ctx).OK
+}
+
+// Reserve takes one token if available and reports the resulting bucket
+// state. A rejected request consumes nothing.
+func (l *Limiter) Reserve(ctx context.Context) Reservation {
+	return l.ReserveN(ctx, 1)
+}
+
+// ReserveN is like [Limiter.Reserve] for a request costing n tokens. A
+// request costing more than the burst is never admitted; its RetryAfter is
+// [InfDuration].
+func (l *Limiter) ReserveN(ctx context.Context, n int) Reservation {
+	if l.remote != nil {
+		if r, err := l.remote.take(ctx, n, l.factor()); err == nil {
+			if !r.OK && n > r.Limit {
+				r.RetryAfter = InfDuration
+			}
+			return r
+		}
+	}
+	return l.reserveLocal(l.now(), n)
+}
+
+// Wait blocks until a token is available or ctx is done, in which case it
+// returns the context error.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		r := l.Reserve(ctx)
+		if r.OK {
+			return nil
+		}
+		t := time.NewTimer(r.RetryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// reserveLocal is ReserveN on the in-process bucket.
+func (l *Limiter) reserveLocal(now time.Time, n int) Reservation {
+	ok := l.lim.AllowN(now, n)
+	tokens := l.lim.TokensAt(now)
+	burst := l.lim.Burst()
+	r := Reservation{
+		OK:         ok,
+		Limit:      burst,
+		Remaining:  max(0, int(tokens)),
+		ResetAfter: refill(float64(burst)-tokens, float64(l.lim.Limit())),
+	}
+	switch {
+	case ok:
+	case n > burst:
+		r.RetryAfter = InfDuration
+	default:
+		r.RetryAfter = refill(float64(n)-tokens, float64(l.lim.Limit()))
+	}
+	return r
+}
+
+// fullRefill returns how long the in-process bucket takes to fill up from
+// empty.
+func (l *Limiter) fullRefill() time.Duration {
+	return refill(float64(l.lim.Burst()), float64(l.lim.Limit()))
+}
+
+// refill returns how long it takes to refill tokens at rps tokens per second.
+func refill(tokens, rps float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	if rps <= 0 {
+		return InfDuration
+	}
+	return time.Duration(math.Ceil(tokens / rps * float64(time.Second)))
 }
@@ -1,7 +1,9 @@
 package ratelimit_test
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Keksclan/goRawrSquirrel/ratelimit"
 )
@@ -28,3 +30,73 @@ func TestLimiter_BlocksWhenBurstExhausted(t *testing.T) {
 		t.Fatal("expected Allow() == false after burst exhausted")
 	}
 }
+
+func TestLimiter_ReserveReportsBucketState(t *testing.T) {
+	// 1 token per second, burst 2.
+	l := ratelimit.NewLimiter(1, 2)
+
+	r := l.Reserve(t.Context())
+	if !r.OK || r.Limit != 2 || r.Remaining != 1 {
+		t.Fatalf("first reservation: %+v", r)
+	}
+	l.Reserve(t.Context())
+
+	r = l.Reserve(t.Context())
+	if r.OK {
+		t.Fatal("expected rejection after burst exhausted")
+	}
+	if r.Remaining != 0 {
+		t.Fatalf("expected 0 remaining, got %d", r.Remaining)
+	}
+	if r.RetryAfter <= 0 || r.RetryAfter > time.Second {
+		t.Fatalf("expected RetryAfter in (0, 1s], got %v", r.RetryAfter)
+	}
+	if r.ResetAfter <= time.Second || r.ResetAfter > 2*time.Second {
+		t.Fatalf("expected ResetAfter in (1s, 2s], got %v", r.ResetAfter)
+	}
+}
+
+func TestLimiter_AllowN(t *testing.T) {
+	l := ratelimit.NewLimiter(0.001, 10)
+
+	if !l.AllowN(7) {
+		t.Fatal("expected 7 of 10 tokens to be granted")
+	}
+	if l.AllowN(4) {
+		t.Fatal("expected 4 tokens to be refused with 3 left")
+	}
+	if !l.AllowN(3) {
+		t.Fatal("a refused request must not consume tokens")
+	}
+	if r := l.ReserveN(t.Context(), 11); r.OK || r.RetryAfter != ratelimit.InfDuration {
+		t.Fatalf("cost above burst: got %+v", r)
+	}
+}
+
+func TestLimiter_SetScaleConcurrent(t *testing.T) {
+	l := ratelimit.NewLimiter(100, 100)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 1000 {
+				l.SetScale(float64((i+j)%2+1) / 2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Whatever factor won, the bucket must match it, so setting either
+	// factor again takes effect.
+	for _, tc := range []struct {
+		f    float64
+		want int
+	}{{0.5, 50}, {1, 100}, {0.5, 50}} {
+		l.SetScale(tc.f)
+		if got := l.Reserve(t.Context()).Limit; got != tc.want {
+			t.Fatalf("SetScale(%v): burst = %d, want %d", tc.f, got, tc.want)
+		}
+	}
+}
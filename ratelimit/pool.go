@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Pool hands out one [Limiter] per key, all sharing the same rate and burst.
+// It keeps at most maxKeys limiters in least-recently-used order and drops
+// limiters that have been idle for longer than the idle timeout, so that a
+// stream of distinct keys (e.g. client IPs) cannot grow memory unboundedly.
+//
+// An evicted limiter comes back as a full bucket. Idle limiters are therefore
+// kept at least until their bucket would have refilled anyway, whatever the
+// idle timeout; after that a fresh bucket is indistinguishable from the old
+// one. Limiters evicted because the pool exceeds maxKeys lose their state
+// early, so maxKeys should exceed the number of keys active at a time.
+type Pool struct {
+	newLimiter func(key string) *Limiter
+	maxKeys    int
+	idle       time.Duration
+	now        func() time.Time
+
+	mu    sync.Mutex
+	ll    *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+// poolEntry is the value stored in each list element.
+type poolEntry struct {
+	key      string
+	lim      *Limiter
+	lastUsed time.Time
+}
+
+// NewPool creates a Pool whose limiters permit rps requests per second with
+// the given burst. maxKeys bounds the number of live limiters and idle is the
+// time after which an unused limiter is evicted, but never before its bucket
+// has refilled (burst/rps); zero or negative values disable the respective
+// bound.
+func NewPool(rps float64, burst, maxKeys int, idle time.Duration) *Pool {
+	return NewPoolFunc(func(string) *Limiter { return NewLimiter(rps, burst) }, maxKeys, idle)
+}
+
+// NewPoolFunc is like [NewPool] but creates limiters with newLimiter, which
+// receives the key the limiter is created for.
+func NewPoolFunc(newLimiter func(key string) *Limiter, maxKeys int, idle time.Duration) *Pool {
+	return &Pool{
+		newLimiter: newLimiter,
+		maxKeys:    maxKeys,
+		idle:       idle,
+		now:        time.Now,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns the limiter for key, creating it if necessary.
+func (p *Pool) Get(key string) *Limiter {
+	now := p.now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if el, ok := p.items[key]; ok {
+		e := el.Value.(*poolEntry)
+		e.lastUsed = now
+		p.ll.MoveToFront(el)
+		p.evict(now)
+		return e.lim
+	}
+
+	e := &poolEntry{key: key, lim: p.newLimiter(key), lastUsed: now}
+	p.items[key] = p.ll.PushFront(e)
+	p.evict(now)
+	return e.lim
+}
+
+// Len returns the number of live limiters.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ll.Len()
+}
+
+// evict drops least-recently-used entries beyond maxKeys and entries idle
+// for longer than both the idle timeout and the time their bucket takes to
+// refill. Must be called with p.mu held.
+func (p *Pool) evict(now time.Time) {
+	for el := p.ll.Back(); el != nil; el = p.ll.Back() {
+		e := el.Value.(*poolEntry)
+		overCap := p.maxKeys > 0 && p.ll.Len() > p.maxKeys
+		expired := p.idle > 0 && now.Sub(e.lastUsed) > max(p.idle, e.lim.fullRefill())
+		if !overCap && !expired {
+			return
+		}
+		p.ll.Remove(el)
+		delete(p.items, e.key)
+	}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPool_SeparateBucketsPerKey(t *testing.T) {
+	p := NewPool(0.001, 1, 0, 0)
+
+	if !p.Get("a").Allow() {
+		t.Fatal("first request for a should pass")
+	}
+	if p.Get("a").Allow() {
+		t.Fatal("second request for a should be limited")
+	}
+	if !p.Get("b").Allow() {
+		t.Fatal("b must have its own bucket")
+	}
+}
+
+func TestPool_EvictsLeastRecentlyUsed(t *testing.T) {
+	p := NewPool(1, 1, 2, 0)
+
+	a := p.Get("a")
+	p.Get("b")
+	p.Get("a") // a is now most recently used
+	p.Get("c") // evicts b
+
+	if p.Len() != 2 {
+		t.Fatalf("expected 2 live limiters, got %d", p.Len())
+	}
+	if p.Get("a") != a {
+		t.Fatal("a should have survived eviction")
+	}
+	if _, ok := p.items["b"]; ok {
+		t.Fatal("b should have been evicted")
+	}
+}
+
+func TestPool_EvictsIdle(t *testing.T) {
+	now := time.Now()
+	p := NewPool(1, 1, 0, time.Minute)
+	p.now = func() time.Time { return now }
+
+	p.Get("a")
+	now = now.Add(2 * time.Minute)
+	p.Get("b")
+
+	if p.Len() != 1 {
+		t.Fatalf("expected idle limiter to be evicted, got %d live", p.Len())
+	}
+	if _, ok := p.items["a"]; ok {
+		t.Fatal("a should have been evicted as idle")
+	}
+}
+
+func TestPool_KeepsIdleUntilRefilled(t *testing.T) {
+	now := time.Now()
+	// An empty bucket of 60 at one token per second refills in a minute.
+	p := NewPool(1, 60, 0, time.Second)
+	p.now = func() time.Time { return now }
+
+	p.Get("a")
+	now = now.Add(30 * time.Second)
+	p.Get("b")
+	if _, ok := p.items["a"]; !ok {
+		t.Fatal("a was evicted before its bucket could have refilled")
+	}
+	now = now.Add(31 * time.Second)
+	p.Get("b")
+	if _, ok := p.items["a"]; ok {
+		t.Fatal("a should have been evicted once refilled")
+	}
+}
@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+	"github.com/redis/go-redis/v9"
+)
+
+// gcraScript implements the generic cell rate algorithm. The bucket state is a
+// single theoretical arrival time (TAT) in seconds relative to 2024-01-01,
+// which keeps enough float precision for sub-millisecond intervals. Redis
+// TIME is used so that replicas with skewed clocks agree on "now".
+//
+// KEYS[1] bucket key
+// ARGV[1] burst, ARGV[2] rate per second, ARGV[3] cost
+//
+// Returns {allowed, remaining, retry_after, reset_after}; the durations are
+// returned as strings because Lua numbers are truncated to integers.
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local interval = 1 / rate
+local t = redis.call("TIME")
+local now = (tonumber(t[1]) - 1704067200) + tonumber(t[2]) / 1000000
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then tat = now end
+
+local new_tat = tat + interval * cost
+local diff = now - (new_tat - interval * burst)
+if diff < 0 then
+  return {0, 0, tostring(-diff), tostring(tat - now)}
+end
+
+local reset_after = new_tat - now
+if reset_after > 0 then
+  redis.call("SET", KEYS[1], tostring(new_tat), "EX", math.ceil(reset_after))
+end
+return {1, math.floor(diff / interval), "0", tostring(reset_after)}
+`)
+
+// RedisConfig configures a Redis-backed [Limiter].
+type RedisConfig struct {
+	// Prefix is prepended to every bucket key. Default "ratelimit:".
+	Prefix string
+	// Timeout bounds each Redis round trip. When it expires the request is
+	// decided by the local fallback limiter. Default 50ms.
+	Timeout time.Duration
+	// Cooldown is how long the limiter stays on the local fallback after a
+	// Redis error before trying Redis again. Default 1s.
+	Cooldown time.Duration
+	// FallbackDivisor divides the rate and burst of the local fallback
+	// limiter, e.g. the replica count so that the aggregate limit stays
+	// roughly the same during an outage. Values below 1 are treated as 1.
+	FallbackDivisor float64
+}
+
+// withDefaults returns cfg with zero fields replaced by their defaults.
+func (cfg RedisConfig) withDefaults() RedisConfig {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "ratelimit:"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 50 * time.Millisecond
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = time.Second
+	}
+	if cfg.FallbackDivisor < 1 {
+		cfg.FallbackDivisor = 1
+	}
+	return cfg
+}
+
+// remote is the shared, Redis-held part of a distributed Limiter.
+type remote struct {
+	rdb      redis.Scripter
+	key      string
+	rps      float64
+	burst    int
+	timeout  time.Duration
+	cooldown time.Duration
+
+	// downUntil is the unix-nano time until which Redis is skipped.
+	downUntil atomic.Int64
+}
+
+// take runs the GCRA script for n tokens, with rate and burst scaled by f.
+// It returns an error when Redis is unreachable or currently in cooldown.
+func (r *remote) take(ctx context.Context, n int, f float64) (Reservation, error) {
+	now := time.Now()
+	if now.UnixNano() < r.downUntil.Load() {
+		return Reservation{}, errCooldown
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	burst := warmup.Scale(r.burst, f)
+	res, err := gcraScript.Run(ctx, r.rdb, []string{r.key},
+		burst, strconv.FormatFloat(r.rps*f, 'g', -1, 64), n).Slice()
+	if err != nil || len(res) < 4 {
+		r.downUntil.Store(now.Add(r.cooldown).UnixNano())
+		return Reservation{}, errUnavailable
+	}
+	allowed, _ := res[0].(int64)
+	remaining, _ := res[1].(int64)
+	return Reservation{
+		OK:         allowed == 1,
+		Limit:      burst,
+		Remaining:  int(remaining),
+		RetryAfter: seconds(res[2]),
+		ResetAfter: seconds(res[3]),
+	}, nil
+}
+
+// seconds parses a script result holding fractional seconds.
+func seconds(v any) time.Duration {
+	s, _ := v.(string)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(f * float64(time.Second)))
+}
+
+// NewRedisLimiter creates a Limiter whose bucket lives in Redis under
+// cfg.Prefix+key, so that every replica using the same key shares one
+// budget of rps requests per second with the given burst. Pass the client of
+// the L2 cache (cache.L2.Client) to reuse its connection pool.
+//
+// Like the L2 cache the limiter fails soft: while Redis is unreachable the
+// request is decided by an in-process limiter with the same parameters
+// (divided by cfg.FallbackDivisor).
+func NewRedisLimiter(rdb redis.Scripter, key string, rps float64, burst int, cfg RedisConfig) *Limiter {
+	cfg = cfg.withDefaults()
+	l := NewLimiter(rps/cfg.FallbackDivisor, max(1, int(float64(burst)/cfg.FallbackDivisor)))
+	l.remote = &remote{
+		rdb:      rdb,
+		key:      cfg.Prefix + key,
+		rps:      rps,
+		burst:    burst,
+		timeout:  cfg.Timeout,
+		cooldown: cfg.Cooldown,
+	}
+	return l
+}
+
+// NewRedisPool creates a [Pool] of Redis-backed limiters. The Redis key of
+// each limiter is prefix+key. Only the local handles (and their fallback
+// buckets) are subject to the pool's LRU and idle bounds; the shared state in
+// Redis expires on its own once a bucket is full again.
+func NewRedisPool(rdb redis.Scripter, prefix string, rps float64, burst, maxKeys int, idle time.Duration, cfg RedisConfig) *Pool {
+	return NewPoolFunc(func(key string) *Limiter {
+		return NewRedisLimiter(rdb, prefix+key, rps, burst, cfg)
+	}, maxKeys, idle)
+}
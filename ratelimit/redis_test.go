@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/cache"
+)
+
+func redisL2(t *testing.T) *cache.L2 {
+	t.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set, skipping Redis integration test")
+	}
+	l2 := cache.NewL2(addr, "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+	if err := l2.Ping(t.Context()); err != nil {
+		t.Fatalf("cannot reach Redis at %s: %v", addr, err)
+	}
+	return l2
+}
+
+func TestRedisLimiter_FallsBackWhenUnreachable(t *testing.T) {
+	l2 := cache.NewL2("localhost:1", "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+
+	l := NewRedisLimiter(l2.Client(), "offline", 0.001, 4, RedisConfig{
+		Timeout:         20 * time.Millisecond,
+		Cooldown:        time.Minute,
+		FallbackDivisor: 2,
+	})
+
+	// The fallback bucket holds burst/divisor = 2 tokens.
+	for i := range 2 {
+		if !l.AllowContext(t.Context()) {
+			t.Fatalf("request %d should pass on the fallback limiter", i)
+		}
+	}
+	if l.AllowContext(t.Context()) {
+		t.Fatal("fallback limiter should be exhausted")
+	}
+	if l.remote.downUntil.Load() <= time.Now().UnixNano() {
+		t.Fatal("expected Redis to be in cooldown after an error")
+	}
+}
+
+func TestRedisLimiter_SharedAcrossInstances(t *testing.T) {
+	l2 := redisL2(t)
+	key := "test:" + t.Name() + ":" + time.Now().Format(time.RFC3339Nano)
+
+	// Two limiters stand in for two replicas sharing one bucket.
+	a := NewRedisLimiter(l2.Client(), key, 0.001, 3, RedisConfig{})
+	b := NewRedisLimiter(l2.Client(), key, 0.001, 3, RedisConfig{})
+
+	allowed := 0
+	for i := range 6 {
+		l := a
+		if i%2 == 1 {
+			l = b
+		}
+		if l.AllowContext(t.Context()) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("expected 3 requests across both instances, got %d", allowed)
+	}
+}
+
+func TestRedisPool_KeysAreIndependent(t *testing.T) {
+	l2 := redisL2(t)
+	prefix := "test:" + t.Name() + ":" + time.Now().Format(time.RFC3339Nano) + ":"
+	p := NewRedisPool(l2.Client(), prefix, 0.001, 1, 0, 0, RedisConfig{})
+
+	if !p.Get("a").AllowContext(t.Context()) {
+		t.Fatal("first request for a should pass")
+	}
+	if p.Get("a").AllowContext(t.Context()) {
+		t.Fatal("second request for a should be limited")
+	}
+	if !p.Get("b").AllowContext(t.Context()) {
+		t.Fatal("b must have its own bucket")
+	}
+}
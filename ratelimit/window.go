@@ -0,0 +1,265 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+)
+
+// FixedWindow admits up to limit requests per window, with windows aligned
+// to multiples of the window length. It is the cheapest algorithm but allows
+// up to twice the limit across a window boundary.
+type FixedWindow struct {
+	capacity int // configured limit
+	window   time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	limit int       // capacity scaled by SetScale
+	start time.Time // start of the current window
+	count int
+}
+
+// NewFixedWindow creates a FixedWindow that admits limit requests per
+// window. It panics unless limit and window are positive.
+func NewFixedWindow(limit int, window time.Duration, opts ...Option) *FixedWindow {
+	mustBePositive("NewFixedWindow", limit, window)
+	return &FixedWindow{capacity: limit, limit: limit, window: window, now: newOptions(opts).now}
+}
+
+// SetScale implements [Scaler].
+func (w *FixedWindow) SetScale(f float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limit = warmup.Scale(w.capacity, clampScale(f))
+}
+
+// Allow implements [Algorithm].
+func (w *FixedWindow) Allow() bool { return w.AllowN(1) }
+
+// AllowN implements [Algorithm].
+func (w *FixedWindow) AllowN(n int) bool { return w.ReserveN(context.Background(), n).OK }
+
+// Reserve implements [Algorithm].
+func (w *FixedWindow) Reserve(ctx context.Context) Reservation { return w.ReserveN(ctx, 1) }
+
+// ReserveN implements [Algorithm].
+func (w *FixedWindow) ReserveN(_ context.Context, n int) Reservation {
+	now := w.now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if start := now.Truncate(w.window); !start.Equal(w.start) {
+		w.start, w.count = start, 0
+	}
+	untilEnd := w.start.Add(w.window).Sub(now)
+	r := Reservation{Limit: w.limit}
+	switch {
+	case w.count+n <= w.limit:
+		w.count += n
+		r.OK = true
+	case n > w.limit:
+		r.RetryAfter = InfDuration
+	default:
+		r.RetryAfter = untilEnd
+	}
+	r.Remaining = w.limit - w.count
+	if w.count > 0 {
+		r.ResetAfter = untilEnd
+	}
+	return r
+}
+
+// SlidingWindowLog admits up to limit requests in any window-long interval
+// by remembering when each admitted request happened. It is exact, but its
+// memory grows with the limit.
+type SlidingWindowLog struct {
+	capacity int // configured limit
+	window   time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	limit int        // capacity scaled by SetScale
+	log   []logEntry // admitted requests, oldest first
+	total int        // sum of costs in log
+}
+
+// logEntry is an admitted request of a SlidingWindowLog.
+type logEntry struct {
+	at   time.Time
+	cost int
+}
+
+// NewSlidingWindowLog creates a SlidingWindowLog that admits limit requests
+// per window. It panics unless limit and window are positive.
+func NewSlidingWindowLog(limit int, window time.Duration, opts ...Option) *SlidingWindowLog {
+	mustBePositive("NewSlidingWindowLog", limit, window)
+	return &SlidingWindowLog{capacity: limit, limit: limit, window: window, now: newOptions(opts).now}
+}
+
+// SetScale implements [Scaler].
+func (w *SlidingWindowLog) SetScale(f float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limit = warmup.Scale(w.capacity, clampScale(f))
+}
+
+// Allow implements [Algorithm].
+func (w *SlidingWindowLog) Allow() bool { return w.AllowN(1) }
+
+// AllowN implements [Algorithm].
+func (w *SlidingWindowLog) AllowN(n int) bool { return w.ReserveN(context.Background(), n).OK }
+
+// Reserve implements [Algorithm].
+func (w *SlidingWindowLog) Reserve(ctx context.Context) Reservation { return w.ReserveN(ctx, 1) }
+
+// ReserveN implements [Algorithm].
+func (w *SlidingWindowLog) ReserveN(_ context.Context, n int) Reservation {
+	now := w.now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Drop entries that left the window.
+	i := 0
+	for ; i < len(w.log) && !w.log[i].at.Add(w.window).After(now); i++ {
+		w.total -= w.log[i].cost
+	}
+	w.log = w.log[i:]
+
+	r := Reservation{Limit: w.limit}
+	switch {
+	case w.total+n <= w.limit:
+		w.log = append(w.log, logEntry{at: now, cost: n})
+		w.total += n
+		r.OK = true
+	case n > w.limit:
+		r.RetryAfter = InfDuration
+	default:
+		// Wait until enough of the oldest entries have expired.
+		freed := 0
+		for _, e := range w.log {
+			freed += e.cost
+			if w.total-freed+n <= w.limit {
+				r.RetryAfter = e.at.Add(w.window).Sub(now)
+				break
+			}
+		}
+	}
+	r.Remaining = w.limit - w.total
+	if len(w.log) > 0 {
+		r.ResetAfter = w.log[len(w.log)-1].at.Add(w.window).Sub(now)
+	}
+	return r
+}
+
+// SlidingWindowCounter approximates a sliding window from the counts of the
+// current and the previous fixed window, weighting the previous one by how
+// much of it still overlaps the sliding window. It smooths the boundary
+// bursts of [FixedWindow] in constant memory.
+type SlidingWindowCounter struct {
+	capacity int // configured limit
+	window   time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	limit      int       // capacity scaled by SetScale
+	start      time.Time // start of the current window
+	prev, curr int
+}
+
+// NewSlidingWindowCounter creates a SlidingWindowCounter that admits about
+// limit requests per window. It panics unless limit and window are positive.
+func NewSlidingWindowCounter(limit int, window time.Duration, opts ...Option) *SlidingWindowCounter {
+	mustBePositive("NewSlidingWindowCounter", limit, window)
+	return &SlidingWindowCounter{capacity: limit, limit: limit, window: window, now: newOptions(opts).now}
+}
+
+// SetScale implements [Scaler].
+func (w *SlidingWindowCounter) SetScale(f float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limit = warmup.Scale(w.capacity, clampScale(f))
+}
+
+// Allow implements [Algorithm].
+func (w *SlidingWindowCounter) Allow() bool { return w.AllowN(1) }
+
+// AllowN implements [Algorithm].
+func (w *SlidingWindowCounter) AllowN(n int) bool { return w.ReserveN(context.Background(), n).OK }
+
+// Reserve implements [Algorithm].
+func (w *SlidingWindowCounter) Reserve(ctx context.Context) Reservation { return w.ReserveN(ctx, 1) }
+
+// ReserveN implements [Algorithm].
+func (w *SlidingWindowCounter) ReserveN(_ context.Context, n int) Reservation {
+	now := w.now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch start := now.Truncate(w.window); {
+	case start.Equal(w.start):
+	case start.Sub(w.start) == w.window:
+		w.start, w.prev, w.curr = start, w.curr, 0
+	default:
+		w.start, w.prev, w.curr = start, 0, 0
+	}
+	elapsed := now.Sub(w.start)
+	weight := 1 - float64(elapsed)/float64(w.window)
+	estimate := float64(w.prev)*weight + float64(w.curr)
+
+	r := Reservation{Limit: w.limit}
+	switch {
+	case estimate+float64(n) <= float64(w.limit):
+		w.curr += n
+		estimate += float64(n)
+		r.OK = true
+	case n > w.limit:
+		r.RetryAfter = InfDuration
+	default:
+		r.RetryAfter = w.retryAfter(elapsed, estimate, n)
+	}
+	r.Remaining = max(0, int(float64(w.limit)-estimate))
+	switch {
+	case w.curr > 0:
+		r.ResetAfter = 2*w.window - elapsed
+	case w.prev > 0:
+		r.ResetAfter = w.window - elapsed
+	}
+	return r
+}
+
+// retryAfter returns how long until a request costing n fits, given the
+// estimate at elapsed into the current window.
+func (w *SlidingWindowCounter) retryAfter(elapsed time.Duration, estimate float64, n int) time.Duration {
+	excess := estimate + float64(n) - float64(w.limit)
+	win := float64(w.window)
+	// The estimate falls by prev per window as the previous window slides
+	// out, possibly enough within the current window.
+	if w.prev > 0 {
+		if d := excess / float64(w.prev) * win; d <= win-float64(elapsed) {
+			return time.Duration(math.Ceil(d))
+		}
+	}
+	// Otherwise wait for the next window, where the current count becomes
+	// the previous one.
+	var next float64
+	if free := float64(w.limit - n); float64(w.curr) > free {
+		next = (1 - free/float64(w.curr)) * win
+	}
+	return w.window - elapsed + time.Duration(math.Ceil(next))
+}
+
+// mustBePositive panics if a window limiter is constructed with a limit or
+// window that would make it reject everything or divide by zero.
+func mustBePositive(fn string, limit int, window time.Duration) {
+	if limit <= 0 {
+		panic(fmt.Sprintf("ratelimit: %s: limit must be positive, got %d", fn, limit))
+	}
+	if window <= 0 {
+		panic(fmt.Sprintf("ratelimit: %s: window must be positive, got %v", fn, window))
+	}
+}
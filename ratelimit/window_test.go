@@ -0,0 +1,162 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/ratelimit"
+)
+
+// clock is a manually advanced clock for deterministic tests. It starts on
+// a minute boundary so that window starts are predictable.
+type clock struct{ t time.Time }
+
+func newClock() *clock                   { return &clock{t: time.Unix(960_000, 0)} }
+func (c *clock) Now() time.Time          { return c.t }
+func (c *clock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestLimiter_InjectedClock(t *testing.T) {
+	clk := newClock()
+	l := ratelimit.NewLimiter(1, 1, ratelimit.WithClock(clk.Now))
+	if !l.Allow() || l.Allow() {
+		t.Fatal("expected exactly one request to pass")
+	}
+	clk.Advance(time.Second)
+	if !l.Allow() {
+		t.Fatal("expected a token after one second")
+	}
+}
+
+func TestFixedWindow(t *testing.T) {
+	clk := newClock()
+	w := ratelimit.NewFixedWindow(3, time.Minute, ratelimit.WithClock(clk.Now))
+
+	clk.Advance(20 * time.Second)
+	for i := range 3 {
+		if !w.Allow() {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	r := w.Reserve(t.Context())
+	if r.OK || r.Remaining != 0 || r.RetryAfter != 40*time.Second || r.ResetAfter != 40*time.Second {
+		t.Fatalf("unexpected reservation %+v", r)
+	}
+	if r := w.ReserveN(t.Context(), 4); r.RetryAfter != ratelimit.InfDuration {
+		t.Fatalf("cost above limit: %+v", r)
+	}
+
+	clk.Advance(40 * time.Second)
+	if r := w.Reserve(t.Context()); !r.OK || r.Remaining != 2 {
+		t.Fatalf("new window: %+v", r)
+	}
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	clk := newClock()
+	w := ratelimit.NewSlidingWindowLog(3, time.Minute, ratelimit.WithClock(clk.Now))
+
+	w.Allow() // t=0
+	clk.Advance(10 * time.Second)
+	w.AllowN(2)                   // t=10
+	clk.Advance(10 * time.Second) // t=20
+
+	r := w.Reserve(t.Context())
+	if r.OK || r.RetryAfter != 40*time.Second || r.ResetAfter != 50*time.Second {
+		t.Fatalf("unexpected reservation %+v", r)
+	}
+	// Two tokens need the entry from t=10 to expire.
+	if r := w.ReserveN(t.Context(), 2); r.OK || r.RetryAfter != 50*time.Second {
+		t.Fatalf("cost 2: %+v", r)
+	}
+
+	clk.Advance(40 * time.Second) // t=60: the first entry expired
+	if r := w.Reserve(t.Context()); !r.OK || r.Remaining != 0 {
+		t.Fatalf("after expiry: %+v", r)
+	}
+}
+
+func TestSlidingWindowCounter(t *testing.T) {
+	clk := newClock()
+	w := ratelimit.NewSlidingWindowCounter(10, time.Minute, ratelimit.WithClock(clk.Now))
+
+	if !w.AllowN(10) {
+		t.Fatal("first window must admit the limit")
+	}
+	// A quarter into the next window, 75% of the previous count still
+	// weighs in: estimate 7.5, so two more fit and a third does not.
+	clk.Advance(time.Minute + 15*time.Second)
+	if !w.AllowN(2) {
+		t.Fatal("expected 2 more requests to fit")
+	}
+	r := w.Reserve(t.Context())
+	if r.OK {
+		t.Fatalf("expected rejection, got %+v", r)
+	}
+	// 0.5 excess / 10 per window = 3s.
+	if r.RetryAfter != 3*time.Second {
+		t.Fatalf("RetryAfter = %v, want 3s", r.RetryAfter)
+	}
+	clk.Advance(r.RetryAfter)
+	if !w.Allow() {
+		t.Fatal("expected admission after RetryAfter")
+	}
+
+	// Two idle windows forget everything.
+	clk.Advance(2 * time.Minute)
+	if r := w.Reserve(t.Context()); !r.OK || r.Remaining != 9 {
+		t.Fatalf("after idle: %+v", r)
+	}
+}
+
+func TestSetScale(t *testing.T) {
+	clk := newClock()
+	for name, a := range map[string]ratelimit.Algorithm{
+		"token bucket": ratelimit.NewLimiter(0.001, 10, ratelimit.WithClock(clk.Now)),
+		"fixed":        ratelimit.NewFixedWindow(10, time.Hour, ratelimit.WithClock(clk.Now)),
+		"log":          ratelimit.NewSlidingWindowLog(10, time.Hour, ratelimit.WithClock(clk.Now)),
+		"counter":      ratelimit.NewSlidingWindowCounter(10, time.Hour, ratelimit.WithClock(clk.Now)),
+	} {
+		a.(ratelimit.Scaler).SetScale(0.25)
+		admitted := 0
+		for a.Allow() {
+			admitted++
+		}
+		if admitted != 3 {
+			t.Errorf("%s: admitted %d at scale 0.25, want 3", name, admitted)
+		}
+
+		a.(ratelimit.Scaler).SetScale(1)
+		if r := a.Reserve(t.Context()); r.Limit != 10 {
+			t.Errorf("%s: limit %d after scaling back, want 10", name, r.Limit)
+		}
+	}
+}
+
+func TestWindowLimiters_RejectInvalidParameters(t *testing.T) {
+	ctors := map[string]func(int, time.Duration, ...ratelimit.Option) ratelimit.Algorithm{
+		"fixed": func(l int, w time.Duration, o ...ratelimit.Option) ratelimit.Algorithm {
+			return ratelimit.NewFixedWindow(l, w, o...)
+		},
+		"log": func(l int, w time.Duration, o ...ratelimit.Option) ratelimit.Algorithm {
+			return ratelimit.NewSlidingWindowLog(l, w, o...)
+		},
+		"counter": func(l int, w time.Duration, o ...ratelimit.Option) ratelimit.Algorithm {
+			return ratelimit.NewSlidingWindowCounter(l, w, o...)
+		},
+	}
+	for name, ctor := range ctors {
+		for _, tt := range []struct {
+			limit  int
+			window time.Duration
+		}{{10, 0}, {10, -time.Second}, {0, time.Minute}, {-1, time.Minute}} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s(%d, %v) did not panic", name, tt.limit, tt.window)
+					}
+				}()
+				ctor(tt.limit, tt.window)
+			}()
+		}
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"math"
 	"math/rand"
 	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
 )
 
 // backoff returns the delay for the given attempt (0-indexed) according to
@@ -26,3 +29,14 @@ func backoff(cfg Config, attempt int) time.Duration {
 	}
 	return time.Duration(delay)
 }
+
+// retryDelay returns the delay requested by the server through an
+// errdetails.RetryInfo detail on st, if any.
+func retryDelay(st *status.Status) (time.Duration, bool) {
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+			return max(ri.GetRetryDelay().AsDuration(), 0), true
+		}
+	}
+	return 0, false
+}
@@ -34,9 +34,15 @@ type Config struct {
 // Do calls fn up to cfg.MaxAttempts times, retrying only when the returned
 // error carries a gRPC status code listed in cfg.RetryCodes. Between
 // attempts an exponential back-off delay (with optional jitter) is applied.
+// When the status carries an errdetails.RetryInfo (as rate-limit rejections
+// do), its retry delay is used instead, since the server knows exactly when
+// the request will be admitted; it is capped at cfg.MaxDelay when that is
+// set.
 //
 // The context is checked before every retry; if ctx is done the function
-// returns immediately with the context error.
+// returns immediately with the context error. If a RetryInfo delay would
+// outlast the context deadline, the retry is abandoned and the server's
+// error is returned without waiting.
 func Do[T any](ctx context.Context, cfg Config, fn func(context.Context) (T, error)) (T, error) {
 	var zero T
 	attempts := max(cfg.MaxAttempts, 1)
@@ -53,12 +59,26 @@ func Do[T any](ctx context.Context, cfg Config, fn func(context.Context) (T, err
 		}
 
 		// Check whether the error code is retryable.
-		if st, ok := status.FromError(err); !ok || !slices.Contains(cfg.RetryCodes, st.Code()) {
+		st, ok := status.FromError(err)
+		if !ok || !slices.Contains(cfg.RetryCodes, st.Code()) {
 			return zero, err
 		}
 
-		// Wait with back-off, but respect context cancellation.
-		delay := backoff(cfg, i)
+		// Wait with back-off (or the server's RetryInfo delay), but respect
+		// context cancellation.
+		delay, ok := retryDelay(st)
+		if !ok {
+			delay = backoff(cfg, i)
+		} else {
+			if cfg.MaxDelay > 0 {
+				delay = min(delay, cfg.MaxDelay)
+			}
+			// The server will not admit the call before the delay is
+			// over; there is no point in waiting past the deadline.
+			if deadline, ok := ctx.Deadline(); ok && delay > time.Until(deadline) {
+				return zero, err
+			}
+		}
 		timer := time.NewTimer(delay)
 		select {
 		case <-ctx.Done():
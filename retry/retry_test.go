@@ -6,8 +6,10 @@ import (
 	"testing"
 	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func TestDo_RetriesOnUnavailableThenSucceeds(t *testing.T) {
@@ -153,3 +155,90 @@ func TestBackoff_ExponentialWithCap(t *testing.T) {
 		t.Fatalf("attempt 3: expected 500ms (capped), got %v", d3)
 	}
 }
+
+// retryInfoErr returns a ResourceExhausted error asking to retry after d.
+func retryInfoErr(t *testing.T, d time.Duration) error {
+	t.Helper()
+	st, err := status.New(codes.ResourceExhausted, "rate limit exceeded").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(d)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+func TestDo_HonorsRetryInfo(t *testing.T) {
+	cfg := Config{
+		MaxAttempts: 2,
+		BaseDelay:   time.Hour, // would time out the test if RetryInfo were ignored
+		MaxDelay:    time.Hour,
+		RetryCodes:  []codes.Code{codes.ResourceExhausted},
+	}
+
+	calls := 0
+	start := time.Now()
+	_, err := Do(t.Context(), cfg, func(_ context.Context) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", retryInfoErr(t, 30*time.Millisecond)
+		}
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("expected to wait the RetryInfo delay, waited %v", elapsed)
+	}
+}
+
+func TestDo_CapsRetryInfoAtMaxDelay(t *testing.T) {
+	cfg := Config{
+		MaxAttempts: 2,
+		MaxDelay:    20 * time.Millisecond,
+		RetryCodes:  []codes.Code{codes.ResourceExhausted},
+	}
+
+	calls := 0
+	start := time.Now()
+	_, err := Do(t.Context(), cfg, func(_ context.Context) (string, error) {
+		calls++
+		if calls == 1 {
+			return "", retryInfoErr(t, time.Hour)
+		}
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("RetryInfo delay not capped, waited %v", elapsed)
+	}
+}
+
+func TestDo_GivesUpWhenDelayOutlastsDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	cfg := Config{
+		MaxAttempts: 3,
+		MaxDelay:    time.Hour,
+		RetryCodes:  []codes.Code{codes.ResourceExhausted},
+	}
+
+	calls := 0
+	start := time.Now()
+	_, err := Do(ctx, cfg, func(_ context.Context) (string, error) {
+		calls++
+		return "", retryInfoErr(t, time.Minute)
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the server error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up at once, waited %v", elapsed)
+	}
+}
@@ -0,0 +1,247 @@
+// Package revocation maintains a deny set of revoked token IDs (jti) and
+// subjects so that JWTs can be invalidated before they expire. Revoking a
+// subject invalidates the tokens issued to it until then; tokens issued
+// afterwards, e.g. after the user logged in again, are accepted.
+//
+// Revocations are written to Redis, which is the source of truth, and to the
+// local L1 cache; revoking reports an error when Redis did not take the
+// write, while lookups fail soft. Lookups consult L1 first; on an L1 miss the result from
+// Redis — revoked or not — is remembered locally for a short time. Every
+// revocation is also published over Redis pub/sub; replicas running
+// [List.Run] apply it to their L1 immediately, so a revoked token is
+// rejected everywhere within moments instead of after the local TTL.
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/auth"
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults applied by [NewList] when the corresponding Config field is zero.
+const (
+	defaultPrefix   = "revoked:"
+	defaultChannel  = "gorawrsquirrel:revocations"
+	defaultLocalTTL = 30 * time.Second
+)
+
+// errRevoked is allocated once to avoid per-request allocations on the hot path.
+var errRevoked = status.Error(codes.Unauthenticated, "token revoked")
+
+// Config holds the revocation list settings.
+type Config struct {
+	// Prefix is prepended to every cache key. Defaults to "revoked:".
+	Prefix string
+
+	// Channel is the Redis pub/sub channel used to propagate revocations.
+	// Defaults to "gorawrsquirrel:revocations".
+	Channel string
+
+	// LocalTTL bounds how long a "not revoked" answer is kept in L1. It is
+	// the worst-case propagation delay when a pub/sub message is missed.
+	// Defaults to 30 seconds.
+	LocalTTL time.Duration
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Token identifies the token a request authenticated with.
+type Token struct {
+	// ID is the token ID (jti), or empty if the token has none.
+	ID string
+	// IssuedAt is the issue time (iat) of the token. The zero value is
+	// treated as issued before any subject revocation.
+	IssuedAt time.Time
+}
+
+// TokenFunc extracts the [Token] of a request for [List.Wrap].
+type TokenFunc func(ctx context.Context, md metadata.MD) Token
+
+// List is a revocation list backed by an L1 and an L2 cache. All methods are
+// safe for concurrent use.
+type List struct {
+	l1  *cache.L1
+	l2  *cache.L2
+	cfg Config
+}
+
+// NewList creates a List that stores revocations in l2 and caches lookups
+// in l1.
+func NewList(l1 *cache.L1, l2 *cache.L2, cfg Config) *List {
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultPrefix
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = defaultChannel
+	}
+	if cfg.LocalTTL <= 0 {
+		cfg.LocalTTL = defaultLocalTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &List{l1: l1, l2: l2, cfg: cfg}
+}
+
+// RevokeToken revokes the token identified by jti for ttl. ttl should be at
+// least the remaining lifetime of the token. An error means the revocation
+// may not have reached Redis or the other replicas and should be retried.
+func (l *List) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	return l.revoke(ctx, l.tokenKey(jti), entry{until: l.cfg.Now().Add(ttl)}, ttl)
+}
+
+// RevokeSubject revokes the tokens of subject issued until now. ttl should
+// be at least the lifetime of the longest-lived token. Since iat has a
+// resolution of one second, tokens issued within the second of the
+// revocation are rejected as well. Errors are reported as for
+// [List.RevokeToken].
+func (l *List) RevokeSubject(ctx context.Context, subject string, ttl time.Duration) error {
+	now := l.cfg.Now()
+	return l.revoke(ctx, l.subjectKey(subject), entry{until: now.Add(ttl), at: now}, ttl)
+}
+
+// revoke stores e under key in both tiers and announces it to other
+// replicas. The L2 Set and Publish fail soft, so Redis is called directly to
+// learn whether the write and the announcement went through. L1 is written
+// first so that this replica rejects the token even when Redis is down.
+func (l *List) revoke(ctx context.Context, key string, e entry, ttl time.Duration) error {
+	v := e.String()
+	_ = l.l1.Set(ctx, key, []byte(v), ttl)
+	rdb := l.l2.Client()
+	if err := rdb.Set(ctx, key, v, ttl).Err(); err != nil {
+		return fmt.Errorf("revocation: store %q: %w", key, err)
+	}
+	if err := rdb.Publish(ctx, l.cfg.Channel, key+"|"+v).Err(); err != nil {
+		return fmt.Errorf("revocation: publish %q: %w", key, err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether tok has been revoked, either by its ID or
+// because subject was revoked after tok was issued. Empty values are
+// ignored.
+func (l *List) IsRevoked(ctx context.Context, tok Token, subject string) bool {
+	if tok.ID != "" {
+		if _, ok := l.lookup(ctx, l.tokenKey(tok.ID)); ok {
+			return true
+		}
+	}
+	if subject == "" {
+		return false
+	}
+	e, ok := l.lookup(ctx, l.subjectKey(subject))
+	return ok && tok.IssuedAt.Before(e.at)
+}
+
+// Check returns a codes.Unauthenticated error if tok has been revoked and
+// nil otherwise. See [List.IsRevoked].
+func (l *List) Check(ctx context.Context, tok Token, subject string) error {
+	if l.IsRevoked(ctx, tok, subject) {
+		return errRevoked
+	}
+	return nil
+}
+
+// Wrap returns an [auth.AuthFunc] that runs next and then rejects the call
+// if the token returned by token, or the authenticated Actor's subject, is
+// revoked. token may be nil when only subject revocation is needed; every
+// token of a revoked subject is then rejected until the revocation expires.
+func (l *List) Wrap(next auth.AuthFunc, token TokenFunc) auth.AuthFunc {
+	return func(ctx context.Context, fullMethod string, md metadata.MD) (context.Context, error) {
+		ctx, err := next(ctx, fullMethod, md)
+		if err != nil {
+			return ctx, err
+		}
+		var tok Token
+		if token != nil {
+			tok = token(ctx, md)
+		}
+		var subject string
+		if a, ok := contextx.ActorFromContext(ctx); ok {
+			subject = a.Subject
+		}
+		return ctx, l.Check(ctx, tok, subject)
+	}
+}
+
+// Run applies revocations published by other replicas to the local L1 until
+// ctx is done. Call it in its own goroutine; the library never starts it
+// automatically.
+func (l *List) Run(ctx context.Context) error {
+	for msg := range l.l2.Subscribe(ctx, l.cfg.Channel) {
+		key, v, ok := strings.Cut(string(msg), "|")
+		if !ok || !strings.HasPrefix(key, l.cfg.Prefix) {
+			continue
+		}
+		if _, ttl, ok := l.parse(v); ok {
+			_ = l.l1.Set(ctx, key, []byte(v), ttl)
+		}
+	}
+	return ctx.Err()
+}
+
+// lookup checks L1, then L2, and remembers the answer in L1. ok reports
+// whether key is revoked.
+func (l *List) lookup(ctx context.Context, key string) (entry, bool) {
+	if v, ok, _ := l.l1.Get(ctx, key); ok {
+		e, _, revoked := l.parse(string(v))
+		return e, revoked
+	}
+	v, ok, _ := l.l2.Get(ctx, key)
+	if ok {
+		if e, ttl, revoked := l.parse(string(v)); revoked {
+			_ = l.l1.Set(ctx, key, v, ttl)
+			return e, true
+		}
+	}
+	// An empty value marks "not revoked" in L1.
+	_ = l.l1.Set(ctx, key, nil, l.cfg.LocalTTL)
+	return entry{}, false
+}
+
+// entry is a stored revocation: when it expires and, for subjects, when it
+// was made. It is encoded as "until" or "until,at" in Unix milliseconds.
+type entry struct {
+	until, at time.Time
+}
+
+func (e entry) String() string {
+	s := strconv.FormatInt(e.until.UnixMilli(), 10)
+	if !e.at.IsZero() {
+		s += "," + strconv.FormatInt(e.at.UnixMilli(), 10)
+	}
+	return s
+}
+
+// parse decodes a stored revocation and returns the time left. ok is false
+// for empty, malformed or expired values.
+func (l *List) parse(v string) (e entry, ttl time.Duration, ok bool) {
+	until, at, _ := strings.Cut(v, ",")
+	ms, err := strconv.ParseInt(until, 10, 64)
+	if err != nil {
+		return entry{}, 0, false
+	}
+	e.until = time.UnixMilli(ms)
+	if at != "" {
+		ms, err := strconv.ParseInt(at, 10, 64)
+		if err != nil {
+			return entry{}, 0, false
+		}
+		e.at = time.UnixMilli(ms)
+	}
+	ttl = e.until.Sub(l.cfg.Now())
+	return e, ttl, ttl > 0
+}
+
+func (l *List) tokenKey(jti string) string       { return l.cfg.Prefix + "jti:" + jti }
+func (l *List) subjectKey(subject string) string { return l.cfg.Prefix + "sub:" + subject }
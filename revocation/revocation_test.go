@@ -0,0 +1,181 @@
+package revocation
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/cache"
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func mustL1(t *testing.T) *cache.L1 {
+	t.Helper()
+	l1, err := cache.NewL1(1000)
+	if err != nil {
+		t.Fatalf("NewL1: %v", err)
+	}
+	return l1
+}
+
+// offlineL2 returns an L2 pointing at an unreachable address so that only
+// the L1 path is exercised, together with a short-lived context that stops
+// the Redis client from retrying for long. Lookups fail soft, revocations
+// report the Redis error but still take effect locally.
+func offlineL2(t *testing.T) (*cache.L2, context.Context) {
+	t.Helper()
+	l2 := cache.NewL2("localhost:1", "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	t.Cleanup(cancel)
+	return l2, ctx
+}
+
+func redisL2(t *testing.T) *cache.L2 {
+	t.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set, skipping Redis integration test")
+	}
+	l2 := cache.NewL2(addr, "", 0)
+	t.Cleanup(func() { _ = l2.Close() })
+	if err := l2.Ping(t.Context()); err != nil {
+		t.Fatalf("cannot reach Redis at %s: %v", addr, err)
+	}
+	return l2
+}
+
+func TestRevokeToken_LocalFastPath(t *testing.T) {
+	l2, ctx := offlineL2(t)
+	l := NewList(mustL1(t), l2, Config{})
+
+	if l.IsRevoked(ctx, Token{ID: "jti-1"}, "") {
+		t.Fatal("token should not be revoked yet")
+	}
+	if err := l.RevokeToken(ctx, "jti-1", time.Minute); err == nil {
+		t.Fatal("RevokeToken reported success although Redis is unreachable")
+	}
+	if !l.IsRevoked(ctx, Token{ID: "jti-1"}, "") {
+		t.Fatal("expected token to be revoked")
+	}
+	if l.IsRevoked(ctx, Token{ID: "jti-2"}, "") {
+		t.Fatal("unrelated token must not be revoked")
+	}
+}
+
+func TestRevokeSubject_Expires(t *testing.T) {
+	now := time.Now()
+	l2, ctx := offlineL2(t)
+	l := NewList(mustL1(t), l2, Config{Now: func() time.Time { return now }})
+
+	if err := l.RevokeSubject(ctx, "user-1", time.Minute); err == nil {
+		t.Fatal("RevokeSubject reported success although Redis is unreachable")
+	}
+	if !l.IsRevoked(ctx, Token{}, "user-1") {
+		t.Fatal("expected subject to be revoked")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if l.IsRevoked(ctx, Token{}, "user-1") {
+		t.Fatal("revocation should have expired")
+	}
+}
+
+func TestRevokeSubject_AcceptsTokensIssuedLater(t *testing.T) {
+	now := time.Now()
+	l2, ctx := offlineL2(t)
+	l := NewList(mustL1(t), l2, Config{Now: func() time.Time { return now }})
+
+	before := Token{IssuedAt: now.Add(-time.Hour)}
+	if err := l.RevokeSubject(ctx, "user-1", 24*time.Hour); err == nil {
+		t.Fatal("RevokeSubject reported success although Redis is unreachable")
+	}
+	now = now.Add(time.Minute)
+	if !l.IsRevoked(ctx, before, "user-1") {
+		t.Fatal("token issued before the revocation must be revoked")
+	}
+	if l.IsRevoked(ctx, Token{IssuedAt: now}, "user-1") {
+		t.Fatal("token issued after the revocation must be accepted")
+	}
+	if l.IsRevoked(ctx, before, "user-2") {
+		t.Fatal("tokens of other subjects must be accepted")
+	}
+}
+
+func TestWrap_RejectsRevokedSubject(t *testing.T) {
+	l2, ctx := offlineL2(t)
+	l := NewList(mustL1(t), l2, Config{})
+	next := func(ctx context.Context, _ string, _ metadata.MD) (context.Context, error) {
+		return contextx.WithActor(ctx, contextx.Actor{Subject: "user-1"}), nil
+	}
+	fn := l.Wrap(next, nil)
+
+	if _, err := fn(ctx, "/svc/M", nil); err != nil {
+		t.Fatalf("unexpected error before revocation: %v", err)
+	}
+	if err := l.RevokeSubject(ctx, "user-1", time.Minute); err == nil {
+		t.Fatal("RevokeSubject reported success although Redis is unreachable")
+	}
+	_, err := fn(ctx, "/svc/M", nil)
+	if st, _ := status.FromError(err); st.Code() != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestWrap_RejectsRevokedJTI(t *testing.T) {
+	l2, ctx := offlineL2(t)
+	l := NewList(mustL1(t), l2, Config{})
+	next := func(ctx context.Context, _ string, _ metadata.MD) (context.Context, error) { return ctx, nil }
+	jti := func(_ context.Context, md metadata.MD) Token {
+		if v := md.Get("x-jti"); len(v) > 0 {
+			return Token{ID: v[0]}
+		}
+		return Token{}
+	}
+	fn := l.Wrap(next, jti)
+
+	if err := l.RevokeToken(ctx, "abc", time.Minute); err == nil {
+		t.Fatal("RevokeToken reported success although Redis is unreachable")
+	}
+	_, err := fn(ctx, "/svc/M", metadata.Pairs("x-jti", "abc"))
+	if st, _ := status.FromError(err); st.Code() != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+	if _, err := fn(ctx, "/svc/M", metadata.Pairs("x-jti", "other")); err != nil {
+		t.Fatalf("unexpected error for other token: %v", err)
+	}
+}
+
+func TestRun_PropagatesAcrossReplicas(t *testing.T) {
+	l2 := redisL2(t)
+	cfg := Config{Channel: "test:revocations:" + t.Name(), Prefix: "test:revoked:" + t.Name() + ":"}
+	a := NewList(mustL1(t), l2, cfg)
+	b := NewList(mustL1(t), l2, cfg)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go func() { _ = b.Run(ctx) }()
+
+	// Replica b caches a negative answer in its L1.
+	if b.IsRevoked(ctx, Token{ID: "jti-x"}, "") {
+		t.Fatal("token should not be revoked yet")
+	}
+	// Give the subscription a moment to become active.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := a.RevokeToken(ctx, "jti-x", time.Minute); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !b.IsRevoked(ctx, Token{ID: "jti-x"}, "") {
+		if time.Now().After(deadline) {
+			t.Fatal("revocation did not propagate to replica b")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
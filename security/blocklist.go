@@ -0,0 +1,207 @@
+package security
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// Entry is a range in the list of an [IPBlocker].
+type Entry struct {
+	// Prefix is the range, with the host bits masked off.
+	Prefix netip.Prefix
+	// Expires is when a temporary entry stops matching. It is zero for
+	// permanent entries.
+	Expires time.Time
+}
+
+// live reports whether e still matches at the time returned by now.
+func (e Entry) live(now func() time.Time) bool {
+	return e.Expires.IsZero() || now().Before(e.Expires)
+}
+
+// EventKind tells what happened to an [Entry].
+type EventKind int
+
+const (
+	// Added reports a new entry, or a new expiry of an existing one.
+	Added EventKind = iota
+	// Removed reports an entry removed by [IPBlocker.Remove].
+	Removed
+	// Expired reports a temporary entry that reached its expiry.
+	Expired
+)
+
+// String returns "added", "removed" or "expired".
+func (k EventKind) String() string {
+	switch k {
+	case Removed:
+		return "removed"
+	case Expired:
+		return "expired"
+	default:
+		return "added"
+	}
+}
+
+// Event describes a change to the list of an [IPBlocker].
+type Event struct {
+	Kind  EventKind
+	Entry Entry
+}
+
+// Add adds prefix, a CIDR or plain IP address, to the list. A positive ttl
+// makes the entry temporary: it stops matching once ttl has elapsed and is
+// then dropped from the list. Adding a range that is already listed
+// replaces its expiry, except that a temporary Add leaves a range that
+// Config.CIDRs or a list file lists permanently untouched. In DenyList mode
+// Add bans the range; in AllowList mode it grants access.
+func (b *IPBlocker) Add(prefix string, ttl time.Duration) error {
+	p, err := ParsePrefix(prefix)
+	if err != nil {
+		return fmt.Errorf("ipblock: invalid CIDR: %w", err)
+	}
+	e := Entry{Prefix: p.Masked()}
+	if ttl > 0 {
+		e.Expires = b.now().Add(ttl)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ttl > 0 && b.sources[e.Prefix] > 0 {
+		// A list names the range permanently; a ban must not make it
+		// expire.
+		return nil
+	}
+	b.entries.Store(b.entries.Load().insert(e.Prefix, e.Expires))
+
+	if t, ok := b.timers[e.Prefix]; ok {
+		t.Stop()
+		delete(b.timers, e.Prefix)
+	}
+	if ttl > 0 {
+		b.timers[e.Prefix] = time.AfterFunc(ttl, func() { b.expire(e) })
+	}
+	b.emit(Added, e)
+	return nil
+}
+
+// Remove removes prefix from the list. It reports whether the range was
+// listed; prefix must match the listed range exactly, not merely overlap it.
+func (b *IPBlocker) Remove(prefix string) (bool, error) {
+	p, err := ParsePrefix(prefix)
+	if err != nil {
+		return false, fmt.Errorf("ipblock: invalid CIDR: %w", err)
+	}
+	p = p.Masked()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next, e, ok := b.entries.Load().remove(p)
+	if !ok {
+		return false, nil
+	}
+	b.entries.Store(next)
+	if t, ok := b.timers[p]; ok {
+		t.Stop()
+		delete(b.timers, p)
+	}
+	b.emit(Removed, e)
+	return true, nil
+}
+
+// List returns the entries that currently match, IPv4 before IPv6 and in
+// address order.
+func (b *IPBlocker) List() []Entry {
+	entries := b.entries.Load().entries()
+	out := entries[:0]
+	for _, e := range entries {
+		if e.live(b.now) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Len returns the number of entries, including temporary entries that
+// expired but were not dropped yet.
+func (b *IPBlocker) Len() int {
+	return b.entries.Load().len()
+}
+
+// expire drops e when its timer fires, unless it was replaced or removed in
+// the meantime.
+func (b *IPBlocker) expire(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cur := b.entries.Load()
+	if o, ok := cur.get(e.Prefix); !ok || !o.Expires.Equal(e.Expires) {
+		return
+	}
+	next, _, _ := cur.remove(e.Prefix)
+	b.entries.Store(next)
+	delete(b.timers, e.Prefix)
+	b.emit(Expired, e)
+}
+
+// emit reports a change to the OnChange callback, if any.
+func (b *IPBlocker) emit(kind EventKind, e Entry) {
+	if b.onChange != nil {
+		b.onChange(Event{Kind: kind, Entry: e})
+	}
+}
+
+// replace swaps the permanent entries prev of one source, such as a list
+// file, for next, emitting an event for every difference. A range is only
+// removed once no source lists it any more, counting Config.CIDRs as one
+// source. Entries of prev that are temporary by now were re-added by someone
+// else and are kept; entries of next replace temporary entries of the same
+// range.
+func (b *IPBlocker) replace(prev, next []netip.Prefix) {
+	was, now := prefixSet(prev), prefixSet(next)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.entries.Load()
+	var events []Event
+	for p := range was {
+		if _, ok := now[p]; ok {
+			continue
+		}
+		if b.sources[p]--; b.sources[p] > 0 {
+			continue
+		}
+		delete(b.sources, p)
+		if e, ok := t.get(p); ok && e.Expires.IsZero() {
+			t, _, _ = t.remove(p)
+			events = append(events, Event{Kind: Removed, Entry: e})
+		}
+	}
+	for p := range now {
+		if _, ok := was[p]; !ok {
+			b.sources[p]++
+		}
+		if e, ok := t.get(p); ok && e.Expires.IsZero() {
+			continue
+		}
+		t = t.insert(p, time.Time{})
+		if tm, ok := b.timers[p]; ok {
+			tm.Stop()
+			delete(b.timers, p)
+		}
+		events = append(events, Event{Kind: Added, Entry: Entry{Prefix: p}})
+	}
+	b.entries.Store(t)
+	for _, ev := range events {
+		b.emit(ev.Kind, ev.Entry)
+	}
+}
+
+// prefixSet returns the distinct masked ranges of ps.
+func prefixSet(ps []netip.Prefix) map[netip.Prefix]struct{} {
+	set := make(map[netip.Prefix]struct{}, len(ps))
+	for _, p := range ps {
+		set[p.Masked()] = struct{}{}
+	}
+	return set
+}
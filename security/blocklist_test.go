@@ -0,0 +1,194 @@
+package security
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+func peerCtx(t *testing.T, addr string) context.Context {
+	return peer.NewContext(t.Context(), &peer.Peer{Addr: fakePeerAddr{addr: addr}})
+}
+
+func TestIPBlocker_AddRemove(t *testing.T) {
+	var events []Event
+	b, err := NewIPBlocker(Config{
+		Mode:     DenyList,
+		CIDRs:    []string{"10.0.0.0/8"},
+		OnChange: func(e Event) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := peerCtx(t, "203.0.113.7:5000")
+
+	if !b.Evaluate(ctx, nil) {
+		t.Fatal("expected 203.0.113.7 to be allowed before the ban")
+	}
+	if err := b.Add("203.0.113.7", 0); err != nil {
+		t.Fatal(err)
+	}
+	if b.Evaluate(ctx, nil) {
+		t.Fatal("expected 203.0.113.7 to be banned")
+	}
+
+	got := b.List()
+	want := []string{"10.0.0.0/8", "203.0.113.7/32"}
+	if len(got) != len(want) {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+	for i, e := range got {
+		if e.Prefix.String() != want[i] || !e.Expires.IsZero() {
+			t.Fatalf("List[%d] = %+v, want permanent %s", i, e, want[i])
+		}
+	}
+
+	if ok, err := b.Remove("203.0.113.7/32"); err != nil || !ok {
+		t.Fatalf("Remove = %v, %v; want true", ok, err)
+	}
+	if ok, _ := b.Remove("203.0.113.7/32"); ok {
+		t.Fatal("second Remove reported a listed range")
+	}
+	if !b.Evaluate(ctx, nil) {
+		t.Fatal("expected 203.0.113.7 to be allowed after removal")
+	}
+
+	kinds := make([]EventKind, len(events))
+	for i, e := range events {
+		kinds[i] = e.Kind
+	}
+	if !slices.Equal(kinds, []EventKind{Added, Removed}) {
+		t.Fatalf("events = %v, want [added removed]", kinds)
+	}
+
+	if err := b.Add("nope", 0); err == nil {
+		t.Fatal("expected error for invalid CIDR")
+	}
+}
+
+func TestIPBlocker_TTLWithFakeClock(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	b, err := NewIPBlocker(Config{Mode: DenyList, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := peerCtx(t, "198.51.100.1:5000")
+
+	if err := b.Add("198.51.100.0/24", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if b.Evaluate(ctx, nil) {
+		t.Fatal("expected temporary ban to match")
+	}
+	if l := b.List(); len(l) != 1 || !l[0].Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("List = %v, want one entry expiring in an hour", l)
+	}
+
+	now = now.Add(time.Hour)
+	if !b.Evaluate(ctx, nil) {
+		t.Fatal("expected expired ban not to match")
+	}
+	if l := b.List(); len(l) != 0 {
+		t.Fatalf("List = %v, want empty", l)
+	}
+}
+
+func TestIPBlocker_TemporaryAddKeepsListedRangePermanent(t *testing.T) {
+	var events []Event
+	b, err := NewIPBlocker(Config{
+		Mode:     DenyList,
+		CIDRs:    []string{"10.0.0.0/8"},
+		OnChange: func(e Event) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// E.g. a fail-ban of a range that is already denied by configuration.
+	if err := b.Add("10.0.0.0/8", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if l := b.List(); len(l) != 1 || !l[0].Expires.IsZero() {
+		t.Fatalf("List = %v, want 10.0.0.0/8 still permanent", l)
+	}
+	if len(events) != 0 {
+		t.Fatalf("events = %v, want none", events)
+	}
+}
+
+func TestIPBlocker_ExpiryEvent(t *testing.T) {
+	var mu sync.Mutex
+	var events []Event
+	expired := make(chan struct{})
+	b, err := NewIPBlocker(Config{
+		Mode: DenyList,
+		OnChange: func(e Event) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+			if e.Kind == Expired {
+				close(expired)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A re-ban replaces the expiry; only the second timer may fire.
+	if err := b.Add("192.0.2.1", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add("192.0.2.1", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the expiry event")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 3 || events[2].Entry.Prefix.String() != "192.0.2.1/32" {
+		t.Fatalf("events = %v, want added, added, expired 192.0.2.1/32", events)
+	}
+	if l := b.Len(); l != 0 {
+		t.Fatalf("Len = %d, want expired entry dropped", l)
+	}
+}
+
+func TestIPBlocker_ConcurrentEvaluate(t *testing.T) {
+	b, err := NewIPBlocker(Config{Mode: DenyList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := peerCtx(t, "192.0.2.9:5000")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					b.Evaluate(ctx, nil)
+				}
+			}
+		}()
+	}
+	for range 100 {
+		_ = b.Add("192.0.2.9", 0)
+		_, _ = b.Remove("192.0.2.9")
+	}
+	close(stop)
+	wg.Wait()
+}
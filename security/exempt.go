@@ -0,0 +1,185 @@
+package security
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"google.golang.org/grpc/metadata"
+)
+
+// HeaderBypassToken is the metadata key carrying a signed bypass token
+// created by [SignBypassToken].
+const HeaderBypassToken = "x-rawr-bypass"
+
+// Reasons reported in [Exemption.Reason].
+const (
+	ExemptCIDR     = "cidr"
+	ExemptSubject  = "subject"
+	ExemptClientID = "client_id"
+	ExemptToken    = "token"
+)
+
+// ExemptionConfig holds the rules of an [Exemptions] matcher. A request is
+// exempt when any rule matches.
+type ExemptionConfig struct {
+	// CIDRs exempts client addresses in these ranges, e.g. the monitoring
+	// network. Plain IPs are treated as single-host prefixes.
+	CIDRs []string
+
+	// Subjects exempts authenticated Actors with one of these subjects.
+	Subjects []string
+
+	// ClientIDs exempts authenticated Actors with one of these client IDs.
+	ClientIDs []string
+
+	// BypassKeys maps key IDs to the secrets that bypass tokens in the
+	// x-rawr-bypass metadata are signed with.
+	BypassKeys map[string][]byte
+
+	// TrustedProxies and HeaderPriority resolve the client address as in
+	// [Config].
+	TrustedProxies []string
+	HeaderPriority []string
+
+	// Now returns the current time used to check token expiry. Defaults to
+	// time.Now.
+	Now func() time.Time
+}
+
+// Exemption describes why a request is exempt.
+type Exemption struct {
+	// Reason is ExemptCIDR, ExemptSubject, ExemptClientID or ExemptToken.
+	Reason string
+	// Match is the rule that matched: the CIDR, subject, client ID or
+	// bypass key ID.
+	Match string
+}
+
+// Exemptions decides whether a request is exempt from rate limiting,
+// concurrency limits and IP blocking. It is safe for concurrent use.
+type Exemptions struct {
+	cidrs     *prefixTrie
+	subjects  map[string]struct{}
+	clientIDs map[string]struct{}
+	keys      map[string][]byte
+	resolver  *ClientResolver
+	now       func() time.Time
+}
+
+// NewExemptions creates an Exemptions matcher from cfg. It returns an error
+// if any CIDR or trusted proxy entry is invalid.
+func NewExemptions(cfg ExemptionConfig) (*Exemptions, error) {
+	cidrs, err := parsePrefixes(cfg.CIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("exempt: invalid CIDR: %w", err)
+	}
+	r, err := NewClientResolver(cfg.TrustedProxies, cfg.HeaderPriority)
+	if err != nil {
+		return nil, fmt.Errorf("exempt: %w", err)
+	}
+	now := cfg.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &Exemptions{
+		cidrs:     newPrefixTrie(cidrs),
+		subjects:  setOf(cfg.Subjects),
+		clientIDs: setOf(cfg.ClientIDs),
+		keys:      cfg.BypassKeys,
+		resolver:  r,
+		now:       now,
+	}, nil
+}
+
+// MatchPeer evaluates the rules known before authentication: the client
+// address and the bypass token in md.
+func (e *Exemptions) MatchPeer(ctx context.Context, md metadata.MD) (Exemption, bool) {
+	if e.cidrs.len() > 0 {
+		if addr, ok := e.resolver.ClientAddr(ctx, md); ok {
+			if p, ok := e.cidrs.lookup(addr, nil); ok {
+				return Exemption{Reason: ExemptCIDR, Match: p.String()}, true
+			}
+		}
+	}
+	if len(e.keys) > 0 {
+		for _, tok := range md.Get(HeaderBypassToken) {
+			if id, ok := e.verifyToken(tok); ok {
+				return Exemption{Reason: ExemptToken, Match: id}, true
+			}
+		}
+	}
+	return Exemption{}, false
+}
+
+// MatchActor evaluates the rules that need the authenticated Actor.
+func (e *Exemptions) MatchActor(a contextx.Actor) (Exemption, bool) {
+	if _, ok := e.subjects[a.Subject]; ok && a.Subject != "" {
+		return Exemption{Reason: ExemptSubject, Match: a.Subject}, true
+	}
+	if _, ok := e.clientIDs[a.ClientID]; ok && a.ClientID != "" {
+		return Exemption{Reason: ExemptClientID, Match: a.ClientID}, true
+	}
+	return Exemption{}, false
+}
+
+// HasActorRules reports whether MatchActor can match at all.
+func (e *Exemptions) HasActorRules() bool {
+	return len(e.subjects) > 0 || len(e.clientIDs) > 0
+}
+
+// SignBypassToken returns a bypass token for the key keyID that is valid
+// until expires. Send it in the x-rawr-bypass metadata. keyID must not
+// contain a dot.
+func SignBypassToken(secret []byte, keyID string, expires time.Time) string {
+	payload := keyID + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + bypassSignature(secret, payload)
+}
+
+// verifyToken checks a token of the form "<key ID>.<expiry>.<signature>"
+// and returns its key ID.
+func (e *Exemptions) verifyToken(tok string) (string, bool) {
+	i := strings.LastIndexByte(tok, '.')
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := tok[:i], tok[i+1:]
+	id, exp, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	secret, ok := e.keys[id]
+	if !ok {
+		return "", false
+	}
+	if !hmac.Equal([]byte(sig), []byte(bypassSignature(secret, payload))) {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !e.now().Before(time.Unix(unix, 0)) {
+		return "", false
+	}
+	return id, true
+}
+
+// bypassSignature is the unpadded base64url HMAC-SHA256 of payload.
+func bypassSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setOf returns the members of ss as a set.
+func setOf(ss []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(ss))
+	for _, s := range ss {
+		m[s] = struct{}{}
+	}
+	return m
+}
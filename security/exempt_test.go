@@ -0,0 +1,93 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestExemptions_MatchPeerCIDR(t *testing.T) {
+	ex, err := NewExemptions(ExemptionConfig{
+		CIDRs:          []string{"10.20.0.0/16"},
+		TrustedProxies: []string{"172.16.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	direct := peer.NewContext(t.Context(), &peer.Peer{Addr: fakePeerAddr{addr: "10.20.3.4:5000"}})
+	if got, ok := ex.MatchPeer(direct, nil); !ok || got.Reason != ExemptCIDR || got.Match != "10.20.0.0/16" {
+		t.Fatalf("MatchPeer = %+v, %v; want cidr 10.20.0.0/16", got, ok)
+	}
+
+	proxied := peer.NewContext(t.Context(), &peer.Peer{Addr: fakePeerAddr{addr: "172.16.0.1:5000"}})
+	if _, ok := ex.MatchPeer(proxied, metadata.Pairs("x-forwarded-for", "10.20.9.9")); !ok {
+		t.Fatal("expected forwarded client behind trusted proxy to be exempt")
+	}
+
+	other := peer.NewContext(t.Context(), &peer.Peer{Addr: fakePeerAddr{addr: "10.21.0.1:5000"}})
+	if _, ok := ex.MatchPeer(other, nil); ok {
+		t.Fatal("expected 10.21.0.1 not to be exempt")
+	}
+}
+
+func TestExemptions_BypassToken(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	secret := []byte("s3cret")
+	ex, err := NewExemptions(ExemptionConfig{
+		BypassKeys: map[string][]byte{"ops": secret},
+		Now:        func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := func(tok string) bool {
+		_, ok := ex.MatchPeer(t.Context(), metadata.Pairs(HeaderBypassToken, tok))
+		return ok
+	}
+
+	valid := SignBypassToken(secret, "ops", now.Add(time.Minute))
+	if got, ok := ex.MatchPeer(t.Context(), metadata.Pairs(HeaderBypassToken, valid)); !ok || got.Reason != ExemptToken || got.Match != "ops" {
+		t.Fatalf("MatchPeer = %+v, %v; want token ops", got, ok)
+	}
+	if match(SignBypassToken(secret, "ops", now)) {
+		t.Fatal("expired token accepted")
+	}
+	if match(SignBypassToken([]byte("wrong"), "ops", now.Add(time.Minute))) {
+		t.Fatal("token with wrong secret accepted")
+	}
+	if match(SignBypassToken(secret, "dev", now.Add(time.Minute))) {
+		t.Fatal("token with unknown key accepted")
+	}
+	if match(valid[:len(valid)-1]) || match("garbage") {
+		t.Fatal("malformed token accepted")
+	}
+}
+
+func TestExemptions_MatchActor(t *testing.T) {
+	ex, err := NewExemptions(ExemptionConfig{
+		Subjects:  []string{"svc-batch"},
+		ClientIDs: []string{"probe"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ex.MatchActor(contextx.Actor{Subject: "svc-batch"}); !ok || got.Reason != ExemptSubject {
+		t.Fatalf("MatchActor = %+v, %v; want subject", got, ok)
+	}
+	if got, ok := ex.MatchActor(contextx.Actor{Subject: "user-1", ClientID: "probe"}); !ok || got.Reason != ExemptClientID {
+		t.Fatalf("MatchActor = %+v, %v; want client_id", got, ok)
+	}
+	if _, ok := ex.MatchActor(contextx.Actor{Subject: "user-1"}); ok {
+		t.Fatal("expected user-1 not to be exempt")
+	}
+}
+
+func TestNewExemptions_InvalidCIDR(t *testing.T) {
+	if _, err := NewExemptions(ExemptionConfig{CIDRs: []string{"not-a-cidr"}}); err == nil {
+		t.Fatal("expected error for invalid CIDR")
+	}
+}
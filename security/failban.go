@@ -0,0 +1,315 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Defaults applied by [NewBanDetector] when the corresponding BanConfig
+// field is zero.
+const (
+	defaultMaxFailures    = 10
+	defaultFailureWindow  = time.Minute
+	defaultBanDuration    = 10 * time.Minute
+	defaultMaxBanDuration = 24 * time.Hour
+	defaultBanMaxKeys     = 100_000
+)
+
+// Reasons reported in [Ban.Reason].
+const (
+	BanByIP    = "ip"
+	BanByActor = "actor"
+)
+
+// BanConfig holds the parameters of a [BanDetector].
+type BanConfig struct {
+	// Codes are the status codes that count as failures. Defaults to
+	// codes.Unauthenticated.
+	Codes []codes.Code
+
+	// MaxFailures is the number of failures within Window that trigger a
+	// ban. Defaults to 10.
+	MaxFailures int
+
+	// Window is the sliding window failures are counted over. Defaults to
+	// one minute.
+	Window time.Duration
+
+	// ByActor also counts failures per subject. Once a subject reaches
+	// MaxFailures, every client IP that fails as that subject during the
+	// next BanDuration is banned, which catches attacks on one account
+	// spread over many addresses. A failed authentication only has a
+	// subject if the AuthFunc reported the one it tried with
+	// contextx.ReportSubject; the HMAC verifier reports the key ID.
+	ByActor bool
+
+	// BanDuration is the length of the first ban of an IP. Every further
+	// ban doubles it, up to MaxBanDuration. Defaults to ten minutes.
+	BanDuration time.Duration
+
+	// MaxBanDuration caps escalating bans. Defaults to 24 hours.
+	MaxBanDuration time.Duration
+
+	// ResetAfter is how long an IP must go without a ban before its next
+	// ban starts at BanDuration again. Defaults to MaxBanDuration.
+	ResetAfter time.Duration
+
+	// Allow lists CIDRs that are never counted or banned, e.g. the office
+	// NAT or other replicas.
+	Allow []string
+
+	// MaxKeys bounds the number of IPs and subjects tracked at a time.
+	// Failures of new keys are ignored while the table is full of active
+	// keys. Defaults to 100 000.
+	MaxKeys int
+
+	// Now returns the current time. Defaults to time.Now; tests may inject
+	// a fake clock.
+	Now func() time.Time
+}
+
+// Ban describes a temporary ban issued by a [BanDetector].
+type Ban struct {
+	// Addr is the banned client IP.
+	Addr netip.Addr
+	// Duration is the length of the ban.
+	Duration time.Duration
+	// Offense counts the bans of Addr since its last reset, starting at 1.
+	Offense int
+	// Reason is BanByIP or BanByActor.
+	Reason string
+	// Subject is the subject whose failures caused a BanByActor ban.
+	Subject string
+}
+
+// failures is a ring of the latest failure times of one key.
+type failures struct {
+	times []time.Time
+	next  int
+	// hotUntil is set on subjects that reached the threshold; failures as
+	// the subject before it ban the client IP right away.
+	hotUntil time.Time
+}
+
+// add records t and reports whether the ring holds max failures within
+// window of each other. A full ring is reset so counting starts over.
+func (f *failures) add(t time.Time, max int, window time.Duration) bool {
+	if len(f.times) < max {
+		f.times = append(f.times, t)
+	} else {
+		f.times[f.next] = t
+		f.next = (f.next + 1) % max
+	}
+	if len(f.times) < max {
+		return false
+	}
+	oldest := f.times[f.next%len(f.times)]
+	if t.Sub(oldest) >= window {
+		return false
+	}
+	f.times, f.next = f.times[:0], 0
+	return true
+}
+
+// last returns the time of the latest failure.
+func (f *failures) last() time.Time {
+	if len(f.times) == 0 {
+		return time.Time{}
+	}
+	return f.times[(f.next+len(f.times)-1)%len(f.times)]
+}
+
+// offense is the ban history of an IP.
+type offense struct {
+	n    int
+	last time.Time
+}
+
+// BanDetector counts failed requests per client IP, and optionally per
+// subject, and bans IPs in an [IPBlocker] that fail too often, for longer
+// on every repeat offense. It is safe for concurrent use.
+type BanDetector struct {
+	blocker *IPBlocker
+	cfg     BanConfig
+	codes   map[codes.Code]struct{}
+	allow   *prefixTrie
+
+	mu        sync.Mutex
+	ips       map[netip.Addr]*failures
+	subjects  map[string]*failures
+	offenses  map[netip.Addr]offense
+	nextSweep time.Time
+}
+
+// NewBanDetector creates a BanDetector that adds its bans to b. It returns
+// an error if b is not in DenyList mode, where adding an entry would grant
+// access instead, or if an Allow entry is invalid.
+func NewBanDetector(b *IPBlocker, cfg BanConfig) (*BanDetector, error) {
+	if b.mode != DenyList {
+		return nil, errors.New("failban: blocker must be in DenyList mode")
+	}
+	allow, err := parsePrefixes(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("failban: invalid allow CIDR: %w", err)
+	}
+	if len(cfg.Codes) == 0 {
+		cfg.Codes = []codes.Code{codes.Unauthenticated}
+	}
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = defaultMaxFailures
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultFailureWindow
+	}
+	if cfg.BanDuration <= 0 {
+		cfg.BanDuration = defaultBanDuration
+	}
+	if cfg.MaxBanDuration <= 0 {
+		cfg.MaxBanDuration = defaultMaxBanDuration
+	}
+	cfg.MaxBanDuration = max(cfg.MaxBanDuration, cfg.BanDuration)
+	if cfg.ResetAfter <= 0 {
+		cfg.ResetAfter = cfg.MaxBanDuration
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = defaultBanMaxKeys
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	cs := make(map[codes.Code]struct{}, len(cfg.Codes))
+	for _, c := range cfg.Codes {
+		cs[c] = struct{}{}
+	}
+	return &BanDetector{
+		blocker:  b,
+		cfg:      cfg,
+		codes:    cs,
+		allow:    newPrefixTrie(allow),
+		ips:      make(map[netip.Addr]*failures),
+		subjects: make(map[string]*failures),
+		offenses: make(map[netip.Addr]offense),
+	}, nil
+}
+
+// Counts reports whether code counts as a failure.
+func (d *BanDetector) Counts(code codes.Code) bool {
+	_, ok := d.codes[code]
+	return ok
+}
+
+// Observe records a request from addr, authenticated as subject (empty if
+// unknown), that ended with code. When the failure triggers a ban, the IP is
+// added to the blocker and the ban is returned.
+func (d *BanDetector) Observe(addr netip.Addr, subject string, code codes.Code) (Ban, bool) {
+	if !addr.IsValid() || !d.Counts(code) {
+		return Ban{}, false
+	}
+	addr = addr.Unmap()
+	if d.allow.contains(addr, nil) {
+		return Ban{}, false
+	}
+
+	now := d.cfg.Now()
+	d.mu.Lock()
+	ban, ok := d.observe(now, addr, subject)
+	d.mu.Unlock()
+	if ok {
+		// The prefix comes from a valid address and cannot fail to parse.
+		_ = d.blocker.Add(netip.PrefixFrom(addr, addr.BitLen()).String(), ban.Duration)
+	}
+	return ban, ok
+}
+
+// observe is Observe with d.mu held.
+func (d *BanDetector) observe(now time.Time, addr netip.Addr, subject string) (Ban, bool) {
+	if d.cfg.ByActor && subject != "" {
+		f := track(d, d.subjects, subject, now)
+		switch {
+		case f == nil:
+		case now.Before(f.hotUntil):
+			return d.ban(now, addr, BanByActor, subject), true
+		case f.add(now, d.cfg.MaxFailures, d.cfg.Window):
+			f.hotUntil = now.Add(d.cfg.BanDuration)
+			delete(d.ips, addr)
+			return d.ban(now, addr, BanByActor, subject), true
+		}
+	}
+	f := track(d, d.ips, addr, now)
+	if f == nil || !f.add(now, d.cfg.MaxFailures, d.cfg.Window) {
+		return Ban{}, false
+	}
+	delete(d.ips, addr)
+	return d.ban(now, addr, BanByIP, ""), true
+}
+
+// ban escalates the offense count of addr and returns its ban.
+func (d *BanDetector) ban(now time.Time, addr netip.Addr, reason, subject string) Ban {
+	o := d.offenses[addr]
+	if now.Sub(o.last) >= d.cfg.ResetAfter {
+		o.n = 0
+	}
+	o.n++
+	o.last = now
+	d.offenses[addr] = o
+
+	dur := d.cfg.BanDuration
+	for i := 1; i < o.n && dur < d.cfg.MaxBanDuration; i++ {
+		dur *= 2
+	}
+	return Ban{
+		Addr:     addr,
+		Duration: min(dur, d.cfg.MaxBanDuration),
+		Offense:  o.n,
+		Reason:   reason,
+		Subject:  subject,
+	}
+}
+
+// track returns the failures of key in m, creating them if there is room.
+// It returns nil when the table is full of active keys. A full table is
+// swept at most once per tenth of the window, so that a flood of new keys
+// does not cost a scan of the whole table each.
+func track[K comparable](d *BanDetector, m map[K]*failures, key K, now time.Time) *failures {
+	if f, ok := m[key]; ok {
+		return f
+	}
+	if len(m)+len(d.offenses) >= d.cfg.MaxKeys {
+		if now.Before(d.nextSweep) {
+			return nil
+		}
+		d.sweep(now)
+		d.nextSweep = now.Add(d.cfg.Window / 10)
+		if len(m)+len(d.offenses) >= d.cfg.MaxKeys {
+			return nil
+		}
+	}
+	f := &failures{}
+	m[key] = f
+	return f
+}
+
+// sweep forgets keys without failures in the window and ban histories
+// older than ResetAfter.
+func (d *BanDetector) sweep(now time.Time) {
+	for k, f := range d.ips {
+		if now.Sub(f.last()) >= d.cfg.Window {
+			delete(d.ips, k)
+		}
+	}
+	for k, f := range d.subjects {
+		if now.Sub(f.last()) >= d.cfg.Window && !now.Before(f.hotUntil) {
+			delete(d.subjects, k)
+		}
+	}
+	for k, o := range d.offenses {
+		if now.Sub(o.last) >= d.cfg.ResetAfter {
+			delete(d.offenses, k)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package security
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newDetector(t *testing.T, clk *fakeClock, cfg BanConfig) (*BanDetector, *IPBlocker) {
+	t.Helper()
+	b, err := NewIPBlocker(Config{Mode: DenyList, Now: clk.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Now = clk.Now
+	d, err := NewBanDetector(b, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, b
+}
+
+// fail reports n Unauthenticated failures from addr one second apart and
+// returns the last outcome.
+func fail(d *BanDetector, clk *fakeClock, addr netip.Addr, subject string, n int) (Ban, bool) {
+	var ban Ban
+	var ok bool
+	for range n {
+		clk.Advance(time.Second)
+		ban, ok = d.Observe(addr, subject, codes.Unauthenticated)
+	}
+	return ban, ok
+}
+
+func TestBanDetector_BansAfterMaxFailures(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	d, b := newDetector(t, clk, BanConfig{MaxFailures: 3, Window: time.Minute, BanDuration: time.Hour})
+	addr := netip.MustParseAddr("203.0.113.7")
+
+	if _, ok := fail(d, clk, addr, "", 2); ok {
+		t.Fatal("banned before reaching MaxFailures")
+	}
+	if _, ok := d.Observe(addr, "", codes.NotFound); ok {
+		t.Fatal("NotFound must not count as a failure")
+	}
+	ban, ok := fail(d, clk, addr, "", 1)
+	if !ok || ban.Addr != addr || ban.Duration != time.Hour || ban.Offense != 1 || ban.Reason != BanByIP {
+		t.Fatalf("ban = %+v, %v; want first one-hour IP ban", ban, ok)
+	}
+	if l := b.List(); len(l) != 1 || l[0].Prefix.String() != "203.0.113.7/32" || !l[0].Expires.Equal(clk.t.Add(time.Hour)) {
+		t.Fatalf("blocker list = %v, want 203.0.113.7/32 for an hour", l)
+	}
+}
+
+func TestBanDetector_SlidingWindow(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	d, _ := newDetector(t, clk, BanConfig{MaxFailures: 3, Window: 10 * time.Second})
+	addr := netip.MustParseAddr("203.0.113.7")
+
+	// Failures 6s apart never put three inside ten seconds.
+	for i := range 10 {
+		clk.Advance(6 * time.Second)
+		if _, ok := d.Observe(addr, "", codes.Unauthenticated); ok {
+			t.Fatalf("failure %d: banned although the window never held 3 failures", i)
+		}
+	}
+	// The last two failures and this one span seven seconds.
+	if _, ok := fail(d, clk, addr, "", 1); !ok {
+		t.Fatal("expected ban once three failures fall inside the window")
+	}
+}
+
+func TestBanDetector_EscalatesAndResets(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	d, _ := newDetector(t, clk, BanConfig{
+		MaxFailures:    1,
+		BanDuration:    time.Minute,
+		MaxBanDuration: 3 * time.Minute,
+		ResetAfter:     time.Hour,
+	})
+	addr := netip.MustParseAddr("198.51.100.1")
+
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		ban, ok := fail(d, clk, addr, "", 1)
+		if !ok || ban.Duration != want || ban.Offense != i+1 {
+			t.Fatalf("offense %d: ban = %+v, %v; want %v", i+1, ban, ok, want)
+		}
+	}
+
+	clk.Advance(time.Hour)
+	if ban, _ := fail(d, clk, addr, "", 1); ban.Duration != time.Minute || ban.Offense != 1 {
+		t.Fatalf("after reset: ban = %+v, want first offense again", ban)
+	}
+}
+
+func TestBanDetector_AllowList(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	d, b := newDetector(t, clk, BanConfig{MaxFailures: 1, Allow: []string{"10.0.0.0/8"}})
+
+	if _, ok := fail(d, clk, netip.MustParseAddr("10.1.2.3"), "", 5); ok {
+		t.Fatal("allow-listed address was banned")
+	}
+	if _, ok := fail(d, clk, netip.MustParseAddr("::ffff:10.1.2.3"), "", 5); ok {
+		t.Fatal("IPv4-mapped allow-listed address was banned")
+	}
+	if l := b.List(); len(l) != 0 {
+		t.Fatalf("blocker list = %v, want empty", l)
+	}
+}
+
+func TestBanDetector_ByActor(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	d, b := newDetector(t, clk, BanConfig{MaxFailures: 3, ByActor: true, BanDuration: time.Minute})
+
+	// Three addresses with one failure each stay below the IP threshold,
+	// but the subject reaches it.
+	addrs := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+		netip.MustParseAddr("192.0.2.3"),
+	}
+	for _, a := range addrs[:2] {
+		if _, ok := fail(d, clk, a, "alice", 1); ok {
+			t.Fatalf("%v banned too early", a)
+		}
+	}
+	ban, ok := fail(d, clk, addrs[2], "alice", 1)
+	if !ok || ban.Reason != BanByActor || ban.Subject != "alice" || ban.Addr != addrs[2] {
+		t.Fatalf("ban = %+v, %v; want actor ban of %v", ban, ok, addrs[2])
+	}
+
+	// While the subject is hot, every failing address is banned at once.
+	next := netip.MustParseAddr("192.0.2.4")
+	if ban, ok := fail(d, clk, next, "alice", 1); !ok || ban.Addr != next {
+		t.Fatalf("ban = %+v, %v; want immediate ban of %v", ban, ok, next)
+	}
+	clk.Advance(time.Minute)
+	if _, ok := fail(d, clk, netip.MustParseAddr("192.0.2.5"), "alice", 1); ok {
+		t.Fatal("subject still hot after BanDuration")
+	}
+	if l := b.List(); len(l) != 0 {
+		t.Fatalf("blocker list = %v, want bans expired", l)
+	}
+}
+
+func TestBanDetector_MaxKeys(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	d, _ := newDetector(t, clk, BanConfig{MaxFailures: 2, MaxKeys: 1, Window: time.Minute})
+
+	a, b := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+	fail(d, clk, a, "", 1)
+	if _, ok := fail(d, clk, b, "", 2); ok {
+		t.Fatal("untracked key was banned while the table was full")
+	}
+	clk.Advance(time.Minute)
+	if _, ok := fail(d, clk, b, "", 2); !ok {
+		t.Fatal("expected stale key to be swept and b to be tracked")
+	}
+}
+
+func TestBanDetector_SweepsFullTableRarely(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	d, _ := newDetector(t, clk, BanConfig{MaxFailures: 2, MaxKeys: 2, Window: time.Minute})
+
+	a, b, c := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("192.0.2.3")
+	fail(d, clk, a, "", 1) // T+1s
+	clk.Advance(55 * time.Second)
+	fail(d, clk, b, "", 1) // T+57s
+	fail(d, clk, c, "", 1) // T+58s: full, swept, nothing stale yet
+
+	// a goes stale at T+61s, but the next sweep is not due before T+64s.
+	clk.Advance(2 * time.Second)
+	if _, ok := fail(d, clk, c, "", 2); ok {
+		t.Fatal("full table swept again before a tenth of the window passed")
+	}
+	clk.Advance(2 * time.Second)
+	if _, ok := fail(d, clk, c, "", 2); !ok {
+		t.Fatal("expected a to be swept once the sweep was due and c to be banned")
+	}
+}
+
+func TestNewBanDetector_InvalidAllow(t *testing.T) {
+	b, err := NewIPBlocker(Config{Mode: DenyList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBanDetector(b, BanConfig{Allow: []string{"nope"}}); err == nil {
+		t.Fatal("expected error for invalid allow CIDR")
+	}
+}
+
+func TestNewBanDetector_RequiresDenyList(t *testing.T) {
+	b, err := NewIPBlocker(Config{Mode: AllowList, CIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBanDetector(b, BanConfig{}); err == nil {
+		t.Fatal("expected error for an AllowList blocker")
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/grpc/metadata"
 )
@@ -24,15 +27,33 @@ type Config struct {
 	CIDRs          []string
 	TrustedProxies []string
 	HeaderPriority []string
+
+	// OnChange, if set, is called for every entry added to, removed from or
+	// expired out of the list after construction. It runs synchronously
+	// while the list is locked and must not call back into the blocker.
+	OnChange func(Event)
+
+	// Now returns the current time used to expire temporary entries.
+	// Defaults to time.Now; tests may inject a fake clock.
+	Now func() time.Time
 }
 
 // IPBlocker evaluates whether a client IP is allowed or denied based on the
-// configured Mode and CIDR ranges.
+// configured Mode and CIDR ranges. The ranges can be changed at runtime with
+// [IPBlocker.Add] and [IPBlocker.Remove]; evaluation reads an immutable
+// snapshot of the list and never blocks on writers.
 type IPBlocker struct {
 	mode           Mode
-	cidrs          []netip.Prefix
-	trustedProxies []netip.Prefix
+	trustedProxies *prefixTrie
 	headerPriority []string
+	onChange       func(Event)
+	now            func() time.Time
+
+	entries atomic.Pointer[prefixTrie] // replaced on write
+
+	mu      sync.Mutex // serializes writers
+	timers  map[netip.Prefix]*time.Timer
+	sources map[netip.Prefix]int // number of lists naming a permanent range
 }
 
 // NewIPBlocker creates an IPBlocker from the given Config.  It parses all CIDR
@@ -54,12 +75,25 @@ func NewIPBlocker(cfg Config) (*IPBlocker, error) {
 		hp = defaultHeaderPriority
 	}
 
-	return &IPBlocker{
+	now := cfg.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	b := &IPBlocker{
 		mode:           cfg.Mode,
-		cidrs:          cidrs,
-		trustedProxies: proxies,
+		trustedProxies: newPrefixTrie(proxies),
 		headerPriority: hp,
-	}, nil
+		onChange:       cfg.OnChange,
+		now:            now,
+		timers:         make(map[netip.Prefix]*time.Timer),
+		sources:        make(map[netip.Prefix]int),
+	}
+	for p := range prefixSet(cidrs) {
+		b.sources[p] = 1
+	}
+	b.entries.Store(newPrefixTrie(cidrs))
+	return b, nil
 }
 
 // Evaluate determines whether the request identified by ctx and md is allowed.
@@ -73,7 +107,7 @@ func (b *IPBlocker) Evaluate(ctx context.Context, md metadata.MD) (allowed bool)
 		return false
 	}
 
-	matched := matchesAny(addr, b.cidrs)
+	matched := b.matches(addr)
 
 	switch b.mode {
 	case AllowList:
@@ -85,14 +119,15 @@ func (b *IPBlocker) Evaluate(ctx context.Context, md metadata.MD) (allowed bool)
 	}
 }
 
-// matchesAny reports whether addr is contained in any of the prefixes.
-func matchesAny(addr netip.Addr, prefixes []netip.Prefix) bool {
-	for _, p := range prefixes {
-		if p.Contains(addr) {
-			return true
-		}
-	}
-	return false
+// ClientAddr returns the effective client address of the request described
+// by ctx and md, honouring the blocker's trusted proxies and header priority.
+func (b *IPBlocker) ClientAddr(ctx context.Context, md metadata.MD) (netip.Addr, bool) {
+	return resolveClientAddr(ctx, md, b.trustedProxies, b.headerPriority)
+}
+
+// matches reports whether addr is contained in any unexpired entry.
+func (b *IPBlocker) matches(addr netip.Addr) bool {
+	return b.entries.Load().contains(addr, b.now)
 }
 
 // parsePrefixes parses a slice of CIDR strings into netip.Prefix values.
@@ -101,16 +136,27 @@ func matchesAny(addr netip.Addr, prefixes []netip.Prefix) bool {
 func parsePrefixes(raw []string) ([]netip.Prefix, error) {
 	out := make([]netip.Prefix, 0, len(raw))
 	for _, s := range raw {
-		p, err := netip.ParsePrefix(s)
+		p, err := ParsePrefix(s)
 		if err != nil {
-			// Try as a bare address.
-			addr, addrErr := netip.ParseAddr(s)
-			if addrErr != nil {
-				return nil, fmt.Errorf("%q: %w", s, err)
-			}
-			p = netip.PrefixFrom(addr, addr.BitLen())
+			return nil, err
 		}
 		out = append(out, p)
 	}
 	return out, nil
 }
+
+// ParsePrefix parses a CIDR such as "10.0.0.0/8" or a plain IP address,
+// which is treated as a single-host prefix (/32 for IPv4, /128 for IPv6).
+// The error names the rejected input.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		// Try as a bare address.
+		addr, addrErr := netip.ParseAddr(s)
+		if addrErr != nil {
+			return netip.Prefix{}, fmt.Errorf("%q: %w", s, err)
+		}
+		p = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return p, nil
+}
@@ -2,6 +2,7 @@ package security
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 
 	"google.golang.org/grpc/metadata"
@@ -272,3 +273,60 @@ func TestRealTCPAddr(t *testing.T) {
 		t.Fatal("expected 192.0.2.1 to be denied")
 	}
 }
+
+func TestClientResolver_HonoursTrustedProxy(t *testing.T) {
+	r, err := NewClientResolver([]string{"10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := peer.NewContext(t.Context(), &peer.Peer{
+		Addr: fakePeerAddr{addr: "10.0.0.1:9000"},
+	})
+	md := metadata.Pairs("x-forwarded-for", "198.51.100.5")
+
+	addr, ok := r.ClientAddr(ctx, md)
+	if !ok || addr.String() != "198.51.100.5" {
+		t.Fatalf("got (%v, %v), want 198.51.100.5", addr, ok)
+	}
+
+	peerOnly, ok := PeerAddr(ctx)
+	if !ok || peerOnly.String() != "10.0.0.1" {
+		t.Fatalf("PeerAddr: got (%v, %v), want 10.0.0.1", peerOnly, ok)
+	}
+}
+
+func TestNewClientResolver_InvalidProxy(t *testing.T) {
+	if _, err := NewClientResolver([]string{"nope"}, nil); err == nil {
+		t.Fatal("expected error for invalid trusted proxy")
+	}
+}
+
+func TestIPFilter_Allowed(t *testing.T) {
+	vpn := NewIPFilter(
+		[]netip.Prefix{netip.MustParsePrefix("10.8.0.0/16"), netip.MustParsePrefix("fd00::/8")},
+		[]netip.Prefix{netip.MustParsePrefix("10.8.99.0/24")},
+	)
+	denyOnly := NewIPFilter(nil, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")})
+
+	tests := []struct {
+		name   string
+		filter *IPFilter
+		addr   string
+		want   bool
+	}{
+		{"allowed range", vpn, "10.8.1.2", true},
+		{"allowed v6 range", vpn, "fd00::1", true},
+		{"mapped v4 address", vpn, "::ffff:10.8.1.2", true},
+		{"outside allowed ranges", vpn, "203.0.113.1", false},
+		{"deny wins over allow", vpn, "10.8.99.7", false},
+		{"deny only admits others", denyOnly, "203.0.113.1", true},
+		{"deny only rejects listed", denyOnly, "192.0.2.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Fatalf("Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
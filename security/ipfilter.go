@@ -0,0 +1,32 @@
+package security
+
+import "net/netip"
+
+// IPFilter admits or rejects client addresses by a fixed pair of allow and
+// deny lists, such as the IP rule of a policy group. Unlike [IPBlocker] it
+// does not resolve addresses itself and cannot be changed after
+// construction. It is safe for concurrent use.
+type IPFilter struct {
+	allow *prefixTrie // nil admits every address
+	deny  *prefixTrie
+}
+
+// NewIPFilter creates an IPFilter. An empty allow list admits every address
+// that deny does not reject.
+func NewIPFilter(allow, deny []netip.Prefix) *IPFilter {
+	f := &IPFilter{deny: newPrefixTrie(deny)}
+	if len(allow) > 0 {
+		f.allow = newPrefixTrie(allow)
+	}
+	return f
+}
+
+// Allowed reports whether addr is admitted: it must not be within a denied
+// range and, if there are allowed ranges, must be within one of them.
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if f.deny.contains(addr, nil) {
+		return false
+	}
+	return f.allow == nil || f.allow.contains(addr, nil)
+}
@@ -0,0 +1,210 @@
+package security
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultWatchInterval is used when WatchConfig.Interval is zero.
+const defaultWatchInterval = 5 * time.Second
+
+// ListError reports a line of an IP list that could not be parsed.
+type ListError struct {
+	// Path is the file the list was read from, empty for readers.
+	Path string
+	// Line is the 1-based line number.
+	Line int
+	Err  error
+}
+
+func (e *ListError) Error() string {
+	return fmt.Sprintf("iplist: %s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *ListError) Unwrap() error { return e.Err }
+
+// LoadList reads an IP list from the file at path. See [ParseList] for the
+// accepted formats. A malformed line yields a *[ListError] carrying its line
+// number.
+func LoadList(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("iplist: %w", err)
+	}
+	defer f.Close()
+	ps, err := ParseList(f)
+	var le *ListError
+	if errors.As(err, &le) {
+		le.Path = path
+	}
+	return ps, err
+}
+
+// ParseList reads an IP list from r. It accepts
+//
+//   - one CIDR or plain IP per line, optionally followed by whitespace and
+//     a description;
+//   - FireHOL netsets and similar feeds, where "#" and ";" start comments
+//     that run to the end of the line;
+//   - CSV, where the address is the first field and the first row may be a
+//     header.
+//
+// Blank lines are skipped. Plain IPs become single-host prefixes.
+func ParseList(r io.Reader) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	sc := bufio.NewScanner(r)
+	header := true // the first data row may be a CSV header
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		field, csv := line, false
+		if i := strings.IndexByte(line, ','); i >= 0 {
+			field, csv = strings.Trim(strings.TrimSpace(line[:i]), `"`), true
+		} else if f := strings.Fields(line); len(f) > 0 {
+			field = f[0]
+		}
+		p, err := ParsePrefix(field)
+		if err != nil {
+			if csv && header {
+				header = false
+				continue
+			}
+			return nil, &ListError{Line: n, Err: err}
+		}
+		header = false
+		out = append(out, p)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("iplist: %w", err)
+	}
+	return out, nil
+}
+
+// WatchConfig holds the parameters of a [ListWatcher].
+type WatchConfig struct {
+	// Interval is how often the file is checked for changes. Defaults to
+	// five seconds.
+	Interval time.Duration
+
+	// OnReload, if set, is called after a changed file was applied, with
+	// the number of prefixes it holds.
+	OnReload func(path string, n int)
+
+	// OnError, if set, is called when a changed file cannot be read or
+	// parsed. The blocker keeps the previous list.
+	OnError func(path string, err error)
+}
+
+// ListWatcher keeps the entries loaded from a list file in an [IPBlocker]
+// in sync with the file. Entries added by other means, such as
+// [IPBlocker.Add] or another watcher, are left alone.
+type ListWatcher struct {
+	blocker *IPBlocker
+	path    string
+	cfg     WatchConfig
+
+	mu      sync.Mutex
+	applied []netip.Prefix
+	modTime time.Time
+	size    int64
+}
+
+// NewListWatcher loads the list at path into b and returns a watcher that
+// reloads it on change once [ListWatcher.Run] is started. It returns an
+// error, and leaves b unchanged, if the file cannot be loaded.
+func NewListWatcher(b *IPBlocker, path string, cfg WatchConfig) (*ListWatcher, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultWatchInterval
+	}
+	w := &ListWatcher{blocker: b, path: path, cfg: cfg}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Reload loads the file and swaps its entries into the blocker in one step.
+// On error the blocker keeps the previous list.
+func (w *ListWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return fmt.Errorf("iplist: %w", err)
+	}
+	return w.reload(fi)
+}
+
+// reload is Reload for the file described by fi, with w.mu held.
+func (w *ListWatcher) reload(fi os.FileInfo) error {
+	ps, err := LoadList(w.path)
+	// Remember the failed version too, so that it is reported only once.
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+	if err != nil {
+		return err
+	}
+	w.blocker.replace(w.applied, ps)
+	w.applied = ps
+	return nil
+}
+
+// Run checks the file every Interval and reloads it when its modification
+// time or size changed, until ctx is done. Call it in its own goroutine;
+// the library never starts it automatically.
+func (w *ListWatcher) Run(ctx context.Context) error {
+	t := time.NewTicker(w.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			w.check()
+		}
+	}
+}
+
+// check reloads the file if it changed and reports the outcome.
+func (w *ListWatcher) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		if w.size != -1 {
+			// Report a missing file once, and reload once it is back.
+			w.size = -1
+			w.report(fmt.Errorf("iplist: %w", err))
+		}
+		return
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return
+	}
+	if err := w.reload(fi); err != nil {
+		w.report(err)
+		return
+	}
+	if w.cfg.OnReload != nil {
+		w.cfg.OnReload(w.path, len(w.applied))
+	}
+}
+
+func (w *ListWatcher) report(err error) {
+	if w.cfg.OnError != nil {
+		w.cfg.OnError(w.path, err)
+	}
+}
@@ -0,0 +1,286 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func prefixStrings(t *testing.T, entries []Entry) string {
+	t.Helper()
+	s := make([]string, len(entries))
+	for i, e := range entries {
+		s[i] = e.Prefix.String()
+	}
+	return strings.Join(s, " ")
+}
+
+func TestParseList_Formats(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{
+			name: "plain",
+			in:   "10.0.0.0/8\n\n192.0.2.1\n  2001:db8::/32  \n",
+			want: "[10.0.0.0/8 192.0.2.1/32 2001:db8::/32]",
+		},
+		{
+			name: "comments",
+			in:   "# header\n10.0.0.0/8 # office\n; spamhaus style\n192.0.2.0/24 ; SBL123\n198.51.100.7 scanner\n",
+			want: "[10.0.0.0/8 192.0.2.0/24 198.51.100.7/32]",
+		},
+		{
+			name: "firehol",
+			in:   "#\n# firehol_level1\n#\n# Maintainer      : FireHOL\n#\n0.0.0.0/8\n1.10.16.0/20\n223.254.0.0/16\n",
+			want: "[0.0.0.0/8 1.10.16.0/20 223.254.0.0/16]",
+		},
+		{
+			name: "csv with header",
+			in:   "cidr,reason,added\n\"10.0.0.0/8\",internal,2024-01-01\n192.0.2.1 , scanner,\n",
+			want: "[10.0.0.0/8 192.0.2.1/32]",
+		},
+		{
+			name: "csv without header",
+			in:   "10.0.0.0/8,internal\n192.0.2.1,scanner\n",
+			want: "[10.0.0.0/8 192.0.2.1/32]",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ps, err := ParseList(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(ps); got != tc.want {
+				t.Fatalf("ParseList = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadList_ErrorLine(t *testing.T) {
+	cases := []struct {
+		name, in string
+		line     int
+	}{
+		{"plain", "# list\n10.0.0.0/8\n\n10.0.0.300\n", 4},
+		// Only the first row of a CSV file may be a header.
+		{"csv", "cidr,reason\n10.0.0.0/8,a\nnot-an-ip,b\n", 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "deny.txt")
+			if err := os.WriteFile(path, []byte(tc.in), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadList(path)
+			var le *ListError
+			if !errors.As(err, &le) {
+				t.Fatalf("err = %v, want *ListError", err)
+			}
+			if le.Path != path || le.Line != tc.line {
+				t.Fatalf("ListError = %s:%d, want %s:%d", le.Path, le.Line, path, tc.line)
+			}
+			if !strings.Contains(err.Error(), fmt.Sprintf("deny.txt:%d:", tc.line)) {
+				t.Fatalf("error %q does not name the line", err)
+			}
+		})
+	}
+}
+
+// writeList writes content to path and moves its modification time forward,
+// so that the change is seen even on file systems with coarse timestamps.
+func writeList(t *testing.T, path, content string, mtime *time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	*mtime = mtime.Add(time.Second)
+	if err := os.Chtimes(path, *mtime, *mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListWatcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	mtime := time.Now()
+	writeList(t, path, "10.0.0.0/8\n192.0.2.0/24\n", &mtime)
+
+	b, err := NewIPBlocker(Config{Mode: DenyList, CIDRs: []string{"172.16.0.0/12"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloads []int
+	var errs []error
+	w, err := NewListWatcher(b, path, WatchConfig{
+		OnReload: func(_ string, n int) { reloads = append(reloads, n) },
+		OnError:  func(_ string, err error) { errs = append(errs, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prefixStrings(t, b.List()), "10.0.0.0/8 172.16.0.0/12 192.0.2.0/24"; got != want {
+		t.Fatalf("List = %s, want %s", got, want)
+	}
+
+	// An unchanged file is not reloaded.
+	w.check()
+	if len(reloads) != 0 {
+		t.Fatalf("reloaded an unchanged file: %v", reloads)
+	}
+
+	// A runtime ban survives reloads.
+	if err := b.Add("203.0.113.7", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	writeList(t, path, "10.0.0.0/8\n198.51.100.0/24\n", &mtime)
+	w.check()
+	if got, want := prefixStrings(t, b.List()), "10.0.0.0/8 172.16.0.0/12 198.51.100.0/24 203.0.113.7/32"; got != want {
+		t.Fatalf("List = %s, want %s", got, want)
+	}
+	if fmt.Sprint(reloads) != "[2]" {
+		t.Fatalf("reloads = %v, want [2]", reloads)
+	}
+
+	// A broken file is reported once and the old list stays in place.
+	writeList(t, path, "10.0.0.0/8\nbogus\n", &mtime)
+	w.check()
+	w.check()
+	var le *ListError
+	if len(errs) != 1 || !errors.As(errs[0], &le) || le.Line != 2 {
+		t.Fatalf("errs = %v, want one ListError on line 2", errs)
+	}
+	if got, want := prefixStrings(t, b.List()), "10.0.0.0/8 172.16.0.0/12 198.51.100.0/24 203.0.113.7/32"; got != want {
+		t.Fatalf("List after bad reload = %s, want %s", got, want)
+	}
+
+	// Once fixed, the file is applied again.
+	writeList(t, path, "192.0.2.0/24\n", &mtime)
+	w.check()
+	if got, want := prefixStrings(t, b.List()), "172.16.0.0/12 192.0.2.0/24 203.0.113.7/32"; got != want {
+		t.Fatalf("List = %s, want %s", got, want)
+	}
+}
+
+func TestListWatcher_FileOverridesTemporaryBan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	mtime := time.Now()
+	writeList(t, path, "", &mtime)
+
+	var events []Event
+	b, err := NewIPBlocker(Config{Mode: DenyList, OnChange: func(e Event) { events = append(events, e) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewListWatcher(b, path, WatchConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add("192.0.2.1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	writeList(t, path, "192.0.2.1\n", &mtime)
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got := b.List()
+	if len(got) != 1 || !got[0].Expires.IsZero() {
+		t.Fatalf("List = %+v, want one permanent entry", got)
+	}
+	if n := len(events); n != 2 || events[1].Kind != Added || !events[1].Entry.Expires.IsZero() {
+		t.Fatalf("events = %+v, want the temporary and the permanent add", events)
+	}
+}
+
+func TestListWatcher_OverlappingSources(t *testing.T) {
+	dir := t.TempDir()
+	pathA, pathB := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	mtime := time.Now()
+	writeList(t, pathA, "10.0.0.0/8\n192.0.2.0/24\n198.51.100.0/24\n", &mtime)
+	writeList(t, pathB, "192.0.2.0/24\n", &mtime)
+
+	b, err := NewIPBlocker(Config{Mode: DenyList, CIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa, err := NewListWatcher(b, pathA, WatchConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb, err := NewListWatcher(b, pathB, WatchConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropping ranges from one file keeps those the config or the other
+	// file still lists.
+	writeList(t, pathA, "", &mtime)
+	if err := wa.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prefixStrings(t, b.List()), "10.0.0.0/8 192.0.2.0/24"; got != want {
+		t.Fatalf("List = %s, want %s", got, want)
+	}
+
+	// Once the last file drops a range, it is unblocked.
+	writeList(t, pathB, "", &mtime)
+	if err := wb.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prefixStrings(t, b.List()), "10.0.0.0/8"; got != want {
+		t.Fatalf("List = %s, want %s", got, want)
+	}
+}
+
+func TestNewListWatcher_BadFile(t *testing.T) {
+	b, err := NewIPBlocker(Config{Mode: DenyList, CIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewListWatcher(b, filepath.Join(t.TempDir(), "missing.txt"), WatchConfig{}); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+	if got := prefixStrings(t, b.List()); got != "10.0.0.0/8" {
+		t.Fatalf("List = %s, want the blocker unchanged", got)
+	}
+}
+
+func TestListWatcher_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	mtime := time.Now()
+	writeList(t, path, "10.0.0.0/8\n", &mtime)
+	b, err := NewIPBlocker(Config{Mode: DenyList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan int, 1)
+	w, err := NewListWatcher(b, path, WatchConfig{
+		Interval: 10 * time.Millisecond,
+		OnReload: func(_ string, n int) { reloaded <- n },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	writeList(t, path, "10.0.0.0/8\n192.0.2.0/24\n", &mtime)
+	select {
+	case n := <-reloaded:
+		if n != 2 {
+			t.Fatalf("reloaded %d prefixes, want 2", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("file change was not picked up")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want context.Canceled", err)
+	}
+}
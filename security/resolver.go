@@ -2,6 +2,7 @@ package security
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/netip"
 	"strings"
@@ -14,6 +15,40 @@ import (
 // the caller does not provide an explicit HeaderPriority.
 var defaultHeaderPriority = []string{"x-real-ip", "x-forwarded-for"}
 
+// ClientResolver determines the effective client address of a request using
+// the same rules as [IPBlocker]: the peer address, or — when the peer is a
+// trusted proxy — the first valid IP from the configured metadata headers.
+type ClientResolver struct {
+	trustedProxies *prefixTrie
+	headerPriority []string
+}
+
+// NewClientResolver creates a ClientResolver. An empty headerPriority uses
+// the default order ("x-real-ip", "x-forwarded-for"). It returns an error if
+// any trusted proxy entry is invalid.
+func NewClientResolver(trustedProxies, headerPriority []string) (*ClientResolver, error) {
+	proxies, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("security: invalid trusted proxy: %w", err)
+	}
+	if len(headerPriority) == 0 {
+		headerPriority = defaultHeaderPriority
+	}
+	return &ClientResolver{trustedProxies: newPrefixTrie(proxies), headerPriority: headerPriority}, nil
+}
+
+// ClientAddr returns the effective client address of the request described
+// by ctx and md. ok is false when no peer information is available.
+func (r *ClientResolver) ClientAddr(ctx context.Context, md metadata.MD) (netip.Addr, bool) {
+	return resolveClientAddr(ctx, md, r.trustedProxies, r.headerPriority)
+}
+
+// PeerAddr returns the IP address of the directly connected peer, ignoring
+// any forwarding headers.
+func PeerAddr(ctx context.Context) (netip.Addr, bool) {
+	return peerAddrFromContext(ctx)
+}
+
 // resolveClientAddr determines the effective client address from the gRPC
 // context and metadata.
 //
@@ -21,13 +56,13 @@ var defaultHeaderPriority = []string{"x-real-ip", "x-forwarded-for"}
 // is within trustedProxies, the function walks headerPriority in order and
 // returns the first valid IP found in the metadata.  Otherwise (or when no
 // valid header IP is found) it returns the peer address itself.
-func resolveClientAddr(ctx context.Context, md metadata.MD, trustedProxies []netip.Prefix, headerPriority []string) (netip.Addr, bool) {
+func resolveClientAddr(ctx context.Context, md metadata.MD, trustedProxies *prefixTrie, headerPriority []string) (netip.Addr, bool) {
 	peerAddr, ok := peerAddrFromContext(ctx)
 	if !ok {
 		return netip.Addr{}, false
 	}
 
-	if isTrustedProxy(peerAddr, trustedProxies) {
+	if trustedProxies.contains(peerAddr, nil) {
 		if addr, found := addrFromHeaders(md, headerPriority); found {
 			return addr, true
 		}
@@ -47,7 +82,19 @@ func peerAddrFromContext(ctx context.Context) (netip.Addr, bool) {
 }
 
 // addrFromNetAddr parses a net.Addr into a netip.Addr, stripping any port.
+// TCP addresses are converted without formatting them, so that resolving
+// the peer of a request does not allocate.
 func addrFromNetAddr(addr net.Addr) (netip.Addr, bool) {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, ok := netip.AddrFromSlice(tcp.IP)
+		if !ok {
+			return netip.Addr{}, false
+		}
+		// net.IP stores IPv4 addresses in 16 bytes; String() prints them
+		// in dotted form, so unmap to match the parsed result.
+		return ip.Unmap().WithZone(tcp.Zone), true
+	}
+
 	addrStr := addr.String()
 
 	// Try parsing as host:port first.
@@ -62,16 +109,6 @@ func addrFromNetAddr(addr net.Addr) (netip.Addr, bool) {
 	return ip, true
 }
 
-// isTrustedProxy reports whether addr falls within any of the given prefixes.
-func isTrustedProxy(addr netip.Addr, prefixes []netip.Prefix) bool {
-	for _, p := range prefixes {
-		if p.Contains(addr) {
-			return true
-		}
-	}
-	return false
-}
-
 // addrFromHeaders walks the header keys in priority order and returns the
 // first valid IP address found.  For multi-value headers such as
 // X-Forwarded-For the left-most (client) entry is used.
@@ -0,0 +1,229 @@
+package security
+
+import (
+	"math/bits"
+	"net/netip"
+	"time"
+)
+
+// prefixTrie is an immutable set of IPv4 and IPv6 prefixes stored in two
+// path-compressed binary (Patricia) tries. Lookups visit at most one node per
+// distinct prefix length on the path to the address, so they take
+// O(address length) regardless of how many prefixes are stored, and they
+// never allocate. Updates copy only the nodes on the path to the changed
+// prefix, so readers of an older trie are never disturbed.
+type prefixTrie struct {
+	v4, v6 *trieNode
+	n      int
+}
+
+// trieNode is a node of a prefixTrie. Nodes without an entry only branch.
+type trieNode struct {
+	prefix  netip.Prefix // masked
+	has     bool
+	expires time.Time // zero for permanent entries
+	child   [2]*trieNode
+}
+
+// newPrefixTrie returns a trie holding the permanent prefixes ps.
+func newPrefixTrie(ps []netip.Prefix) *prefixTrie {
+	t := &prefixTrie{}
+	for _, p := range ps {
+		t = t.insert(p, time.Time{})
+	}
+	return t
+}
+
+// len returns the number of prefixes in t.
+func (t *prefixTrie) len() int { return t.n }
+
+// root returns the root of the IPv4 or the IPv6 trie.
+func (t *prefixTrie) root(is4 bool) *trieNode {
+	if is4 {
+		return t.v4
+	}
+	return t.v6
+}
+
+// withRoot returns a copy of t with the root of a family replaced.
+func (t *prefixTrie) withRoot(is4 bool, r *trieNode, n int) *prefixTrie {
+	c := *t
+	if is4 {
+		c.v4 = r
+	} else {
+		c.v6 = r
+	}
+	c.n = n
+	return &c
+}
+
+// lookup returns the shortest prefix in t that contains addr and has not
+// expired at the time returned by now. now is only called for temporary
+// entries.
+func (t *prefixTrie) lookup(addr netip.Addr, now func() time.Time) (netip.Prefix, bool) {
+	n := t.root(addr.Is4())
+	for n != nil && n.prefix.Contains(addr) {
+		if n.has && (n.expires.IsZero() || now().Before(n.expires)) {
+			return n.prefix, true
+		}
+		if n.prefix.Bits() == addr.BitLen() {
+			break
+		}
+		n = n.child[bitAt(addr, n.prefix.Bits())]
+	}
+	return netip.Prefix{}, false
+}
+
+// contains reports whether any permanent or unexpired prefix in t contains
+// addr.
+func (t *prefixTrie) contains(addr netip.Addr, now func() time.Time) bool {
+	_, ok := t.lookup(addr, now)
+	return ok
+}
+
+// get returns the entry of exactly p.
+func (t *prefixTrie) get(p netip.Prefix) (Entry, bool) {
+	p = p.Masked()
+	n := t.root(p.Addr().Is4())
+	for n != nil && n.prefix.Bits() <= p.Bits() && n.prefix.Contains(p.Addr()) {
+		if n.prefix.Bits() == p.Bits() {
+			return Entry{Prefix: n.prefix, Expires: n.expires}, n.has
+		}
+		n = n.child[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+	return Entry{}, false
+}
+
+// insert returns a trie with p added, replacing the expiry of p if it is
+// already present.
+func (t *prefixTrie) insert(p netip.Prefix, expires time.Time) *prefixTrie {
+	p = p.Masked()
+	is4 := p.Addr().Is4()
+	r, added := insertNode(t.root(is4), p, expires)
+	n := t.n
+	if added {
+		n++
+	}
+	return t.withRoot(is4, r, n)
+}
+
+// insertNode returns a copy of the subtree n with p added, and whether p is
+// new.
+func insertNode(n *trieNode, p netip.Prefix, expires time.Time) (*trieNode, bool) {
+	if n == nil {
+		return &trieNode{prefix: p, has: true, expires: expires}, true
+	}
+	common := min(commonBits(n.prefix.Addr(), p.Addr()), n.prefix.Bits(), p.Bits())
+	switch {
+	case common == n.prefix.Bits() && common == p.Bits():
+		c := *n
+		c.has, c.expires = true, expires
+		return &c, !n.has
+	case common == n.prefix.Bits():
+		c := *n
+		b := bitAt(p.Addr(), common)
+		var added bool
+		c.child[b], added = insertNode(n.child[b], p, expires)
+		return &c, added
+	case common == p.Bits():
+		c := &trieNode{prefix: p, has: true, expires: expires}
+		c.child[bitAt(n.prefix.Addr(), common)] = n
+		return c, true
+	default:
+		c := &trieNode{prefix: netip.PrefixFrom(p.Addr(), common).Masked()}
+		c.child[bitAt(n.prefix.Addr(), common)] = n
+		c.child[bitAt(p.Addr(), common)] = &trieNode{prefix: p, has: true, expires: expires}
+		return c, true
+	}
+}
+
+// remove returns a trie without p and the removed entry, or t itself when
+// p is not present.
+func (t *prefixTrie) remove(p netip.Prefix) (*prefixTrie, Entry, bool) {
+	p = p.Masked()
+	is4 := p.Addr().Is4()
+	r, e, ok := removeNode(t.root(is4), p)
+	if !ok {
+		return t, Entry{}, false
+	}
+	return t.withRoot(is4, r, t.n-1), e, true
+}
+
+// removeNode returns a copy of the subtree n without p. Nodes left without
+// an entry and with fewer than two children are collapsed.
+func removeNode(n *trieNode, p netip.Prefix) (*trieNode, Entry, bool) {
+	if n == nil || n.prefix.Bits() > p.Bits() || !n.prefix.Contains(p.Addr()) {
+		return n, Entry{}, false
+	}
+	c := *n
+	var e Entry
+	if n.prefix.Bits() == p.Bits() {
+		if !n.has {
+			return n, Entry{}, false
+		}
+		e = Entry{Prefix: n.prefix, Expires: n.expires}
+		c.has, c.expires = false, time.Time{}
+	} else {
+		b := bitAt(p.Addr(), n.prefix.Bits())
+		var ok bool
+		if c.child[b], e, ok = removeNode(n.child[b], p); !ok {
+			return n, Entry{}, false
+		}
+	}
+	if c.has {
+		return &c, e, true
+	}
+	switch {
+	case c.child[0] == nil:
+		return c.child[1], e, true
+	case c.child[1] == nil:
+		return c.child[0], e, true
+	}
+	return &c, e, true
+}
+
+// entries returns the entries of t, IPv4 before IPv6 and each family in
+// address order with shorter prefixes first.
+func (t *prefixTrie) entries() []Entry {
+	out := make([]Entry, 0, t.n)
+	out = appendEntries(out, t.v4)
+	return appendEntries(out, t.v6)
+}
+
+func appendEntries(out []Entry, n *trieNode) []Entry {
+	if n == nil {
+		return out
+	}
+	if n.has {
+		out = append(out, Entry{Prefix: n.prefix, Expires: n.expires})
+	}
+	out = appendEntries(out, n.child[0])
+	return appendEntries(out, n.child[1])
+}
+
+// bitAt returns bit i, counted from the most significant bit, of addr.
+func bitAt(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		i += 96
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits returns the length of the longest common prefix of two
+// addresses of the same family.
+func commonBits(a, b netip.Addr) int {
+	x, y := a.As16(), b.As16()
+	n := 0
+	for i := range x {
+		if d := x[i] ^ y[i]; d != 0 {
+			n += bits.LeadingZeros8(d)
+			break
+		}
+		n += 8
+	}
+	if a.Is4() {
+		n -= 96
+	}
+	return n
+}
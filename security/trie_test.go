@@ -0,0 +1,246 @@
+package security
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+// randomPrefixes returns n random prefixes of the given family, with
+// lengths spread over the whole range so that they nest and overlap.
+func randomPrefixes(rng *rand.Rand, n int, v6 bool) []netip.Prefix {
+	out := make([]netip.Prefix, n)
+	for i := range out {
+		var addr netip.Addr
+		if v6 {
+			var b [16]byte
+			rng.Read(b[:])
+			addr = netip.AddrFrom16(b)
+		} else {
+			var b [4]byte
+			rng.Read(b[:])
+			addr = netip.AddrFrom4(b)
+		}
+		out[i] = netip.PrefixFrom(addr, rng.Intn(addr.BitLen()+1)).Masked()
+	}
+	return out
+}
+
+func randomAddr(rng *rand.Rand, v6 bool) netip.Addr {
+	if v6 {
+		var b [16]byte
+		rng.Read(b[:])
+		return netip.AddrFrom16(b)
+	}
+	var b [4]byte
+	rng.Read(b[:])
+	return netip.AddrFrom4(b)
+}
+
+// linearLookup is the reference the trie is checked against.
+func linearLookup(ps []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range ps {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPrefixTrie_MatchesLinearScan(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		rng := rand.New(rand.NewSource(1))
+		// Short prefixes would cover nearly everything; bias towards
+		// longer ones by concatenating with narrow /16+ prefixes.
+		ps := randomPrefixes(rng, 2000, v6)
+		for i, p := range ps {
+			if p.Bits() < 8 {
+				ps[i] = netip.PrefixFrom(p.Addr(), 8+i%(p.Addr().BitLen()-8)).Masked()
+			}
+		}
+		trie := newPrefixTrie(ps)
+		if trie.len() > len(ps) {
+			t.Fatalf("len = %d, want at most %d", trie.len(), len(ps))
+		}
+
+		for range 20_000 {
+			addr := randomAddr(rng, v6)
+			if got, want := trie.contains(addr, nil), linearLookup(ps, addr); got != want {
+				t.Fatalf("contains(%v) = %v, want %v", addr, got, want)
+			}
+		}
+		// Every prefix contains its own address.
+		for _, p := range ps {
+			if !trie.contains(p.Addr(), nil) {
+				t.Fatalf("contains(%v) = false for listed %v", p.Addr(), p)
+			}
+		}
+	}
+}
+
+func TestPrefixTrie_RemoveKeepsOldVersions(t *testing.T) {
+	ps := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("10.1.2.0/24"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	full := newPrefixTrie(ps)
+
+	without, e, ok := full.remove(netip.MustParsePrefix("10.0.0.0/8"))
+	if !ok || e.Prefix != ps[0] {
+		t.Fatalf("remove = %+v, %v; want 10.0.0.0/8", e, ok)
+	}
+	if _, _, ok := without.remove(netip.MustParsePrefix("10.0.0.0/8")); ok {
+		t.Fatal("removed 10.0.0.0/8 twice")
+	}
+	if _, _, ok := without.remove(netip.MustParsePrefix("10.2.0.0/16")); ok {
+		t.Fatal("removed a prefix that was never added")
+	}
+
+	addr := netip.MustParseAddr("10.9.9.9")
+	if !full.contains(addr, nil) {
+		t.Fatal("old version lost 10.0.0.0/8")
+	}
+	if without.contains(addr, nil) {
+		t.Fatal("new version still matches 10.0.0.0/8")
+	}
+	if !without.contains(netip.MustParseAddr("10.1.2.3"), nil) {
+		t.Fatal("new version lost the nested prefixes")
+	}
+
+	var got []string
+	for _, e := range without.entries() {
+		got = append(got, e.Prefix.String())
+	}
+	want := "[10.1.0.0/16 10.1.2.0/24 192.168.0.0/16 2001:db8::/32]"
+	if fmt.Sprint(got) != want || without.len() != 4 {
+		t.Fatalf("entries = %v (len %d), want %s", got, without.len(), want)
+	}
+
+	empty := without
+	for _, p := range ps[1:] {
+		empty, _, _ = empty.remove(p)
+	}
+	if empty.len() != 0 || empty.v4 != nil || empty.v6 != nil {
+		t.Fatalf("trie not empty after removing everything: %+v", empty)
+	}
+}
+
+func TestPrefixTrie_Expiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+	trie := newPrefixTrie([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	trie = trie.insert(netip.MustParsePrefix("192.0.2.0/24"), now.Add(time.Minute))
+	// A live nested prefix still matches below an expired covering one.
+	trie = trie.insert(netip.MustParsePrefix("198.51.0.0/16"), now.Add(time.Second))
+	trie = trie.insert(netip.MustParsePrefix("198.51.100.0/24"), time.Time{})
+
+	if !trie.contains(netip.MustParseAddr("192.0.2.1"), clock) {
+		t.Fatal("temporary prefix must match before its expiry")
+	}
+	now = now.Add(time.Minute)
+	if trie.contains(netip.MustParseAddr("192.0.2.1"), clock) {
+		t.Fatal("temporary prefix must not match after its expiry")
+	}
+	if !trie.contains(netip.MustParseAddr("198.51.100.7"), clock) {
+		t.Fatal("permanent nested prefix must match below an expired one")
+	}
+	if e, ok := trie.get(netip.MustParsePrefix("192.0.2.0/24")); !ok || e.Expires.IsZero() {
+		t.Fatalf("get = %+v, %v; want the temporary entry", e, ok)
+	}
+}
+
+func TestPrefixTrie_LookupDoesNotAllocate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	trie := newPrefixTrie(randomPrefixes(rng, 10_000, false))
+	addr := randomAddr(rng, false)
+	if n := testing.AllocsPerRun(100, func() { trie.contains(addr, nil) }); n != 0 {
+		t.Fatalf("lookup allocates %v times, want 0", n)
+	}
+}
+
+func TestIPBlocker_EvaluateDoesNotAllocate(t *testing.T) {
+	b, err := NewIPBlocker(Config{Mode: DenyList, CIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := peer.NewContext(t.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}})
+	if n := testing.AllocsPerRun(100, func() { b.Evaluate(ctx, nil) }); n != 0 {
+		t.Fatalf("Evaluate allocates %v times, want 0", n)
+	}
+}
+
+// BenchmarkPrefixTrie_Lookup shows that lookups take the same time for ten
+// and for 200k prefixes.
+func BenchmarkPrefixTrie_Lookup(b *testing.B) {
+	for _, v6 := range []bool{false, true} {
+		family := "v4"
+		if v6 {
+			family = "v6"
+		}
+		for _, n := range []int{10, 1_000, 200_000} {
+			b.Run(fmt.Sprintf("%s/%d", family, n), func(b *testing.B) {
+				rng := rand.New(rand.NewSource(1))
+				ps := randomPrefixes(rng, n, v6)
+				for i, p := range ps {
+					// Feed-like lengths: hosts and small networks.
+					bits := p.Addr().BitLen()
+					ps[i] = netip.PrefixFrom(p.Addr(), bits-rng.Intn(bits/4)).Masked()
+				}
+				trie := newPrefixTrie(ps)
+				addrs := make([]netip.Addr, 1024)
+				for i := range addrs {
+					addrs[i] = randomAddr(rng, v6)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := range b.N {
+					trie.contains(addrs[i%len(addrs)], nil)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkLinearLookup is the linear scan the trie replaces.
+func BenchmarkLinearLookup(b *testing.B) {
+	for _, n := range []int{10, 1_000, 200_000} {
+		b.Run(fmt.Sprintf("v4/%d", n), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			ps := randomPrefixes(rng, n, false)
+			for i, p := range ps {
+				ps[i] = netip.PrefixFrom(p.Addr(), 32-rng.Intn(8)).Masked()
+			}
+			addr := randomAddr(rng, false)
+			b.ResetTimer()
+			for range b.N {
+				linearLookup(ps, addr)
+			}
+		})
+	}
+}
+
+func BenchmarkIPBlocker_Evaluate(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	cidrs := make([]string, 200_000)
+	for i := range cidrs {
+		cidrs[i] = netip.PrefixFrom(randomAddr(rng, false), 24+rng.Intn(9)).Masked().String()
+	}
+	blocker, err := NewIPBlocker(Config{Mode: DenyList, CIDRs: cidrs})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := peer.NewContext(b.Context(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		blocker.Evaluate(ctx, nil)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/Keksclan/goRawrSquirrel/interceptors"
 	"github.com/Keksclan/goRawrSquirrel/internal/core"
 	"github.com/Keksclan/goRawrSquirrel/ping"
+	"github.com/Keksclan/goRawrSquirrel/quota"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 )
@@ -44,6 +46,9 @@ func NewServer(opts ...Option) *Server {
 	for _, o := range opts {
 		o(&cfg)
 	}
+	for _, fn := range cfg.deferred {
+		fn(&cfg)
+	}
 
 	// When both L1 and L2 are configured, combine them into a tiered cache.
 	if cfg.l1 != nil && cfg.l2 != nil {
@@ -71,6 +76,20 @@ func (s *Server) Cache() cache.Cache {
 	return s.cache
 }
 
+// Quotas returns the tenant quotas configured via [WithQuota], for example
+// to report remaining quota with [quota.Quotas.Usage]. It returns nil if no
+// quota was configured.
+func (s *Server) Quotas() *quota.Quotas {
+	return s.cfg.quotas
+}
+
+// WarmUp returns the warm-up ramp configured via [WithWarmUp], for example
+// to report its progress with [warmup.Ramp.Status] or to restart it right
+// before serving. It returns nil if no warm-up was configured.
+func (s *Server) WarmUp() *warmup.Ramp {
+	return s.cfg.warmUp
+}
+
 // RegisterPing registers the built-in rawr.Ping health-check service on the
 // underlying gRPC server using the supplied [ping.Handler]. If h is nil and
 // FunMode is enabled (via [WithFunMode]), a fun handler is used; otherwise
@@ -4,8 +4,12 @@ import (
 	"context"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/Keksclan/goRawrSquirrel/interceptors"
+	"github.com/Keksclan/goRawrSquirrel/quota"
+	"github.com/Keksclan/goRawrSquirrel/warmup"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 )
 
@@ -31,6 +35,27 @@ func TestMetricsHandlerImplementsHTTPHandler(t *testing.T) {
 	}
 }
 
+func TestWarmUpGaugeFollowsLatestServer(t *testing.T) {
+	frozen := func() time.Time { return time.Unix(0, 0) }
+	NewServer(WithWarmUp(warmup.Config{Duration: time.Hour, From: 0.5, Now: frozen}))
+	NewServer(WithWarmUp(warmup.Config{Duration: time.Hour, From: 0.2, Now: frozen}))
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "rawr_warmup_factor" {
+			continue
+		}
+		if got := mf.GetMetric()[0].GetGauge().GetValue(); got != 0.2 {
+			t.Fatalf("rawr_warmup_factor = %v, want 0.2", got)
+		}
+		return
+	}
+	t.Fatal("rawr_warmup_factor not registered")
+}
+
 // makeUnaryInterceptor returns a unary interceptor that appends tag to the log slice.
 func makeUnaryInterceptor(tag string, log *[]string) grpc.UnaryServerInterceptor {
 	return func(
@@ -164,3 +189,19 @@ func TestOptionFunc(t *testing.T) {
 		_ = c
 	}
 }
+
+func TestQuotasRequireRedis(t *testing.T) {
+	cfg := quota.Config{Plans: map[string]quota.Plan{"default": {}}}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("NewServer must panic for WithQuota without WithCacheRedis")
+			}
+		}()
+		NewServer(WithQuota(cfg))
+	}()
+	s := NewServer(WithQuota(cfg), WithCacheRedis("localhost:1", "", 0))
+	if s.Quotas() == nil {
+		t.Fatal("Quotas() returned nil with WithCacheRedis")
+	}
+}
@@ -0,0 +1,74 @@
+// Package tenant enforces tenant isolation: a caller may only address
+// resources that belong to the tenant recorded in its [contextx.Actor],
+// unless it holds one of the configured cross-tenant admin scopes.
+package tenant
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Target extracts the tenant a request addresses according to rule. The
+// metadata key is consulted first, then the request field. ok is false when
+// neither yields a value. conflict is true when both are present but differ.
+func Target(rule *policy.TenantRule, md metadata.MD, msg any) (target string, ok, conflict bool) {
+	if rule.Metadata != "" {
+		if vals := md.Get(rule.Metadata); len(vals) > 0 && vals[0] != "" {
+			target, ok = vals[0], true
+		}
+	}
+	if rule.Field != "" {
+		if m, isProto := msg.(proto.Message); isProto {
+			if v, found := FieldValue(m, rule.Field); found {
+				if ok && v != target {
+					return target, true, true
+				}
+				target, ok = v, true
+			}
+		}
+	}
+	return target, ok, false
+}
+
+// FieldValue returns the non-empty string at the dot-separated field path
+// inside msg. Intermediate fields must be singular messages; the final field
+// must be a string.
+func FieldValue(msg proto.Message, path string) (string, bool) {
+	m := msg.ProtoReflect()
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil || fd.IsList() || fd.IsMap() {
+			return "", false
+		}
+		if i == len(names)-1 {
+			if fd.Kind() != protoreflect.StringKind {
+				return "", false
+			}
+			v := m.Get(fd).String()
+			return v, v != ""
+		}
+		if fd.Kind() != protoreflect.MessageKind || !m.Has(fd) {
+			return "", false
+		}
+		m = m.Get(fd).Message()
+	}
+	return "", false
+}
+
+// Allowed reports whether actor may access target. Actors holding any of
+// rule.AdminScopes may access every tenant.
+func Allowed(rule *policy.TenantRule, actor contextx.Actor, target string) bool {
+	for _, s := range rule.AdminScopes {
+		if slices.Contains(actor.Scopes, s) {
+			return true
+		}
+	}
+	return actor.Tenant != "" && actor.Tenant == target
+}
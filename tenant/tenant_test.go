@@ -0,0 +1,79 @@
+package tenant
+
+import (
+	"testing"
+
+	"github.com/Keksclan/goRawrSquirrel/contextx"
+	"github.com/Keksclan/goRawrSquirrel/policy"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// msg returns a message with a top-level ("name") and a nested
+// ("options.go_package") string field for path tests.
+func msg(name, goPackage string) *descriptorpb.FileDescriptorProto {
+	m := &descriptorpb.FileDescriptorProto{Name: proto.String(name)}
+	if goPackage != "" {
+		m.Options = &descriptorpb.FileOptions{GoPackage: proto.String(goPackage)}
+	}
+	return m
+}
+
+func TestFieldValue(t *testing.T) {
+	tests := []struct {
+		path   string
+		m      proto.Message
+		want   string
+		wantOK bool
+	}{
+		{"name", msg("acme", ""), "acme", true},
+		{"options.go_package", msg("x", "globex"), "globex", true},
+		{"options.go_package", msg("x", ""), "", false},
+		{"missing", msg("acme", ""), "", false},
+		{"dependency", msg("acme", ""), "", false}, // repeated field
+		{"options", msg("x", "globex"), "", false}, // not a string
+	}
+	for _, tt := range tests {
+		got, ok := FieldValue(tt.m, tt.path)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("FieldValue(%q) = (%q, %v), want (%q, %v)", tt.path, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestTarget_MetadataAndFieldConflict(t *testing.T) {
+	rule := &policy.TenantRule{Metadata: "x-tenant-id", Field: "name"}
+
+	target, ok, conflict := Target(rule, metadata.Pairs("x-tenant-id", "acme"), msg("acme", ""))
+	if target != "acme" || !ok || conflict {
+		t.Fatalf("got (%q, %v, %v), want (acme, true, false)", target, ok, conflict)
+	}
+
+	_, _, conflict = Target(rule, metadata.Pairs("x-tenant-id", "acme"), msg("globex", ""))
+	if !conflict {
+		t.Fatal("expected conflict between metadata and field")
+	}
+
+	_, ok, _ = Target(rule, nil, nil)
+	if ok {
+		t.Fatal("expected no target")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	rule := &policy.TenantRule{AdminScopes: []string{"tenants:any"}}
+
+	if !Allowed(rule, contextx.Actor{Tenant: "acme"}, "acme") {
+		t.Fatal("same tenant should be allowed")
+	}
+	if Allowed(rule, contextx.Actor{Tenant: "acme"}, "globex") {
+		t.Fatal("other tenant should be denied")
+	}
+	if Allowed(rule, contextx.Actor{}, "") {
+		t.Fatal("actor without tenant must not match an empty target")
+	}
+	if !Allowed(rule, contextx.Actor{Tenant: "acme", Scopes: []string{"tenants:any"}}, "globex") {
+		t.Fatal("admin scope should allow cross-tenant access")
+	}
+}
@@ -0,0 +1,137 @@
+// Package warmup provides a slow-start ramp: a capacity factor that grows
+// from a fraction to 1 over a fixed duration after startup, so that a fresh
+// replica with cold caches is not flooded with its full share of traffic.
+//
+// A [Ramp] only computes the factor; rate and concurrency limiters read it
+// on every request and scale their capacity accordingly.
+package warmup
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults applied by [New] when the corresponding Config field is zero.
+const (
+	defaultFrom = 0.1
+
+	// steps is the number of distinct factors a ramp passes through, so
+	// that limiters are rescaled at most that many times.
+	steps = 100
+)
+
+// Curve is the shape of a ramp.
+type Curve int
+
+const (
+	// Linear raises the factor by the same amount per unit of time.
+	Linear Curve = iota
+	// Exponential multiplies the factor by the same amount per unit of
+	// time: capacity doubles at a steady pace, like TCP slow start, and
+	// stays low for longer than on a linear ramp.
+	Exponential
+)
+
+// String returns "linear" or "exponential".
+func (c Curve) String() string {
+	if c == Exponential {
+		return "exponential"
+	}
+	return "linear"
+}
+
+// Config holds the parameters of a [Ramp].
+type Config struct {
+	// Duration is the time it takes to reach full capacity. Zero disables
+	// the ramp: the factor is always 1.
+	Duration time.Duration
+
+	// From is the fraction of capacity available at the start, in (0, 1].
+	// Defaults to 0.1.
+	From float64
+
+	// Curve is the shape of the ramp. Defaults to Linear.
+	Curve Curve
+
+	// Now returns the current time. Defaults to time.Now; tests may inject
+	// a fake clock.
+	Now func() time.Time
+}
+
+// Status describes the progress of a ramp.
+type Status struct {
+	// Factor is the current fraction of capacity, in [From, 1].
+	Factor float64
+	// Curve is the shape of the ramp.
+	Curve Curve
+	// Started is when the ramp was started or last restarted.
+	Started time.Time
+	// Remaining is the time until full capacity, zero once reached.
+	Remaining time.Duration
+}
+
+// Ramp computes the capacity factor of a warm-up period. All methods are
+// safe for concurrent use.
+type Ramp struct {
+	cfg   Config
+	start atomic.Int64 // unix nanoseconds
+}
+
+// New creates a Ramp with the given configuration and starts it.
+func New(cfg Config) *Ramp {
+	if cfg.From <= 0 || cfg.From > 1 {
+		cfg.From = defaultFrom
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	r := &Ramp{cfg: cfg}
+	r.Restart()
+	return r
+}
+
+// Restart starts the ramp over, e.g. right before the server begins
+// serving if setup after NewServer took a while.
+func (r *Ramp) Restart() {
+	r.start.Store(r.cfg.Now().UnixNano())
+}
+
+// Factor returns the current fraction of capacity. It moves in steps of a
+// hundredth of the ramp so that limiters need not be rescaled on every
+// request. A nil Ramp always returns 1.
+func (r *Ramp) Factor() float64 {
+	if r == nil {
+		return 1
+	}
+	return r.Status().Factor
+}
+
+// Status returns the progress of the ramp.
+func (r *Ramp) Status() Status {
+	start := time.Unix(0, r.start.Load())
+	s := Status{Factor: 1, Curve: r.cfg.Curve, Started: start}
+	elapsed := r.cfg.Now().Sub(start)
+	if r.cfg.Duration <= 0 || elapsed >= r.cfg.Duration {
+		return s
+	}
+	s.Remaining = r.cfg.Duration - elapsed
+	// Progress is quantized before it is shaped, so both curves pass
+	// through the same number of steps.
+	p := math.Floor(max(float64(elapsed), 0)/float64(r.cfg.Duration)*steps) / steps
+	from := r.cfg.From
+	if r.cfg.Curve == Exponential {
+		s.Factor = from * math.Pow(1/from, p)
+	} else {
+		s.Factor = from + (1-from)*p
+	}
+	return s
+}
+
+// Scale returns n scaled by f, rounded up and at least 1.
+func Scale(n int, f float64) int {
+	if f >= 1 {
+		return n
+	}
+	return max(1, int(math.Ceil(float64(n)*f)))
+}
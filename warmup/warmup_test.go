@@ -0,0 +1,101 @@
+package warmup
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestRamp_Linear(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	r := New(Config{Duration: 100 * time.Second, From: 0.2, Now: clk.Now})
+
+	for _, tc := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{0, 0.2},
+		{50 * time.Second, 0.6},
+		{50*time.Second + 500*time.Millisecond, 0.6}, // between steps
+		{75 * time.Second, 0.8},
+		{100 * time.Second, 1},
+		{time.Hour, 1},
+	} {
+		clk.t = time.Unix(0, 0).Add(tc.at)
+		if got := r.Factor(); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("factor at %v = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestRamp_Exponential(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	r := New(Config{Duration: 100 * time.Second, From: 0.01, Curve: Exponential, Now: clk.Now})
+
+	for _, tc := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{0, 0.01},
+		{50 * time.Second, 0.1},
+		{100 * time.Second, 1},
+	} {
+		clk.t = time.Unix(0, 0).Add(tc.at)
+		if got := r.Factor(); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("factor at %v = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestRamp_StatusAndRestart(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(0, 0)}
+	r := New(Config{Duration: time.Minute, Now: clk.Now})
+
+	clk.Advance(time.Minute)
+	if s := r.Status(); s.Factor != 1 || s.Remaining != 0 {
+		t.Fatalf("after the ramp: %+v", s)
+	}
+
+	r.Restart()
+	clk.Advance(15 * time.Second)
+	s := r.Status()
+	if s.Remaining != 45*time.Second || !s.Started.Equal(time.Unix(60, 0)) {
+		t.Fatalf("after restart: %+v", s)
+	}
+	if s.Factor != defaultFrom+(1-defaultFrom)*0.25 {
+		t.Fatalf("factor = %v", s.Factor)
+	}
+}
+
+func TestRamp_Disabled(t *testing.T) {
+	var nilRamp *Ramp
+	if nilRamp.Factor() != 1 {
+		t.Fatal("nil ramp must report full capacity")
+	}
+	if f := New(Config{}).Factor(); f != 1 {
+		t.Fatalf("zero duration: factor = %v, want 1", f)
+	}
+}
+
+func TestScale(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		f    float64
+		want int
+	}{
+		{100, 1, 100},
+		{100, 0.25, 25},
+		{10, 0.15, 2},
+		{3, 0.01, 1},
+	} {
+		if got := Scale(tc.n, tc.f); got != tc.want {
+			t.Errorf("Scale(%d, %v) = %d, want %d", tc.n, tc.f, got, tc.want)
+		}
+	}
+}